@@ -0,0 +1,332 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+type EventKind string
+
+const (
+	Firing   EventKind = "firing"
+	Resolved EventKind = "resolved"
+)
+
+// SensorValue is one sensor's value of a rule's metric
+type SensorValue struct {
+	SensorIndex int     `json:"sensor_index"`
+	Name        string  `json:"name,omitempty"`
+	Value       float64 `json:"value"`
+}
+
+// Event is a rule firing or clearing
+type Event struct {
+	Rule      string        `json:"rule"`
+	Kind      EventKind     `json:"kind"`
+	Time      time.Time     `json:"time"`
+	Metric    string        `json:"metric"`
+	Aggregate string        `json:"aggregate"`
+	Op        string        `json:"op"`
+	Threshold float64       `json:"threshold"`
+	Value     float64       `json:"value"`
+	Category  string        `json:"category,omitempty"` // the AQI category of Value for AQI metrics
+	Sensors   []SensorValue `json:"sensors"`            // worst first
+}
+
+func (e Event) String() string {
+	value := formatValue(e.Value)
+	threshold := formatValue(e.Threshold)
+	if isCategory(e.Metric) {
+		value = purpleair.AqiCategory(e.Value).String()
+		threshold = purpleair.AqiCategory(e.Threshold).String()
+	} else if e.Category != "" {
+		value += " (" + e.Category + ")"
+	}
+	return fmt.Sprintf("%s %s: %s %s is %s, threshold %s %s, across %d sensors",
+		e.Rule, e.Kind, e.Aggregate, e.Metric, value, e.Op, threshold, len(e.Sensors))
+}
+
+func formatValue(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.1f", v), "0"), ".")
+}
+
+type ruleState struct {
+	Firing    bool      `json:"firing"`
+	Since     time.Time `json:"since,omitempty"`    // when the condition started holding, zero if it isn't
+	Notified  time.Time `json:"notified,omitempty"` // when firing was last notified, for the cooldown
+	Announced bool      `json:"announced"`          // firing was notified, so clearing will be too
+	Value     float64   `json:"value"`
+}
+
+// Engine evaluates rules against the latest sample of each sensor every time samples are
+// written to it, and calls OnEvent when a rule fires or clears. It is a sink, so it can be fed
+// by the poller next to the others. Rule state is saved to a file after each evaluation so a
+// restart neither forgets a firing rule nor notifies it again.
+type Engine struct {
+	Rules   []Rule
+	MaxAge  time.Duration // older samples are ignored, so backfilled history doesn't fire rules
+	OnEvent func(Event)
+
+	path   string
+	now    func() time.Time
+	mu     sync.Mutex
+	latest map[int]purpleair.SensorSample
+	state  map[string]*ruleState
+}
+
+// NewEngine makes an engine that saves its state to path, or doesn't if path is empty
+func NewEngine(rules []Rule, path string) *Engine {
+	return &Engine{
+		Rules:  rules,
+		MaxAge: 15 * time.Minute,
+		path:   path,
+		now:    time.Now,
+		latest: make(map[int]purpleair.SensorSample),
+		state:  make(map[string]*ruleState),
+	}
+}
+
+// Fields are the api fields all the rules need
+func (e *Engine) Fields() []string {
+	seen := make(map[string]bool)
+	var fields []string
+	for _, r := range e.Rules {
+		for _, f := range r.Fields() {
+			if !seen[f] {
+				seen[f] = true
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields
+}
+
+// Load reads the saved state. A missing file is not an error.
+func (e *Engine) Load() error {
+	if e.path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(e.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := make(map[string]*ruleState)
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("alert state %s: %s", e.path, err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.state = state
+	return nil
+}
+
+func (e *Engine) saveLocked() error {
+	if e.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(e.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(e.path), filepath.Base(e.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), e.path)
+}
+
+// Firing lists the rules that are firing
+func (e *Engine) Firing() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var names []string
+	for _, r := range e.Rules {
+		if st, ok := e.state[r.Name]; ok && st.Firing {
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+func (e *Engine) Open() error {
+	return nil
+}
+
+// Write keeps the latest sample of each sensor and evaluates the rules if any sample was new
+func (e *Engine) Write(samples []purpleair.SensorSample) error {
+	e.mu.Lock()
+	now := e.now()
+	updated := false
+	for _, s := range samples {
+		if e.MaxAge > 0 && now.Sub(s.Time) > e.MaxAge {
+			continue
+		}
+		if prev, ok := e.latest[s.SensorIndex]; ok && !s.Time.After(prev.Time) {
+			continue
+		}
+		e.latest[s.SensorIndex] = s
+		updated = true
+	}
+	if !updated {
+		e.mu.Unlock()
+		return nil
+	}
+	for idx, s := range e.latest {
+		if e.MaxAge > 0 && now.Sub(s.Time) > e.MaxAge {
+			delete(e.latest, idx)
+		}
+	}
+	var events []Event
+	for _, r := range e.Rules {
+		if ev, ok := e.evaluate(r, now); ok {
+			events = append(events, ev)
+		}
+	}
+	err := e.saveLocked()
+	e.mu.Unlock()
+	// outside the lock so a handler may call back into the engine
+	if e.OnEvent != nil {
+		for _, ev := range events {
+			e.OnEvent(ev)
+		}
+	}
+	return err
+}
+
+func (e *Engine) Flush() error {
+	return nil
+}
+
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.saveLocked()
+}
+
+func aggregate(r Rule, values []SensorValue) float64 {
+	vs := make([]float64, len(values))
+	for i, v := range values {
+		vs[i] = v.Value
+	}
+	sort.Float64s(vs)
+	switch r.Aggregate {
+	case "median":
+		n := len(vs)
+		if n%2 == 1 {
+			return vs[n/2]
+		}
+		return (vs[n/2-1] + vs[n/2]) / 2
+	case "mean":
+		sum := 0.0
+		for _, v := range vs {
+			sum += v
+		}
+		return sum / float64(len(vs))
+	case "min":
+		return vs[0]
+	case "max":
+		return vs[len(vs)-1]
+	}
+	// any single sensor crossing is the worst one crossing
+	if r.above() {
+		return vs[len(vs)-1]
+	}
+	return vs[0]
+}
+
+// evaluate moves a rule's state on and returns the event to notify, if any
+func (e *Engine) evaluate(r Rule, now time.Time) (Event, bool) {
+	var values []SensorValue
+	for _, s := range e.latest {
+		if !r.includes(s) {
+			continue
+		}
+		if v, ok := metricValue(r.Metric, s); ok && !math.IsNaN(v) {
+			values = append(values, SensorValue{SensorIndex: s.SensorIndex, Name: s.Tags["name"], Value: v})
+		}
+	}
+	// without enough sensors there's nothing to say, so the rule stays as it was
+	if len(values) < r.MinSensors || len(values) == 0 {
+		return Event{}, false
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Value != values[j].Value {
+			return values[i].Value > values[j].Value == r.above()
+		}
+		return values[i].SensorIndex < values[j].SensorIndex
+	})
+	v := aggregate(r, values)
+
+	st, ok := e.state[r.Name]
+	if !ok {
+		st = &ruleState{}
+		e.state[r.Name] = st
+	}
+	st.Value = v
+	ev := Event{
+		Rule:      r.Name,
+		Time:      now,
+		Metric:    r.Metric,
+		Aggregate: r.Aggregate,
+		Op:        r.Op,
+		Threshold: float64(r.Threshold),
+		Value:     v,
+		Sensors:   values,
+	}
+	if r.isAqi() {
+		ev.Category = purpleair.AqiToCategory(int(math.Round(v))).String()
+	}
+
+	if st.Firing && r.cleared(v) {
+		st.Firing = false
+		st.Since = time.Time{}
+		notify := st.Announced
+		st.Announced = false
+		ev.Kind = Resolved
+		return ev, notify
+	}
+	if !st.Firing {
+		if !r.crossed(v) {
+			st.Since = time.Time{}
+			return Event{}, false
+		}
+		if st.Since.IsZero() {
+			st.Since = now
+		}
+		if now.Sub(st.Since) < time.Duration(r.For) {
+			return Event{}, false
+		}
+		st.Firing = true
+	}
+	// a rule that fired during its cooldown is notified when the cooldown is over
+	if st.Announced || !st.Notified.IsZero() && now.Sub(st.Notified) < time.Duration(r.Cooldown) {
+		return Event{}, false
+	}
+	st.Notified = now
+	st.Announced = true
+	ev.Kind = Firing
+	return ev, true
+}
@@ -0,0 +1,190 @@
+package alert
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+	"github.com/stretchr/testify/assert"
+)
+
+// sample is a sensor's pm2.5_alt at a location given in degrees
+func sample(sensor_index int, t time.Time, pm25 float64, lat float64, lon float64) purpleair.SensorSample {
+	s := purpleair.NewSensorSample(sensor_index, t)
+	s.Fields["pm2.5_alt"] = pm25
+	s.Tags["name"] = "sensor " + strconv.Itoa(sensor_index)
+	s.Tags["latitude"] = strconv.FormatFloat(lat, 'f', -1, 64)
+	s.Tags["longitude"] = strconv.FormatFloat(lon, 'f', -1, 64)
+	return *s
+}
+
+func level(v float64) *Level {
+	l := Level(v)
+	return &l
+}
+
+type testEngine struct {
+	*Engine
+	now    time.Time
+	events []Event
+}
+
+func newTestEngine(t *testing.T, path string, rules ...Rule) *testEngine {
+	for i := range rules {
+		assert.Nil(t, rules[i].Validate())
+	}
+	te := &testEngine{Engine: NewEngine(rules, path), now: time.Unix(1664200000, 0).UTC()}
+	te.Engine.now = func() time.Time { return te.now }
+	te.OnEvent = func(e Event) { te.events = append(te.events, e) }
+	return te
+}
+
+// step moves the clock on a minute and writes one sample per pm2.5 value, at 0.01 degree steps
+// of latitude from 45, -122
+func (te *testEngine) step(t *testing.T, pm25 ...float64) []Event {
+	te.now = te.now.Add(time.Minute)
+	te.events = nil
+	var samples []purpleair.SensorSample
+	for i, v := range pm25 {
+		samples = append(samples, sample(100+i, te.now, v, 45+0.01*float64(i), -122))
+	}
+	assert.Nil(t, te.Write(samples))
+	return te.events
+}
+
+func TestMedianForHysteresis(t *testing.T) {
+	// median AQI across sensors within 3 km > 150 for 10 minutes, clearing below 100
+	te := newTestEngine(t, "", Rule{
+		Name: "neighbourhood", Metric: "aqi", Aggregate: "median", Threshold: 150, Clear: level(100),
+		For: Duration(10 * time.Minute), Lat: 45, Lon: -122, RadiusKm: 3,
+	})
+	// sensor 103 is 3.3 km away, so its AQI of 500 doesn't count
+	assert.Empty(t, te.step(t, 60, 60, 10, 600))
+	for i := 0; i < 9; i++ {
+		assert.Empty(t, te.step(t, 60, 60, 10, 600), i)
+	}
+	events := te.step(t, 60, 60, 10, 600)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, Firing, events[0].Kind)
+	assert.Equal(t, float64(purpleair.Pm25ToAqi(60)), events[0].Value)
+	assert.Equal(t, "Unhealthy", events[0].Category)
+	assert.Equal(t, 3, len(events[0].Sensors))
+	assert.Equal(t, 100, events[0].Sensors[0].SensorIndex)
+	assert.Equal(t, "sensor 100", events[0].Sensors[0].Name)
+	assert.Equal(t, []string{"neighbourhood"}, te.Firing())
+
+	// AQI 124 is under the threshold but not under clear
+	assert.Empty(t, te.step(t, 45, 45, 10))
+	assert.Empty(t, te.step(t, 60, 60, 10))
+	events = te.step(t, 20, 20, 10)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, Resolved, events[0].Kind)
+	assert.Empty(t, te.Firing())
+}
+
+func TestForResets(t *testing.T) {
+	te := newTestEngine(t, "", Rule{Name: "any", Metric: "pm2.5_alt", Threshold: 35, For: Duration(3 * time.Minute)})
+	assert.Empty(t, te.step(t, 10, 40))
+	assert.Empty(t, te.step(t, 10, 40))
+	assert.Empty(t, te.step(t, 10, 30))
+	assert.Empty(t, te.step(t, 50, 10))
+	assert.Empty(t, te.step(t, 50, 10))
+	assert.Empty(t, te.step(t, 50, 10))
+	events := te.step(t, 50, 10)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, 50.0, events[0].Value)
+	assert.Equal(t, 100, events[0].Sensors[0].SensorIndex)
+}
+
+func TestCooldown(t *testing.T) {
+	te := newTestEngine(t, "", Rule{Name: "any", Metric: "aqi", Threshold: 100, Cooldown: Duration(5 * time.Minute)})
+	assert.Equal(t, Firing, te.step(t, 40)[0].Kind)
+	assert.Equal(t, Resolved, te.step(t, 10)[0].Kind)
+	// fires again within the cooldown, which is noted but not notified, nor is its clearing
+	assert.Empty(t, te.step(t, 40))
+	assert.Equal(t, []string{"any"}, te.Firing())
+	assert.Empty(t, te.step(t, 10))
+	// still firing when the cooldown is over, so that's notified then
+	assert.Empty(t, te.step(t, 40))
+	events := te.step(t, 40)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, Firing, events[0].Kind)
+	assert.Empty(t, te.step(t, 40))
+}
+
+func TestBelow(t *testing.T) {
+	te := newTestEngine(t, "", Rule{Name: "clean", Metric: "aqi_category", Aggregate: "max", Op: "<=", Threshold: Level(purpleair.AqiGood), Clear: level(1)})
+	assert.Empty(t, te.step(t, 20, 8))
+	events := te.step(t, 5, 8)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "clean firing: max aqi_category is Good, threshold <= Good, across 2 sensors", events[0].String())
+	// Moderate doesn't clear, Unhealthy for Sensitive Groups does
+	assert.Empty(t, te.step(t, 20, 8))
+	assert.Equal(t, Resolved, te.step(t, 40, 8)[0].Kind)
+}
+
+func TestQualityFilter(t *testing.T) {
+	te := newTestEngine(t, "", Rule{Name: "qa", Metric: "pm2.5_corrected", Threshold: 20, ExcludeFlagged: true, MinConfidence: 90})
+	assert.ElementsMatch(t, []string{"pm2.5_cf_1", "humidity", "channel_flags", "confidence"}, te.Fields())
+	te.now = te.now.Add(time.Minute)
+	bad := purpleair.NewSensorSample(1, te.now)
+	for k, v := range map[string]float64{"pm2.5_cf_1": 100, "humidity": 40, "channel_flags": 2, "confidence": 100} {
+		bad.Fields[k] = v
+	}
+	unsure := purpleair.NewSensorSample(2, te.now)
+	for k, v := range map[string]float64{"pm2.5_cf_1": 100, "humidity": 40, "channel_flags": 0, "confidence": 50} {
+		unsure.Fields[k] = v
+	}
+	good := purpleair.NewSensorSample(3, te.now)
+	for k, v := range map[string]float64{"pm2.5_cf_1": 10, "humidity": 40, "channel_flags": 0, "confidence": 100} {
+		good.Fields[k] = v
+	}
+	assert.Nil(t, te.Write([]purpleair.SensorSample{*bad, *unsure, *good}))
+	assert.Empty(t, te.events)
+
+	te.now = te.now.Add(time.Minute)
+	good.Time = te.now
+	good.Fields["pm2.5_cf_1"] = 50
+	assert.Nil(t, te.Write([]purpleair.SensorSample{*good}))
+	assert.Equal(t, 1, len(te.events))
+	assert.InDelta(t, purpleair.CorrectedPm25(50, 40), te.events[0].Value, 0.001)
+}
+
+func TestMinSensorsAndMaxAge(t *testing.T) {
+	te := newTestEngine(t, "", Rule{Name: "pair", Metric: "aqi", Aggregate: "min", Threshold: 100, MinSensors: 2})
+	assert.Empty(t, te.step(t, 50))
+	// a backfilled sample from an hour ago is ignored
+	old := sample(101, te.now.Add(-time.Hour), 50, 45, -122)
+	assert.Nil(t, te.Write([]purpleair.SensorSample{old}))
+	assert.Empty(t, te.events)
+	assert.Equal(t, 1, len(te.step(t, 50, 50)))
+}
+
+func TestStatePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	rule := Rule{Name: "any", Metric: "aqi", Threshold: 100, Clear: level(50), Cooldown: Duration(time.Hour)}
+	te := newTestEngine(t, path, rule)
+	assert.Equal(t, 1, len(te.step(t, 40)))
+	now := te.now
+
+	// after a restart the rule is still firing, so it isn't notified again, and it clears
+	te = newTestEngine(t, path, rule)
+	assert.Nil(t, te.Load())
+	te.now = now
+	assert.Equal(t, []string{"any"}, te.Firing())
+	assert.Empty(t, te.step(t, 40))
+	assert.Empty(t, te.step(t, 20))
+	events := te.step(t, 5)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, Resolved, events[0].Kind)
+	assert.Nil(t, te.Close())
+
+	// and the cooldown carried over too
+	te = newTestEngine(t, path, rule)
+	assert.Nil(t, te.Load())
+	te.now = now.Add(10 * time.Minute)
+	assert.Empty(t, te.step(t, 40))
+	assert.Equal(t, []string{"any"}, te.Firing())
+}
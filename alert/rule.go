@@ -0,0 +1,318 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration written as a string like "10m" in rule files
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return fmt.Errorf("line %d: duration must be a string like \"10m\"", value.Line)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: %s", value.Line, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10m\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Level is a threshold, given as a number or, for the category metrics, a category name
+type Level float64
+
+func (l *Level) UnmarshalYAML(value *yaml.Node) error {
+	var v float64
+	if value.Tag != "!!str" && value.Decode(&v) == nil {
+		*l = Level(v)
+		return nil
+	}
+	c, err := purpleair.ParseAqiCategory(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: threshold must be a number or an AQI category: %s", value.Line, err)
+	}
+	*l = Level(c)
+	return nil
+}
+
+func (l *Level) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			*l = Level(v)
+			return nil
+		}
+		c, err := purpleair.ParseAqiCategory(s)
+		if err != nil {
+			return err
+		}
+		*l = Level(c)
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return fmt.Errorf("threshold must be a number or an AQI category")
+	}
+	*l = Level(v)
+	return nil
+}
+
+// metricFields are the api fields each derived metric is computed from
+var metricFields = map[string][]string{
+	"aqi":                    {"pm2.5_alt"},
+	"aqi_epa":                {"pm2.5_alt"},
+	"aqi_raw":                {"pm2.5"},
+	"aqi_category":           {"pm2.5_alt"},
+	"pm2.5_corrected":        {"pm2.5_cf_1", "humidity"},
+	"aqi_corrected":          {"pm2.5_cf_1", "humidity"},
+	"aqi_corrected_category": {"pm2.5_cf_1", "humidity"},
+}
+
+// metricValue is a sample's value of a metric, either one of its fields or one derived from them
+func metricValue(metric string, s purpleair.SensorSample) (float64, bool) {
+	field := func(name string) (float64, bool) {
+		v, ok := s.Fields[name]
+		return v, ok
+	}
+	corrected := func() (float64, bool) {
+		pm, ok := field("pm2.5_cf_1")
+		rh, ok2 := field("humidity")
+		return purpleair.CorrectedPm25(pm, rh), ok && ok2
+	}
+	switch metric {
+	case "aqi", "aqi_epa", "aqi_category":
+		v, ok := field("pm2.5_alt")
+		if metric == "aqi_category" {
+			return float64(purpleair.AqiToCategory(purpleair.Pm25ToAqi(v))), ok
+		}
+		return float64(purpleair.Pm25ToAqi(v)), ok
+	case "aqi_raw":
+		v, ok := field("pm2.5")
+		return float64(purpleair.Pm25ToAqi(v)), ok
+	case "pm2.5_corrected":
+		return corrected()
+	case "aqi_corrected", "aqi_corrected_category":
+		v, ok := corrected()
+		if metric == "aqi_corrected_category" {
+			return float64(purpleair.AqiToCategory(purpleair.Pm25ToAqi(v))), ok
+		}
+		return float64(purpleair.Pm25ToAqi(v)), ok
+	}
+	return field(metric)
+}
+
+// Rule is a condition on the sensors' latest samples, like "median aqi_corrected of the sensors
+// within 3 km > 150 for 10m". Metric is a sample field or one of aqi (aqi_epa), aqi_raw,
+// aqi_category, pm2.5_corrected, aqi_corrected and aqi_corrected_category, categories being
+// numbered from Good = 0 to Hazardous = 5. Aggregate is how the sensors' values are combined:
+// median, mean, max, min, or any, which is true when any single sensor crosses the threshold.
+//
+// The rule fires once the condition has held for For, and clears when the value crosses back
+// over Clear, which defaults to Threshold; a Clear below a > Threshold gives hysteresis so a
+// value hovering at the threshold doesn't flap. After notifying that it fired a rule doesn't
+// notify again for Cooldown.
+type Rule struct {
+	Name      string   `json:"name" yaml:"name"`
+	Metric    string   `json:"metric" yaml:"metric"`
+	Aggregate string   `json:"aggregate" yaml:"aggregate"`
+	Op        string   `json:"op" yaml:"op"`
+	Threshold Level    `json:"threshold" yaml:"threshold"`
+	Clear     *Level   `json:"clear,omitempty" yaml:"clear"`
+	For       Duration `json:"for,omitempty" yaml:"for"`
+	Cooldown  Duration `json:"cooldown,omitempty" yaml:"cooldown"`
+
+	// only sensors within RadiusKm of Lat, Lon (degrees) count, if RadiusKm is set. Sensor
+	// locations come from the latitude and longitude tags of the metadata cache.
+	Lat      float64 `json:"lat,omitempty" yaml:"lat"`
+	Lon      float64 `json:"lon,omitempty" yaml:"lon"`
+	RadiusKm float64 `json:"radius_km,omitempty" yaml:"radius_km"`
+
+	// QA as purpleair.SampleQuality: skip sensors whose channels are downgraded, or whose
+	// confidence, from 0 to 100, is below MinConfidence
+	ExcludeFlagged bool    `json:"exclude_flagged,omitempty" yaml:"exclude_flagged"`
+	MinConfidence  float64 `json:"min_confidence,omitempty" yaml:"min_confidence"`
+	// the rule isn't evaluated with fewer sensors than this, default 1
+	MinSensors int `json:"min_sensors,omitempty" yaml:"min_sensors"`
+}
+
+// LoadRules reads a YAML or JSON array of rules and validates them
+func LoadRules(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if errs := ValidateRules(rules); len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", path, errs[0])
+	}
+	return rules, nil
+}
+
+// ValidateRules validates each rule, and returns every problem found
+func ValidateRules(rules []Rule) []error {
+	var errs []error
+	names := make(map[string]bool, len(rules))
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d: %w", i+1, err))
+			continue
+		}
+		if names[rules[i].Name] {
+			errs = append(errs, fmt.Errorf("rule name %q is used twice", rules[i].Name))
+		}
+		names[rules[i].Name] = true
+	}
+	return errs
+}
+
+// Validate checks a rule and fills in its defaults
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule has no name")
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("rule %s has no metric", r.Name)
+	}
+	if r.Aggregate == "" {
+		r.Aggregate = "any"
+	}
+	switch r.Aggregate {
+	case "median", "mean", "max", "min", "any":
+	default:
+		return fmt.Errorf("rule %s aggregate must be median, mean, max, min or any", r.Name)
+	}
+	if r.Op == "" {
+		r.Op = ">"
+	}
+	switch r.Op {
+	case ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("rule %s op must be >, >=, < or <=", r.Name)
+	}
+	if r.Clear != nil {
+		if r.above() && *r.Clear > r.Threshold || !r.above() && *r.Clear < r.Threshold {
+			return fmt.Errorf("rule %s clear must be on the other side of the threshold", r.Name)
+		}
+	}
+	if r.RadiusKm < 0 || r.Lat < -90 || r.Lat > 90 || r.Lon < -180 || r.Lon > 180 {
+		return fmt.Errorf("rule %s has an invalid lat, lon or radius_km", r.Name)
+	}
+	if r.For < 0 || r.Cooldown < 0 {
+		return fmt.Errorf("rule %s for and cooldown can't be negative", r.Name)
+	}
+	if r.MinSensors < 1 {
+		r.MinSensors = 1
+	}
+	return nil
+}
+
+// Fields are the api fields the rule needs in samples
+func (r Rule) Fields() []string {
+	fields, ok := metricFields[r.Metric]
+	if !ok {
+		fields = []string{r.Metric}
+	}
+	fields = append([]string(nil), fields...)
+	if r.ExcludeFlagged {
+		fields = append(fields, "channel_flags")
+	}
+	if r.MinConfidence > 0 {
+		fields = append(fields, "confidence")
+	}
+	return fields
+}
+
+func (r Rule) above() bool {
+	return r.Op == ">" || r.Op == ">="
+}
+
+func (r Rule) crossed(v float64) bool {
+	t := float64(r.Threshold)
+	switch r.Op {
+	case ">":
+		return v > t
+	case ">=":
+		return v >= t
+	case "<":
+		return v < t
+	}
+	return v <= t
+}
+
+func (r Rule) cleared(v float64) bool {
+	if r.Clear == nil {
+		return !r.crossed(v)
+	}
+	if r.above() {
+		return v < float64(*r.Clear)
+	}
+	return v > float64(*r.Clear)
+}
+
+// includes reports whether a sample is in the rule's area and passes its QA
+func (r Rule) includes(s purpleair.SensorSample) bool {
+	if r.RadiusKm > 0 {
+		lat, err := strconv.ParseFloat(s.Tags["latitude"], 64)
+		if err != nil {
+			return false
+		}
+		lon, err := strconv.ParseFloat(s.Tags["longitude"], 64)
+		if err != nil {
+			return false
+		}
+		d_km := purpleair.GreatCircleDistanceKm(purpleair.Radians(r.Lat), purpleair.Radians(r.Lon), purpleair.Radians(lat), purpleair.Radians(lon))
+		if d_km > r.RadiusKm {
+			return false
+		}
+	}
+	if !purpleair.SampleQuality(s, r.ExcludeFlagged, r.MinConfidence) {
+		return false
+	}
+	return true
+}
+
+// isCategory reports whether metric is an AQI category rather than a value
+func isCategory(metric string) bool {
+	return metric == "aqi_category" || metric == "aqi_corrected_category"
+}
+
+// isAqi reports whether the metric is an AQI value
+func (r Rule) isAqi() bool {
+	switch r.Metric {
+	case "aqi", "aqi_epa", "aqi_raw", "aqi_corrected":
+		return true
+	}
+	return false
+}
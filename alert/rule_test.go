@@ -0,0 +1,98 @@
+package alert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(path, []byte(`[
+		{"name": "neighbourhood", "metric": "aqi_corrected", "aggregate": "median", "threshold": 150,
+		 "clear": 130, "for": "10m", "cooldown": "1h", "lat": 45.5, "lon": -122.6, "radius_km": 3},
+		{"name": "unhealthy", "metric": "aqi_category", "threshold": "unhealthy"}
+	]`), 0644)
+	rules, err := LoadRules(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rules))
+	assert.Equal(t, Level(130), *rules[0].Clear)
+	assert.Equal(t, Duration(10*time.Minute), rules[0].For)
+	assert.Equal(t, Duration(time.Hour), rules[0].Cooldown)
+	assert.Equal(t, 3.0, rules[0].RadiusKm)
+	assert.Equal(t, Level(purpleair.AqiUnhealthy), rules[1].Threshold)
+	// defaults
+	assert.Equal(t, "any", rules[1].Aggregate)
+	assert.Equal(t, ">", rules[1].Op)
+	assert.Equal(t, 1, rules[1].MinSensors)
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	for _, rules := range []string{
+		`[{"metric": "aqi", "threshold": 100}]`,
+		`[{"name": "a", "metric": "aqi", "threshold": 100, "aggregate": "p99"}]`,
+		`[{"name": "a", "metric": "aqi", "threshold": 100, "op": "=="}]`,
+		`[{"name": "a", "metric": "aqi", "threshold": 100, "clear": 120}]`,
+		`[{"name": "a", "metric": "aqi", "threshold": 100, "op": "<", "clear": 80}]`,
+		`[{"name": "a", "metric": "aqi", "threshold": 100, "for": 10}]`,
+		`[{"name": "a", "metric": "aqi_category", "threshold": "smoky"}]`,
+		`[{"name": "a", "metric": "aqi", "threshold": 100}, {"name": "a", "metric": "aqi", "threshold": 150}]`,
+	} {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		os.WriteFile(path, []byte(rules), 0644)
+		_, err := LoadRules(path)
+		assert.NotNil(t, err, rules)
+	}
+}
+
+func TestMetricValue(t *testing.T) {
+	s := purpleair.NewSensorSample(15111, time.Unix(1664200000, 0))
+	s.Fields["pm2.5_alt"] = 40
+	s.Fields["pm2.5"] = 60
+	s.Fields["pm2.5_cf_1"] = 80
+	s.Fields["humidity"] = 30
+	for metric, want := range map[string]float64{
+		"aqi":                    float64(purpleair.Pm25ToAqi(40)),
+		"aqi_raw":                float64(purpleair.Pm25ToAqi(60)),
+		"aqi_category":           float64(purpleair.AqiUnhealthyForSensitiveGroups),
+		"pm2.5_corrected":        purpleair.CorrectedPm25(80, 30),
+		"aqi_corrected":          float64(purpleair.Pm25ToAqi(purpleair.CorrectedPm25(80, 30))),
+		"aqi_corrected_category": float64(purpleair.AqiUnhealthy),
+		"humidity":               30,
+	} {
+		v, ok := metricValue(metric, *s)
+		assert.True(t, ok, metric)
+		assert.InDelta(t, want, v, 0.001, metric)
+	}
+	_, ok := metricValue("voc", *s)
+	assert.False(t, ok)
+}
+
+func TestLoadRulesYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	os.WriteFile(path, []byte(`
+- name: neighbourhood
+  metric: aqi
+  aggregate: median
+  threshold: 150
+  clear: 130
+  for: 10m
+- name: unhealthy
+  metric: aqi_category
+  threshold: Unhealthy for Sensitive Groups
+`), 0644)
+	rules, err := LoadRules(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rules))
+	assert.Equal(t, Level(150), rules[0].Threshold)
+	assert.Equal(t, Duration(10*time.Minute), rules[0].For)
+	assert.Equal(t, Level(purpleair.AqiUnhealthyForSensitiveGroups), rules[1].Threshold)
+
+	os.WriteFile(path, []byte("- name: a\n  metric: aqi\n  threshold: 100\n  colour: red\n"), 0644)
+	_, err = LoadRules(path)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,444 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/alert"
+	"github.com/poynting/purpleair-api-go/notify"
+	"github.com/poynting/purpleair-api-go/purpleair"
+	"github.com/poynting/purpleair-api-go/sink"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is a sink or notifier: its kind and options, written in YAML as a map of the options
+// with a kind key, e.g. {kind: influx, url: "http://localhost:8086", database: purpleair}
+type Spec struct {
+	Kind    string
+	Options map[string]string
+}
+
+func (s *Spec) UnmarshalYAML(value *yaml.Node) error {
+	var m map[string]string
+	if err := value.Decode(&m); err != nil {
+		return err
+	}
+	s.Kind = m["kind"]
+	if s.Kind == "" {
+		return fmt.Errorf("line %d: needs a kind", value.Line)
+	}
+	delete(m, "kind")
+	s.Options = m
+	return nil
+}
+
+// String is the spec as given to --sink or --notify, with the options sorted
+func (s Spec) String() string {
+	keys := make([]string, 0, len(s.Options))
+	for k := range s.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	opts := make([]string, len(keys))
+	for i, k := range keys {
+		opts[i] = k + "=" + s.Options[k]
+	}
+	if len(opts) == 0 {
+		return s.Kind
+	}
+	return s.Kind + ":" + strings.Join(opts, ",")
+}
+
+// Profile is a named set of settings. Everything is optional, and overridden by the
+// environment and command line flags.
+type Profile struct {
+	ReadKey     string    `yaml:"read_key"`
+	WriteKey    string    `yaml:"write_key"`
+	ReadKeys    []ReadKey `yaml:"read_keys"`    // a pool of read keys to take turns with
+	KeyRotation string    `yaml:"key_rotation"` // round-robin or budget
+
+	// where to poll: a circle around latitude, longitude, or a GeoJSON area file
+	Latitude  *float64 `yaml:"latitude"`
+	Longitude *float64 `yaml:"longitude"`
+	RangeKm   *float64 `yaml:"range_km"`
+	Area      string   `yaml:"area"`
+
+	Fields        []string        `yaml:"fields"`
+	Corrections   []string        `yaml:"corrections"` // names from purpleair.Corrections
+	PollInterval  *alert.Duration `yaml:"poll_interval"`
+	Adaptive      *bool           `yaml:"adaptive"`       // poll as PurpleAir refreshes instead
+	PointsPerDay  *int            `yaml:"points_per_day"` // for polling
+	BudgetDaily   *int            `yaml:"budget_daily"`   // for every request
+	BudgetMonthly *int            `yaml:"budget_monthly"`
+	PointsState   string          `yaml:"points_state"`
+	MetadataCache string          `yaml:"metadata_cache"`
+	Sinks         []Spec          `yaml:"sinks"`
+	Notify        []Spec          `yaml:"notify"`
+	Alerts        []alert.Rule    `yaml:"alerts"`
+	AlertState    string          `yaml:"alert_state"`
+
+	// Locations are polled together instead of the profile's location or area
+	Locations []Location `yaml:"locations"`
+}
+
+// ReadKey is one of a pool of read keys: the key, or a file holding it such as a Docker
+// secret. BudgetDaily is the points it may spend per UTC day, 0 for no limit.
+type ReadKey struct {
+	Name        string `yaml:"name"`
+	Key         string `yaml:"key"`
+	File        string `yaml:"file"`
+	BudgetDaily int    `yaml:"budget_daily"`
+}
+
+// Value is the key, read from File if it's set
+func (k ReadKey) Value() (string, error) {
+	if k.File == "" {
+		return k.Key, nil
+	}
+	b, err := os.ReadFile(k.File)
+	if err != nil {
+		return "", fmt.Errorf("read key %s: %s", k.Name, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// KeyRotations are how a pool of read keys can be taken turns with
+var KeyRotations = []string{"round-robin", "budget"}
+
+// Location is one of several places a profile polls, with its own tags, interval and sinks.
+// Samples go to its sinks as well as the profile's.
+type Location struct {
+	Name         string            `yaml:"name"`
+	Latitude     *float64          `yaml:"latitude"`
+	Longitude    *float64          `yaml:"longitude"`
+	RangeKm      *float64          `yaml:"range_km"`
+	Area         string            `yaml:"area"`
+	PollInterval *alert.Duration   `yaml:"poll_interval"` // the profile's when not set
+	Tags         map[string]string `yaml:"tags"`          // location=<name> when not set
+	Sinks        []Spec            `yaml:"sinks"`
+}
+
+// Region is where the location polls
+func (l Location) Region() (*purpleair.Region, error) {
+	if l.Area != "" {
+		area, err := purpleair.LoadGeoJSONArea(l.Area)
+		if err != nil {
+			return nil, err
+		}
+		return purpleair.NewAreaRegion(area)
+	}
+	if l.Latitude == nil || l.Longitude == nil || l.RangeKm == nil {
+		return nil, fmt.Errorf("location %s needs latitude, longitude and range_km, or area", l.Name)
+	}
+	return purpleair.NewCircleRegion(*l.Latitude, *l.Longitude, *l.RangeKm)
+}
+
+// SampleTags are added to the location's samples
+func (l Location) SampleTags() map[string]string {
+	if len(l.Tags) == 0 {
+		return map[string]string{"location": l.Name}
+	}
+	return l.Tags
+}
+
+// Flags are the profile's settings that have a command line flag, by flag name
+func (p Profile) Flags() map[string]string {
+	flags := make(map[string]string)
+	set := func(name string, v string) {
+		if v != "" {
+			flags[name] = v
+		}
+	}
+	float := func(name string, v *float64) {
+		if v != nil {
+			flags[name] = strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+	}
+	set("readkey", p.ReadKey)
+	set("writekey", p.WriteKey)
+	set("key-rotation", p.KeyRotation)
+	float("lat", p.Latitude)
+	float("lon", p.Longitude)
+	float("range-km", p.RangeKm)
+	set("area", p.Area)
+	set("fields", strings.Join(p.Fields, ","))
+	set("corrections", strings.Join(p.Corrections, ","))
+	if p.PollInterval != nil {
+		set("poll-interval", time.Duration(*p.PollInterval).String())
+	}
+	if p.Adaptive != nil {
+		set("adaptive", strconv.FormatBool(*p.Adaptive))
+	}
+	if p.PointsPerDay != nil {
+		set("points-per-day", strconv.Itoa(*p.PointsPerDay))
+	}
+	if p.BudgetDaily != nil {
+		set("budget-daily", strconv.Itoa(*p.BudgetDaily))
+	}
+	if p.BudgetMonthly != nil {
+		set("budget-monthly", strconv.Itoa(*p.BudgetMonthly))
+	}
+	set("points-state", p.PointsState)
+	set("metadata-cache", p.MetadataCache)
+	set("alert-state", p.AlertState)
+	return flags
+}
+
+// Config is a config file of profiles. Default names the profile to use when none is given.
+type Config struct {
+	Default  string              `yaml:"default"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// Load reads a YAML (or JSON) config file. Unknown keys are errors, so typos aren't ignored.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &c, nil
+}
+
+// ProfileName is the profile to use for name, which may be empty for the default profile, or
+// the only one if there's no default
+func (c *Config) ProfileName(name string) (string, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" && len(c.Profiles) == 1 {
+		for n := range c.Profiles {
+			name = n
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("no profile given and the config has no default")
+	}
+	if _, ok := c.Profiles[name]; !ok {
+		return "", fmt.Errorf("no profile %q in the config", name)
+	}
+	return name, nil
+}
+
+// Profile returns the profile for name as ProfileName picks it, validated
+func (c *Config) Profile(name string) (*Profile, error) {
+	name, err := c.ProfileName(name)
+	if err != nil {
+		return nil, err
+	}
+	p := c.Profiles[name]
+	if p == nil {
+		return nil, fmt.Errorf("profile %s is empty", name)
+	}
+	if errs := p.validate(); len(errs) > 0 {
+		return nil, newValidationError(name, errs)
+	}
+	return p, nil
+}
+
+// ValidationError lists everything wrong with a config
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func newValidationError(profile string, errs []error) *ValidationError {
+	ve := &ValidationError{}
+	for _, err := range errs {
+		ve.Errors = append(ve.Errors, fmt.Errorf("profile %s: %w", profile, err))
+	}
+	return ve
+}
+
+// Validate checks every profile, and returns a *ValidationError of all the problems found
+func (c *Config) Validate() error {
+	ve := &ValidationError{}
+	if c.Default != "" {
+		if _, ok := c.Profiles[c.Default]; !ok {
+			ve.Errors = append(ve.Errors, fmt.Errorf("default profile %q doesn't exist", c.Default))
+		}
+	}
+	if len(c.Profiles) == 0 {
+		ve.Errors = append(ve.Errors, fmt.Errorf("no profiles"))
+	}
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if c.Profiles[name] == nil {
+			ve.Errors = append(ve.Errors, fmt.Errorf("profile %s is empty", name))
+			continue
+		}
+		if errs := c.Profiles[name].validate(); len(errs) > 0 {
+			ve.Errors = append(ve.Errors, newValidationError(name, errs).Errors...)
+		}
+	}
+	if len(ve.Errors) > 0 {
+		return ve
+	}
+	return nil
+}
+
+// validateRegion checks a circle or area, of a profile or location
+func validateRegion(lat *float64, lon *float64, range_km *float64, area string) []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	circle := lat != nil || lon != nil || range_km != nil
+	if circle && area != "" {
+		fail("set latitude, longitude and range_km, or area, not both")
+	}
+	if circle && (lat == nil || lon == nil || range_km == nil) {
+		fail("latitude, longitude and range_km go together")
+	}
+	if lat != nil && (*lat < -90 || *lat > 90) {
+		fail("latitude %v is out of range", *lat)
+	}
+	if lon != nil && (*lon < -180 || *lon > 180) {
+		fail("longitude %v is out of range", *lon)
+	}
+	if range_km != nil && *range_km <= 0 {
+		fail("range_km must be more than 0")
+	}
+	if area != "" {
+		if _, err := purpleair.LoadGeoJSONArea(area); err != nil {
+			fail("area: %s", err)
+		}
+	}
+	return errs
+}
+
+// locationNames are safe to put in file names, for each location's metadata cache
+var locationNames = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (l Location) validate() []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("location %s: %s", l.Name, fmt.Sprintf(format, args...)))
+	}
+	if !locationNames.MatchString(l.Name) {
+		fail("name must be letters, digits, - and _")
+	}
+	if l.Latitude == nil && l.Longitude == nil && l.RangeKm == nil && l.Area == "" {
+		fail("needs latitude, longitude and range_km, or area")
+	}
+	for _, err := range validateRegion(l.Latitude, l.Longitude, l.RangeKm, l.Area) {
+		fail("%s", err)
+	}
+	if l.PollInterval != nil && *l.PollInterval <= 0 {
+		fail("poll_interval must be more than 0")
+	}
+	for _, err := range validateSinks(l.Sinks) {
+		fail("%s", err)
+	}
+	return errs
+}
+
+// validateSinks builds each sink to check its options. influx without options is configured
+// from the environment, as with --sink influx.
+func validateSinks(sinks []Spec) []error {
+	var errs []error
+	for i, s := range sinks {
+		if s.Kind == "influx" && len(s.Options) == 0 {
+			continue
+		}
+		if _, err := sink.New(s.Kind, s.Options); err != nil {
+			errs = append(errs, fmt.Errorf("sink %d: %s", i+1, err))
+		}
+	}
+	return errs
+}
+
+func (p *Profile) validate() []error {
+	errs := validateRegion(p.Latitude, p.Longitude, p.RangeKm, p.Area)
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	if len(p.Locations) > 0 && (p.Latitude != nil || p.Longitude != nil || p.RangeKm != nil || p.Area != "") {
+		fail("set locations, or a latitude, longitude and range_km or area, not both")
+	}
+	key_names := make(map[string]bool)
+	for _, k := range p.ReadKeys {
+		switch {
+		case k.Name == "":
+			fail("read_keys need a name")
+		case key_names[k.Name]:
+			fail("read key %s is given more than once", k.Name)
+		case k.Key == "" && k.File == "":
+			fail("read key %s needs a key or a file", k.Name)
+		case k.Key != "" && k.File != "":
+			fail("read key %s has a key and a file, set one", k.Name)
+		case k.BudgetDaily < 0:
+			fail("read key %s budget_daily can't be negative", k.Name)
+		}
+		key_names[k.Name] = true
+	}
+	known := p.KeyRotation == ""
+	for _, r := range KeyRotations {
+		known = known || r == p.KeyRotation
+	}
+	if !known {
+		fail("unknown key_rotation %s, expected one of %s", p.KeyRotation, strings.Join(KeyRotations, ", "))
+	}
+	names := make(map[string]bool)
+	for _, l := range p.Locations {
+		if names[l.Name] {
+			fail("location %s is given more than once", l.Name)
+		}
+		names[l.Name] = true
+		errs = append(errs, l.validate()...)
+	}
+	for _, f := range p.Fields {
+		if !purpleair.ValidField(f) {
+			fail("unknown field %s", f)
+		}
+	}
+	for _, c := range p.Corrections {
+		if _, ok := purpleair.Corrections[c]; !ok {
+			fail("unknown correction %s", c)
+		}
+	}
+	if p.PollInterval != nil && *p.PollInterval <= 0 {
+		fail("poll_interval must be more than 0")
+	}
+	if p.PointsPerDay != nil && *p.PointsPerDay < 0 {
+		fail("points_per_day can't be negative")
+	}
+	if p.BudgetDaily != nil && *p.BudgetDaily < 0 {
+		fail("budget_daily can't be negative")
+	}
+	if p.BudgetMonthly != nil && *p.BudgetMonthly < 0 {
+		fail("budget_monthly can't be negative")
+	}
+	errs = append(errs, validateSinks(p.Sinks)...)
+	for i, s := range p.Notify {
+		if _, err := notify.New(s.Kind, s.Options); err != nil {
+			fail("notify %d: %s", i+1, err)
+		}
+	}
+	for _, err := range alert.ValidateRules(p.Alerts) {
+		fail("alerts: %s", err)
+	}
+	return errs
+}
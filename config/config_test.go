@@ -0,0 +1,227 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/alert"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, yaml string) string {
+	path := filepath.Join(t.TempDir(), "purpleair.yaml")
+	os.WriteFile(path, []byte(yaml), 0644)
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	c, err := Load(writeConfig(t, `
+default: home
+profiles:
+  home:
+    read_key: abc
+    latitude: 45.5
+    longitude: -122.6
+    range_km: 3
+    fields: [pm2.5_cf_1, humidity]
+    corrections: [epa]
+    poll_interval: 2m
+    adaptive: true
+    points_per_day: 500000
+    budget_monthly: 20000000
+    points_state: points.json
+    sinks:
+      - kind: json
+        path: /tmp/out.json
+    notify:
+      - kind: webhook
+        url: https://ntfy.sh/smoke
+        format: ntfy
+    alerts:
+      - name: smoke
+        metric: aqi_corrected
+        threshold: unhealthy
+  cabin:
+    area: cabin.geojson
+`))
+	assert.Nil(t, err)
+	p := c.Profiles["home"]
+	assert.Equal(t, 45.5, *p.Latitude)
+	assert.Equal(t, alert.Duration(2*time.Minute), *p.PollInterval)
+	assert.Equal(t, "json:path=/tmp/out.json", p.Sinks[0].String())
+	assert.Equal(t, "webhook:format=ntfy,url=https://ntfy.sh/smoke", p.Notify[0].String())
+	assert.Equal(t, alert.Level(3), p.Alerts[0].Threshold)
+	assert.Equal(t, map[string]string{
+		"readkey":        "abc",
+		"lat":            "45.5",
+		"lon":            "-122.6",
+		"range-km":       "3",
+		"fields":         "pm2.5_cf_1,humidity",
+		"corrections":    "epa",
+		"poll-interval":  "2m0s",
+		"adaptive":       "true",
+		"points-per-day": "500000",
+		"budget-monthly": "20000000",
+		"points-state":   "points.json",
+	}, p.Flags())
+	assert.Equal(t, map[string]string{"area": "cabin.geojson"}, c.Profiles["cabin"].Flags())
+
+	_, err = Load(writeConfig(t, "profiles:\n  home:\n    latitud: 45.5\n"))
+	assert.NotNil(t, err)
+	_, err = Load(writeConfig(t, "profiles:\n  home:\n    sinks:\n      - path: out.json\n"))
+	assert.NotNil(t, err)
+}
+
+func TestProfile(t *testing.T) {
+	c := &Config{Profiles: map[string]*Profile{"home": {ReadKey: "abc"}}}
+	p, err := c.Profile("")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", p.ReadKey)
+	_, err = c.Profile("cabin")
+	assert.NotNil(t, err)
+
+	c.Profiles["cabin"] = &Profile{}
+	_, err = c.Profile("")
+	assert.NotNil(t, err)
+	c.Default = "cabin"
+	_, err = c.Profile("")
+	assert.Nil(t, err)
+
+	// a profile without settings decodes to nil
+	c, err = Load(writeConfig(t, "profiles:\n  home:\n"))
+	assert.Nil(t, err)
+	_, err = c.Profile("")
+	assert.ErrorContains(t, err, "profile home is empty")
+}
+
+func TestValidate(t *testing.T) {
+	c, err := Load(writeConfig(t, `
+default: office
+profiles:
+  home:
+    latitude: 95
+    fields: [pm25]
+    corrections: [lrapa]
+    poll_interval: 0s
+    budget_daily: -1
+    sinks:
+      - kind: nosuch
+    alerts:
+      - metric: aqi
+        threshold: 100
+  cabin:
+    area: cabin.geojson
+    longitude: -122.6
+`))
+	assert.Nil(t, err)
+	err = c.Validate()
+	var ve *ValidationError
+	assert.True(t, errors.As(err, &ve))
+	assert.Equal(t, 12, len(ve.Errors), err.Error())
+
+	c, err = Load(writeConfig(t, "profiles:\n  home:\n    latitude: 45.5\n    longitude: -122.6\n    range_km: 3\n"))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Validate())
+}
+
+func TestLocations(t *testing.T) {
+	c, err := Load(writeConfig(t, `
+profiles:
+  family:
+    poll_interval: 5m
+    locations:
+      - name: home
+        latitude: 33.3333
+        longitude: -96.6666
+        range_km: 3
+        sinks:
+          - kind: influx
+      - name: school
+        latitude: 33.34
+        longitude: -96.66
+        range_km: 3
+        poll_interval: 1m
+        tags: {location: school, district: plano}
+`))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Validate())
+	home := c.Profiles["family"].Locations[0]
+	assert.Equal(t, map[string]string{"location": "home"}, home.SampleTags())
+	r, err := home.Region()
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(33.3333, -96.6666))
+	assert.Equal(t, "plano", c.Profiles["family"].Locations[1].SampleTags()["district"])
+
+	c, err = Load(writeConfig(t, `
+profiles:
+  family:
+    area: county.geojson
+    locations:
+      - name: home
+      - name: home/office
+        latitude: 33.3
+        poll_interval: 0s
+        sinks:
+          - kind: nosuch
+      - name: home
+        area: home.geojson
+`))
+	assert.Nil(t, err)
+	err = c.Validate()
+	var ve *ValidationError
+	assert.True(t, errors.As(err, &ve))
+	assert.Equal(t, 9, len(ve.Errors), err.Error())
+}
+
+func TestReadKeys(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "purpleair_prod")
+	os.WriteFile(secret, []byte("prod-key\n"), 0600)
+	c, err := Load(writeConfig(t, `
+profiles:
+  home:
+    key_rotation: budget
+    read_keys:
+      - name: prod
+        file: `+secret+`
+        budget_daily: 100000
+      - name: dev
+        key: dev-key
+`))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Validate())
+	p := c.Profiles["home"]
+	assert.Equal(t, "budget", p.Flags()["key-rotation"])
+	key, err := p.ReadKeys[0].Value()
+	assert.Nil(t, err)
+	assert.Equal(t, "prod-key", key)
+	key, _ = p.ReadKeys[1].Value()
+	assert.Equal(t, "dev-key", key)
+
+	c, err = Load(writeConfig(t, `
+profiles:
+  home:
+    key_rotation: random
+    read_keys:
+      - key: abc
+      - name: dev
+        key: abc
+        file: dev.key
+      - name: dev
+        key: def
+        budget_daily: -5
+`))
+	assert.Nil(t, err)
+	err = c.Validate()
+	var ve *ValidationError
+	assert.True(t, errors.As(err, &ve))
+	assert.Equal(t, 4, len(ve.Errors), err.Error())
+	assert.ErrorContains(t, err, "read key dev has a key and a file")
+
+	c, err = Load(writeConfig(t, "profiles:\n  home:\n    read_keys:\n      - name: prod\n"))
+	assert.Nil(t, err)
+	assert.ErrorContains(t, c.Validate(), "read key prod needs a key or a file")
+}
@@ -0,0 +1,224 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// request duration histogram buckets in seconds
+var durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	endpoint string
+	code     string
+}
+
+type durationHistogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// ClientMetrics counts the PurpleAir api requests made through its RoundTripper: requests by
+// endpoint and status, latency, and errors by the api's error type. Points used are counted by
+// AddPoints, as a purpleair Client's OnPoints. It also has the points each organization has
+// left, as last reported by the api.
+type ClientMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[string]*durationHistogram
+	errors    map[string]uint64
+	points    uint64
+	remaining map[string]int
+}
+
+func NewClientMetrics() *ClientMetrics {
+	return &ClientMetrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[string]*durationHistogram),
+		errors:    make(map[string]uint64),
+		remaining: make(map[string]int),
+	}
+}
+
+// SetRemainingPoints records the points an organization has left, from /organization
+func (m *ClientMetrics) SetRemainingPoints(organization string, points int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remaining[organization] = points
+}
+
+// endpoint replaces sensor indexes in a path so each endpoint is one series,
+// e.g. /v1/sensors/15111/history becomes /v1/sensors/:sensor_index/history
+func endpoint(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if _, err := strconv.Atoi(p); err == nil && i > 0 && parts[i-1] == "sensors" {
+			parts[i] = ":sensor_index"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// errorType is the error name of a PurpleAir error response, like ApiKeyInvalidError
+func errorType(body []byte, code int) string {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != "" && len(e.Error) <= 64 {
+		valid := true
+		for _, c := range e.Error {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+				valid = false
+			}
+		}
+		if valid {
+			return e.Error
+		}
+	}
+	return "http_" + strconv.Itoa(code)
+}
+
+// AddPoints counts the points a response was charged. Set it as a purpleair Client's OnPoints.
+func (m *ClientMetrics) AddPoints(points int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.points += uint64(points)
+}
+
+func (m *ClientMetrics) observe(ep string, code string, d time.Duration, errType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{endpoint: ep, code: code}]++
+	h, ok := m.durations[ep]
+	if !ok {
+		h = &durationHistogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[ep] = h
+	}
+	for i, le := range durationBuckets {
+		if d.Seconds() <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += d.Seconds()
+	h.count++
+	if errType != "" {
+		m.errors[errType]++
+	}
+}
+
+type roundTripper struct {
+	metrics *ClientMetrics
+	next    http.RoundTripper
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	ep := endpoint(req.URL.Path)
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		rt.metrics.observe(ep, "error", time.Since(start), "transport")
+		return resp, err
+	}
+	if resp.StatusCode < 400 {
+		// the body is left to stream to the caller, which reports its points to AddPoints
+		rt.metrics.observe(ep, strconv.Itoa(resp.StatusCode), time.Since(start), "")
+		return resp, nil
+	}
+	// an error body is small, and read here for its error type and replaced for the caller
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	d := time.Since(start)
+	if err != nil {
+		rt.metrics.observe(ep, strconv.Itoa(resp.StatusCode), d, "transport")
+		return resp, nil
+	}
+	rt.metrics.observe(ep, strconv.Itoa(resp.StatusCode), d, errorType(body, resp.StatusCode))
+	return resp, nil
+}
+
+// Wrap returns a RoundTripper that records requests made through next, or
+// http.DefaultTransport if next is nil. Use it as a purpleair Client's HTTPClient.Transport.
+func (m *ClientMetrics) Wrap(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper{metrics: m, next: next}
+}
+
+// Points is the total points AddPoints has counted
+func (m *ClientMetrics) Points() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.points
+}
+
+func (m *ClientMetrics) WriteMetrics(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "purpleair_api_requests_total", "counter", "PurpleAir api requests by endpoint and status code.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].code < keys[j].code
+	})
+	for _, k := range keys {
+		writeSample(w, "purpleair_api_requests_total", map[string]string{"endpoint": k.endpoint, "code": k.code}, float64(m.requests[k]))
+	}
+
+	writeHeader(w, "purpleair_api_request_duration_seconds", "histogram", "PurpleAir api request latency.")
+	endpoints := make([]string, 0, len(m.durations))
+	for ep := range m.durations {
+		endpoints = append(endpoints, ep)
+	}
+	sort.Strings(endpoints)
+	for _, ep := range endpoints {
+		h := m.durations[ep]
+		var cumulative uint64
+		for i, le := range durationBuckets {
+			cumulative += h.counts[i]
+			writeSample(w, "purpleair_api_request_duration_seconds_bucket", map[string]string{"endpoint": ep, "le": formatValue(le)}, float64(cumulative))
+		}
+		writeSample(w, "purpleair_api_request_duration_seconds_bucket", map[string]string{"endpoint": ep, "le": "+Inf"}, float64(h.count))
+		writeSample(w, "purpleair_api_request_duration_seconds_sum", map[string]string{"endpoint": ep}, h.sum)
+		writeSample(w, "purpleair_api_request_duration_seconds_count", map[string]string{"endpoint": ep}, float64(h.count))
+	}
+
+	writeHeader(w, "purpleair_api_errors_total", "counter", "Failed PurpleAir api requests by error type, transport for network errors.")
+	types := make([]string, 0, len(m.errors))
+	for t := range m.errors {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		writeSample(w, "purpleair_api_errors_total", map[string]string{"type": t}, float64(m.errors[t]))
+	}
+
+	writeHeader(w, "purpleair_api_points_used_total", "counter", "PurpleAir api points used, fields times rows of each response.")
+	writeSample(w, "purpleair_api_points_used_total", nil, float64(m.points))
+
+	writeHeader(w, "purpleair_api_remaining_points", "gauge", "PurpleAir api points each organization has left, as last reported.")
+	orgs := make([]string, 0, len(m.remaining))
+	for o := range m.remaining {
+		orgs = append(orgs, o)
+	}
+	sort.Strings(orgs)
+	for _, o := range orgs {
+		writeSample(w, "purpleair_api_remaining_points", map[string]string{"organization": o}, float64(m.remaining[o]))
+	}
+}
@@ -0,0 +1,152 @@
+package exporter
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+// Exporter is a sink that keeps the latest sample of each sensor and serves them as Prometheus
+// gauges, so scrapes never reach the PurpleAir api. Each gauge is labelled with sensor_index,
+// the sensor's name tag and its location tag, or Location if the sample has none.
+type Exporter struct {
+	Location string
+	MaxAge   time.Duration // sensors with no sample for this long are no longer exported
+
+	client   *ClientMetrics
+	now      func() time.Time
+	mu       sync.RWMutex
+	sensors  map[int]purpleair.SensorSample
+	lastPoll time.Time
+}
+
+// NewExporter makes an exporter. client may be nil to leave out the api client metrics.
+func NewExporter(location string, client *ClientMetrics) *Exporter {
+	return &Exporter{
+		Location: location,
+		MaxAge:   1 * time.Hour,
+		client:   client,
+		now:      time.Now,
+		sensors:  make(map[int]purpleair.SensorSample),
+	}
+}
+
+func (e *Exporter) Open() error {
+	return nil
+}
+
+// Write replaces each sensor's latest sample. Backfilled samples are written too, so only
+// Polled marks a poll successful.
+func (e *Exporter) Write(samples []purpleair.SensorSample) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range samples {
+		if prev, ok := e.sensors[s.SensorIndex]; ok && prev.Time.After(s.Time) {
+			continue
+		}
+		e.sensors[s.SensorIndex] = s
+	}
+	return nil
+}
+
+// Polled marks a live poll that got samples
+func (e *Exporter) Polled() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastPoll = e.now()
+}
+
+func (e *Exporter) Flush() error {
+	return nil
+}
+
+func (e *Exporter) Close() error {
+	return nil
+}
+
+func (e *Exporter) labels(s purpleair.SensorSample) map[string]string {
+	location := s.Tags["location"]
+	if location == "" {
+		location = e.Location
+	}
+	return map[string]string{
+		"sensor_index": strconv.Itoa(s.SensorIndex),
+		"name":         s.Tags["name"],
+		"location":     location,
+	}
+}
+
+// fields adds the AQI values computed from pm2.5_alt and pm2.5, as the influx sink does
+func fields(s purpleair.SensorSample) map[string]float64 {
+	f := make(map[string]float64, len(s.Fields)+2)
+	for k, v := range s.Fields {
+		f[k] = v
+	}
+	if v, ok := s.Fields["pm2.5_alt"]; ok {
+		f["aqi_epa"] = float64(purpleair.Pm25ToAqi(v))
+	}
+	if v, ok := s.Fields["pm2.5"]; ok {
+		f["aqi_raw"] = float64(purpleair.Pm25ToAqi(v))
+	}
+	return f
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	e.mu.Lock()
+	now := e.now()
+	indexes := make([]int, 0, len(e.sensors))
+	for idx, s := range e.sensors {
+		if e.MaxAge > 0 && now.Sub(s.Time) > e.MaxAge {
+			delete(e.sensors, idx)
+			continue
+		}
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	byField := make(map[string][]int)
+	values := make(map[int]map[string]float64, len(indexes))
+	for _, idx := range indexes {
+		values[idx] = fields(e.sensors[idx])
+		for k := range values[idx] {
+			byField[k] = append(byField[k], idx)
+		}
+	}
+	names := make([]string, 0, len(byField))
+	for k := range byField {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, field := range names {
+		name := purpleair.MetricName(field)
+		writeHeader(&b, name, "gauge", "PurpleAir sensor "+field+".")
+		for _, idx := range byField[field] {
+			writeSample(&b, name, e.labels(e.sensors[idx]), values[idx][field])
+		}
+	}
+	writeHeader(&b, "purpleair_sensor_timestamp_seconds", "gauge", "Time of each sensor's latest sample.")
+	for _, idx := range indexes {
+		s := e.sensors[idx]
+		writeSample(&b, "purpleair_sensor_timestamp_seconds", e.labels(s), float64(s.Time.Unix()))
+	}
+	writeHeader(&b, "purpleair_sensors", "gauge", "Sensors exported.")
+	writeSample(&b, "purpleair_sensors", nil, float64(len(indexes)))
+	writeHeader(&b, "purpleair_last_successful_poll_timestamp_seconds", "gauge", "Time of the last poll that got samples, 0 before the first.")
+	lastPoll := 0.0
+	if !e.lastPoll.IsZero() {
+		lastPoll = float64(e.lastPoll.UnixNano()) / 1e9
+	}
+	writeSample(&b, "purpleair_last_successful_poll_timestamp_seconds", nil, lastPoll)
+	e.mu.Unlock()
+
+	if e.client != nil {
+		e.client.WriteMetrics(&b)
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Write(b.Bytes())
+}
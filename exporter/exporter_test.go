@@ -0,0 +1,131 @@
+package exporter
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(t *testing.T, h http.Handler) string {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	return rec.Body.String()
+}
+
+func TestExporter(t *testing.T) {
+	now := time.Unix(1664170900, 0)
+	e := NewExporter("home", nil)
+	e.now = func() time.Time { return now }
+
+	s1 := purpleair.NewSensorSample(15111, time.Unix(1664170800, 0).UTC())
+	s1.Fields["pm2.5"] = 8.7
+	s1.Fields["pm2.5_alt"] = 6.1
+	s1.Tags["name"] = `Backyard "North"`
+	s2 := purpleair.NewSensorSample(20755, time.Unix(1664170740, 0).UTC())
+	s2.Fields["humidity"] = 43
+	s2.Tags["location"] = "cabin"
+	assert.Nil(t, e.Write([]purpleair.SensorSample{*s1, *s2}))
+	e.Polled()
+
+	assert.Equal(t, `# HELP purpleair_aqi_epa PurpleAir sensor aqi_epa.
+# TYPE purpleair_aqi_epa gauge
+purpleair_aqi_epa{location="home",name="Backyard \"North\"",sensor_index="15111"} 25
+# HELP purpleair_aqi_raw PurpleAir sensor aqi_raw.
+# TYPE purpleair_aqi_raw gauge
+purpleair_aqi_raw{location="home",name="Backyard \"North\"",sensor_index="15111"} 36
+# HELP purpleair_humidity PurpleAir sensor humidity.
+# TYPE purpleair_humidity gauge
+purpleair_humidity{location="cabin",sensor_index="20755"} 43
+# HELP purpleair_pm2_5 PurpleAir sensor pm2.5.
+# TYPE purpleair_pm2_5 gauge
+purpleair_pm2_5{location="home",name="Backyard \"North\"",sensor_index="15111"} 8.7
+# HELP purpleair_pm2_5_alt PurpleAir sensor pm2.5_alt.
+# TYPE purpleair_pm2_5_alt gauge
+purpleair_pm2_5_alt{location="home",name="Backyard \"North\"",sensor_index="15111"} 6.1
+# HELP purpleair_sensor_timestamp_seconds Time of each sensor's latest sample.
+# TYPE purpleair_sensor_timestamp_seconds gauge
+purpleair_sensor_timestamp_seconds{location="home",name="Backyard \"North\"",sensor_index="15111"} 1.6641708e+09
+purpleair_sensor_timestamp_seconds{location="cabin",sensor_index="20755"} 1.66417074e+09
+# HELP purpleair_sensors Sensors exported.
+# TYPE purpleair_sensors gauge
+purpleair_sensors 2
+# HELP purpleair_last_successful_poll_timestamp_seconds Time of the last poll that got samples, 0 before the first.
+# TYPE purpleair_last_successful_poll_timestamp_seconds gauge
+purpleair_last_successful_poll_timestamp_seconds 1.6641709e+09
+`, scrape(t, e))
+
+	// an older sample, as backfilled, doesn't replace a newer one or count as a poll, and stale
+	// sensors are dropped
+	now = now.Add(58 * time.Minute)
+	old := purpleair.NewSensorSample(15111, time.Unix(1664170000, 0).UTC())
+	old.Fields["pm2.5"] = 99
+	e.Write([]purpleair.SensorSample{*old})
+	body := scrape(t, e)
+	assert.Contains(t, body, "purpleair_last_successful_poll_timestamp_seconds 1.6641709e+09\n")
+	assert.Contains(t, body, `purpleair_pm2_5{location="home",name="Backyard \"North\"",sensor_index="15111"} 8.7`)
+	assert.NotContains(t, body, "20755")
+	assert.Contains(t, body, "purpleair_sensors 1\n")
+}
+
+func TestClientMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") == "bad-key" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"api_version":"V1.0.11-0.0.40","error":"ApiKeyInvalidError","description":"The provided api_key was not valid."}`))
+			return
+		}
+		w.Write([]byte(`{"fields":["sensor_index","pm2.5"],"data":[[15111,8.7],[20755,9.1],[90011,null]]}`))
+	}))
+	defer server.Close()
+
+	m := NewClientMetrics()
+	c, _ := purpleair.NewClient("test-read-key", "")
+	c.BaseURL = server.URL + "/v1"
+	c.HTTPClient.Transport = m.Wrap(nil)
+	c.OnPoints = m.AddPoints
+	s, err := c.GetSensors(map[string]string{"fields": "pm2.5"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(s.Data)) // the body is still there for the client
+	c.GetSensorHistory(15111, time.Unix(1664170800, 0), time.Unix(1664172600, 0), 10, []string{"pm2.5_atm"})
+	c.ReadKey = "bad-key"
+	c.GetSensors(map[string]string{"fields": "pm2.5"})
+	c.BaseURL = "http://127.0.0.1:1/v1"
+	c.GetSensors(map[string]string{"fields": "pm2.5"})
+	assert.Equal(t, uint64(12), m.Points())
+	m.SetRemainingPoints("Example Air", 987654)
+
+	e := NewExporter("", m)
+	body := scrape(t, e)
+	for _, line := range []string{
+		`purpleair_api_requests_total{code="200",endpoint="/v1/sensors"} 1`,
+		`purpleair_api_requests_total{code="403",endpoint="/v1/sensors"} 1`,
+		`purpleair_api_requests_total{code="200",endpoint="/v1/sensors/:sensor_index/history"} 1`,
+		`purpleair_api_requests_total{code="error",endpoint="/v1/sensors"} 1`,
+		`purpleair_api_request_duration_seconds_bucket{endpoint="/v1/sensors",le="+Inf"} 3`,
+		`purpleair_api_request_duration_seconds_count{endpoint="/v1/sensors/:sensor_index/history"} 1`,
+		`purpleair_api_errors_total{type="ApiKeyInvalidError"} 1`,
+		`purpleair_api_errors_total{type="transport"} 1`,
+		`purpleair_api_points_used_total 12`,
+		`purpleair_api_remaining_points{organization="Example Air"} 987654`,
+		`purpleair_last_successful_poll_timestamp_seconds 0`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestExporterServe(t *testing.T) {
+	e := NewExporter("home", nil)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "purpleair_sensors 0\n")
+}
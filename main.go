@@ -1,223 +1,1406 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
-	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/poynting/purpleair-api-go/alert"
+	"github.com/poynting/purpleair-api-go/config"
+	"github.com/poynting/purpleair-api-go/exporter"
+	"github.com/poynting/purpleair-api-go/notify"
 	"github.com/poynting/purpleair-api-go/purpleair"
+	"github.com/poynting/purpleair-api-go/sink"
 	"github.com/urfave/cli/v2"
 )
 
-type InfluxDbClient struct {
-	host       string
-	port       int
-	database   string
-	username   string
-	password   string
-	HTTPClient *http.Client
-}
-
-func NewInfluxClient(host string, port int, database string, username string, password string) *InfluxDbClient {
-	return &InfluxDbClient{
-		host:     host,
-		port:     port,
-		database: database,
-		username: username,
-		password: password,
-		HTTPClient: &http.Client{
-			Timeout: time.Second * 2,
-		},
+// state is what applyConfig sets up from the flags and config file for the command to use.
+// The commands are its methods.
+type state struct {
+	profile     *config.Profile          // the --profile from --config, nil without a config file
+	extraFields []string                 // requested as well as the defaults, for alert rules and digests
+	keyPool     *purpleair.KeyPool       // read keys every client from newClient takes turns with, nil for just --readkey
+	readKeys    []config.ReadKey         // the keyPool's keys, read from their files
+	points      *purpleair.PointsTracker // nil unless --budget-daily, --budget-monthly or --points-state is set
+	metrics     *exporter.ClientMetrics  // records the requests of every client from newClient when set
+	exp         *exporter.Exporter       // serves --metrics, nil without
+	scheds      []*purpleair.Scheduler   // the pollers', which share the balance
+}
+
+func (st *state) newClient(readkey string, writekey string) (*purpleair.Client, error) {
+	c, err := purpleair.NewClient(readkey, writekey)
+	if err != nil {
+		return nil, err
+	}
+	if st.metrics != nil {
+		c.HTTPClient.Transport = st.metrics.Wrap(c.HTTPClient.Transport)
+		c.OnPoints = st.metrics.AddPoints
 	}
+	c.Points = st.points
+	c.Keys = st.keyPool
+	return c, nil
 }
 
-func GetEnvToParams(cCtx *cli.Context) (string, string, map[string]string, error) {
-	readkey := os.Getenv("PURPLEAIR_READ_KEY")
-	writekey := os.Getenv("PURPLEAIR_WRITE_KEY")
-	latstr := os.Getenv("PURPLEAIR_LATITUDE")
-	lonstr := os.Getenv("PURPLEAIR_LONGITUDE")
-	rangestr := os.Getenv("PURPLEAIR_RANGE_KM")
-	if readkey == "" {
-		return "", "", nil, fmt.Errorf("read key is required. Set env PURPLEAIR_READ_KEY")
+// setupKeyPool puts the --readkeys and --readkey-file keys, or the profile's read_keys, in the
+// keyPool with --readkey. Without them clients just use --readkey. It also reads --writekey-file.
+func (st *state) setupKeyPool(cCtx *cli.Context) error {
+	if path := cCtx.String("writekey-file"); path != "" && cCtx.String("writekey") == "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := cCtx.Set("writekey", strings.TrimSpace(string(b))); err != nil {
+			return err
+		}
+	}
+	var keys []config.ReadKey
+	if st.profile != nil && !cCtx.IsSet("readkeys") && !cCtx.IsSet("readkey-file") {
+		keys = st.profile.ReadKeys
+	}
+	for i, k := range cCtx.StringSlice("readkeys") {
+		name, key, found := strings.Cut(k, "=")
+		if !found {
+			name, key = fmt.Sprintf("key%d", i+1), k
+		}
+		keys = append(keys, config.ReadKey{Name: name, Key: key})
 	}
-	if latstr == "" || lonstr == "" {
-		return "", "", nil, fmt.Errorf("lat,lon is required. Set env PURPLEAIR_LATITUDE, PURPLEAIR_LONGITUDE")
+	for _, path := range cCtx.StringSlice("readkey-file") {
+		keys = append(keys, config.ReadKey{Name: filepath.Base(path), File: path})
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	budgets := make(map[string]int)
+	for _, b := range cCtx.StringSlice("key-budget") {
+		name, v, _ := strings.Cut(b, "=")
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("--key-budget %s should be name=points", b)
+		}
+		budgets[name] = n
+	}
+	st.keyPool = purpleair.NewKeyPool()
+	switch rotation := cCtx.String("key-rotation"); rotation {
+	case "round-robin":
+	case "budget":
+		st.keyPool.ByBudget = true
+	default:
+		return fmt.Errorf("unknown --key-rotation %s, expected one of %s", rotation, strings.Join(config.KeyRotations, ", "))
+	}
+	st.keyPool.OnDisable = func(name string, err error) {
+		fmt.Println("read key", name, "taken out of use:", err)
+	}
+	resolved := make([]config.ReadKey, 0, len(keys)+1)
+	in_pool := false
+	for _, k := range keys {
+		key, err := k.Value()
+		if err != nil {
+			return err
+		}
+		in_pool = in_pool || key == cCtx.String("readkey")
+		resolved = append(resolved, config.ReadKey{Name: k.Name, Key: key, BudgetDaily: k.BudgetDaily})
+	}
+	// --readkey goes first, unless it's one of the pool's, which keeps its name and budget
+	if readkey := cCtx.String("readkey"); readkey != "" && !in_pool {
+		resolved = append([]config.ReadKey{{Name: "readkey", Key: readkey}}, resolved...)
+	}
+	for _, k := range resolved {
+		if n, ok := budgets[k.Name]; ok {
+			k.BudgetDaily = n
+			delete(budgets, k.Name)
+		}
+		if err := st.keyPool.Add(k.Name, k.Key, k.BudgetDaily); err != nil {
+			return err
+		}
+		st.readKeys = append(st.readKeys, k)
 	}
-	if rangestr == "" {
-		return "", "", nil, fmt.Errorf("range in km is required. Set env PURPLEAIR_RANGE_KM")
+	for name := range budgets {
+		return fmt.Errorf("--key-budget for %s, which isn't a read key", name)
 	}
-	lat_deg, err := strconv.ParseFloat(latstr, 64)
+	// commands check for a read key before making a client, which uses the pool's
+	if cCtx.String("readkey") == "" {
+		return cCtx.Set("readkey", st.readKeys[0].Key)
+	}
+	return nil
+}
+
+// setupPointsTracker makes the points tracker, loading what's been spent from --points-state
+func (st *state) setupPointsTracker(cCtx *cli.Context) error {
+	if !cCtx.IsSet("points-state") && !cCtx.IsSet("budget-daily") && !cCtx.IsSet("budget-monthly") {
+		return nil
+	}
+	if cCtx.Int("budget-daily") < 0 || cCtx.Int("budget-monthly") < 0 {
+		return fmt.Errorf("--budget-daily and --budget-monthly can't be negative")
+	}
+	st.points = purpleair.NewPointsTracker(cCtx.String("points-state"))
+	st.points.DailyBudget = cCtx.Int("budget-daily")
+	st.points.MonthlyBudget = cCtx.Int("budget-monthly")
+	st.points.OnError = func(err error) {
+		fmt.Println("error saving points state", err)
+	}
+	if interval := cCtx.Duration("balance-interval"); interval > 0 {
+		st.points.BalanceRefresh = interval
+	}
+	return st.points.Load()
+}
+
+// checkBalance gets the points left from /organization with each read key, adding up keys of
+// different organizations, for the points tracker to refuse requests past and the metrics
+// gauge. Pollers share the balance by what their polls cost, so they can poll as often as each
+// other. Nothing is updated if a key's organization can't be got.
+func (st *state) checkBalance(cCtx *cli.Context) error {
+	keys := st.readKeys
+	if len(keys) == 0 {
+		keys = []config.ReadKey{{Name: "readkey", Key: cCtx.String("readkey")}}
+	}
+	var orgs []*purpleair.Organization
+	seen := make(map[string]bool)
+	c, err := st.newClient(keys[0].Key, "")
 	if err != nil {
-		return "", "", nil, fmt.Errorf("could not parse PURPLEAIR_LATITUDE into float")
+		return err
+	}
+	for _, k := range keys {
+		o, err := c.GetKeyOrganization(k.Key)
+		if err != nil {
+			return fmt.Errorf("read key %s: %s", k.Name, err)
+		}
+		if !seen[o.ID] {
+			seen[o.ID] = true
+			orgs = append(orgs, o)
+		}
+	}
+	total := 0
+	for _, o := range orgs {
+		total += o.RemainingPoints
+		if st.metrics != nil {
+			st.metrics.SetRemainingPoints(o.Name, o.RemainingPoints)
+		}
+	}
+	if st.points != nil {
+		st.points.SetBalance(total)
+	}
+	costs := 0
+	for _, s := range st.scheds {
+		costs += s.Cost()
+	}
+	for _, s := range st.scheds {
+		if costs == 0 {
+			// nothing's been polled yet to know the costs by
+			s.SetBalance(total / len(st.scheds))
+		} else {
+			s.SetBalance(total * s.Cost() / costs)
+		}
+	}
+	return nil
+}
+
+// watchBalance checks the balance now, and then every --balance-interval, when there are
+// budgets, pollers or metrics to use it
+func (st *state) watchBalance(cCtx *cli.Context) {
+	interval := cCtx.Duration("balance-interval")
+	if interval <= 0 || (st.points == nil && st.metrics == nil && len(st.scheds) == 0) {
+		return
+	}
+	check := func() {
+		if err := st.checkBalance(cCtx); err != nil {
+			fmt.Println("error checking points left", err)
+		}
+	}
+	check()
+	go func() {
+		for range time.Tick(interval) {
+			check()
+		}
+	}()
+}
+
+// retryWait is how long to wait after a poll fails: until the budget starts again if the
+// request would have gone over it, otherwise a few seconds
+func retryWait(err error) time.Duration {
+	var budget *purpleair.BudgetError
+	if errors.As(err, &budget) {
+		return time.Until(budget.Reset)
+	}
+	return time.Duration(rand.Float32()*20.0+5.0) * time.Second
+}
+
+// spentNote is the points spent today and this month, and by each of the keyPool's keys today,
+// for logging
+func (st *state) spentNote() string {
+	note := ""
+	if st.points != nil {
+		day, month := st.points.Spent()
+		note += fmt.Sprintf(", %d points spent today, %d this month", day, month)
+	}
+	if st.keyPool != nil {
+		for _, u := range st.keyPool.Usage() {
+			note += fmt.Sprintf(", key %s %d points in %d requests", u.Name, u.Points, u.Requests)
+			if u.Disabled != nil {
+				note += " (out of use)"
+			}
+		}
+	}
+	return note
+}
+
+// hasFlag reports whether the command or app has a flag called name
+func hasFlag(cCtx *cli.Context, name string) bool {
+	for _, flags := range [][]cli.Flag{cCtx.Command.Flags, cCtx.App.Flags} {
+		for _, f := range flags {
+			for _, n := range f.Names() {
+				if n == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// applyConfig loads the --profile from --config, and sets each flag that isn't set on the
+// command line or in the environment from it: flags win over env, and env over the profile.
+// It then checks the --fields and --corrections however they were set, and sets up the
+// key pool and points tracker.
+func (st *state) applyConfig(cCtx *cli.Context) error {
+	if path := cCtx.String("config"); path != "" {
+		c, err := config.Load(path)
+		if err != nil {
+			return err
+		}
+		st.profile, err = c.Profile(cCtx.String("profile"))
+		if err != nil {
+			return err
+		}
+		for name, v := range st.profile.Flags() {
+			if !hasFlag(cCtx, name) || cCtx.IsSet(name) {
+				continue
+			}
+			if err := cCtx.Set(name, v); err != nil {
+				return fmt.Errorf("profile %s: %s", name, err)
+			}
+		}
+	}
+	if f := cCtx.String("fields"); f != "" {
+		for _, field := range strings.Split(f, ",") {
+			if !purpleair.ValidField(field) {
+				return fmt.Errorf("unknown field %s", field)
+			}
+		}
+	}
+	if c := cCtx.String("corrections"); c != "" {
+		for _, name := range strings.Split(c, ",") {
+			if _, ok := purpleair.Corrections[name]; !ok {
+				return fmt.Errorf("unknown correction %s", name)
+			}
+		}
+	}
+	if err := st.setupKeyPool(cCtx); err != nil {
+		return err
+	}
+	return st.setupPointsTracker(cCtx)
+}
+
+// validateConfig reports everything wrong with --config at once
+func validateConfig(cCtx *cli.Context) error {
+	path := cCtx.String("config")
+	if path == "" {
+		return fmt.Errorf("config file is required. Set --config or env PURPLEAIR_CONFIG")
+	}
+	c, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	fmt.Println(path, "is valid")
+	return nil
+}
+
+// defaultSensorParams requests --fields, or the default fields, plus those the --corrections
+// and alert rules need
+func (st *state) defaultSensorParams(cCtx *cli.Context) map[string]string {
+	fields := []string{"humidity", "temperature", "voc", "pm1.0", "pm2.5", "pm10.0", "pm2.5_alt", "last_seen"}
+	if f := cCtx.String("fields"); f != "" {
+		fields = append(strings.Split(f, ","), "last_seen")
+	}
+	// a copy, as location pollers call this at once
+	extra := append([]string{}, st.extraFields...)
+	for _, c := range getCorrections(cCtx) {
+		extra = append(extra, c.Fields...)
+	}
+	for _, f := range extra {
+		found := false
+		for _, d := range fields {
+			found = found || d == f
+		}
+		if !found {
+			fields = append(fields, f)
+		}
+	}
+	return map[string]string{
+		"fields":        strings.Join(fields, ","),
+		"location_type": "0",
+	}
+}
+
+// getCorrections are the --corrections, which applyConfig has checked
+func getCorrections(cCtx *cli.Context) []purpleair.Correction {
+	var corrections []purpleair.Correction
+	for _, name := range strings.Split(cCtx.String("corrections"), ",") {
+		if c, ok := purpleair.Corrections[name]; ok {
+			corrections = append(corrections, c)
+		}
 	}
-	lon_deg, err := strconv.ParseFloat(lonstr, 64)
+	return corrections
+}
+
+func (st *state) getAreaSensors(cCtx *cli.Context, path string) (*purpleair.Sensors, error) {
+	readkey := cCtx.String("readkey")
+	writekey := cCtx.String("writekey")
+	if readkey == "" {
+		return nil, fmt.Errorf("read key is required. Set --readkey or env PURPLEAIR_READ_KEY")
+	}
+	area, err := purpleair.LoadGeoJSONArea(path)
 	if err != nil {
-		return "", "", nil, fmt.Errorf("could not parse PURPLEAIR_LATITUDE into float")
+		return nil, err
 	}
-	range_km, err := strconv.ParseFloat(rangestr, 64)
+	c, err := st.newClient(readkey, writekey)
 	if err != nil {
-		return "", "", nil, fmt.Errorf("could not parse PURPLEAIR_RANGE_KM into float")
+		return nil, err
 	}
-	lat_rad := purpleair.Radians(lat_deg)
-	lon_rad := purpleair.Radians(lon_deg)
-	nwlat, nwlng := purpleair.PointFromLocRadial(lat_rad, lon_rad, range_km, purpleair.Radians(-45))
-	selat, selng := purpleair.PointFromLocRadial(lat_rad, lon_rad, range_km, purpleair.Radians(135))
-	b, err := purpleair.NewBounds(
-		float32(purpleair.Degrees(nwlng)),
-		float32(purpleair.Degrees(nwlat)),
-		float32(purpleair.Degrees(selng)),
-		float32(purpleair.Degrees(selat)))
+	r, err := c.GetSensorsInArea(st.defaultSensorParams(cCtx), area)
 	if err != nil {
-		return "", "", nil, err
+		return nil, err
 	}
-	params := map[string]string{
-		"fields":        "humidity,temperature,voc,pm1.0,pm2.5,pm10.0,pm2.5_alt",
-		"location_type": "0",
+	return r, nil
+}
+
+// GetEnvToParams returns the keys, the parameters to request and the box around the circle of
+// --range-km around --lat,--lon. The box may cross the antimeridian, so it's queried with
+// GetSensorsInBounds rather than added to the parameters.
+func (st *state) GetEnvToParams(cCtx *cli.Context) (string, string, map[string]string, *purpleair.Bounds, error) {
+	readkey := cCtx.String("readkey")
+	writekey := cCtx.String("writekey")
+	if readkey == "" {
+		return "", "", nil, nil, fmt.Errorf("read key is required. Set --readkey or env PURPLEAIR_READ_KEY")
 	}
-	params = purpleair.AppendBoundsParams(params, b)
-	_, nwlng_valid := params["nwlng"]
-	_, nwlat_valid := params["nwlat"]
-	_, selng_valid := params["selng"]
-	_, selat_valid := params["selat"]
+	if !cCtx.IsSet("lat") || !cCtx.IsSet("lon") {
+		return "", "", nil, nil, fmt.Errorf("lat,lon is required. Set --lat, --lon or env PURPLEAIR_LATITUDE, PURPLEAIR_LONGITUDE")
+	}
+	if !cCtx.IsSet("range-km") {
+		return "", "", nil, nil, fmt.Errorf("range in km is required. Set --range-km or env PURPLEAIR_RANGE_KM")
+	}
+	r, err := purpleair.NewCircleRegion(cCtx.Float64("lat"), cCtx.Float64("lon"), cCtx.Float64("range-km"))
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	return readkey, writekey, st.defaultSensorParams(cCtx), r.Bounds, nil
+}
 
-	if !(nwlng_valid && nwlat_valid && selng_valid && selat_valid) {
-		return "", "", nil, fmt.Errorf("error: must provide a location")
+// getMetadataCache returns nil if no cache file was configured
+func (st *state) getMetadataCache(cCtx *cli.Context) (*purpleair.MetadataCache, error) {
+	path := cCtx.String("metadata-cache")
+	if path == "" {
+		return nil, nil
 	}
+	var params map[string]string
+	var bounds *purpleair.Bounds
+	readkey := cCtx.String("readkey")
+	writekey := cCtx.String("writekey")
+	if areapath := cCtx.String("area"); areapath != "" {
+		area, err := purpleair.LoadGeoJSONArea(areapath)
+		if err != nil {
+			return nil, err
+		}
+		bounds, err = area.Envelope()
+		if err != nil {
+			return nil, err
+		}
+		params = map[string]string{"location_type": "0"}
+	} else {
+		var err error
+		readkey, writekey, params, bounds, err = st.GetEnvToParams(cCtx)
+		if err != nil {
+			return nil, err
+		}
+		delete(params, "fields")
+	}
+	c, err := st.newClient(readkey, writekey)
+	if err != nil {
+		return nil, err
+	}
+	m := purpleair.NewMetadataCache(c, params, path)
+	m.Bounds = bounds
+	if err := m.Load(); err != nil {
+		return nil, err
+	}
+	// a query the points tracker hasn't seen is expected to return about the cached sensors
+	if st.points != nil && m.Len() > 0 {
+		st.points.DefaultSensors = m.Len()
+	}
+	return m, nil
+}
 
-	return readkey, writekey, params, err
+// getBackfiller returns nil if --backfill-state isn't set
+func (st *state) getBackfiller(cCtx *cli.Context) (*purpleair.Backfiller, error) {
+	path := cCtx.String("backfill-state")
+	if path == "" {
+		return nil, nil
+	}
+	c, err := st.newClient(cCtx.String("readkey"), cCtx.String("writekey"))
+	if err != nil {
+		return nil, err
+	}
+	b := purpleair.NewBackfiller(c, path)
+	// the same fields as polling, so backfilled samples match those they fill in for
+	b.Fields = purpleair.HistoryFields(strings.Split(st.defaultSensorParams(cCtx)["fields"], ","))
+	b.Average = cCtx.Int("backfill-average")
+	b.MaxPointsPerDay = cCtx.Int("backfill-max-points")
+	if _, err := purpleair.HistoryMaxSpan(b.Average); err != nil {
+		return nil, err
+	}
+	return b, b.Load()
 }
 
-func getSamples(cCtx *cli.Context) ([]purpleair.Sample, error) {
-	readkey, writekey, params, err := GetEnvToParams(cCtx)
+// getNotifiers returns nil if no --notify is set and the profile has none. Email digests are
+// added to out, to sum up the samples.
+func (st *state) getNotifiers(cCtx *cli.Context, out *sink.Fanout) (*notify.Dispatcher, error) {
+	var notifiers []config.Spec
+	if st.profile != nil {
+		notifiers = st.profile.Notify
+	}
+	specs, err := getSpecs(cCtx, "notify", notifiers)
 	if err != nil {
 		return nil, err
 	}
-	c, err := purpleair.NewClient(readkey, writekey)
+	d := notify.NewDispatcher()
+	digests := false
+	for _, spec := range specs {
+		n, err := notify.New(spec.Kind, spec.Options)
+		if err != nil {
+			return nil, err
+		}
+		d.Add(spec.Kind, n)
+		if e, ok := n.(*notify.Email); ok && e.Digest != nil {
+			out.Add("digest", e.Digest)
+			st.extraFields = append(st.extraFields, e.Digest.Fields()...)
+			digests = true
+		}
+	}
+	if d.Len() == 0 {
+		return nil, nil
+	}
+	// without rules or a digest the notifiers would never be sent anything
+	rules := cCtx.String("alert-rules") != "" || (st.profile != nil && len(st.profile.Alerts) > 0)
+	if !rules && !digests {
+		return nil, fmt.Errorf("--notify needs --alert-rules or an email digest")
+	}
+	d.OnError = func(name string, err error) {
+		fmt.Println("error notifying", name, err)
+	}
+	return d, nil
+}
+
+// getAlertEngine returns nil if --alert-rules isn't set and the profile has no alerts. Alerts
+// are printed as they fire and clear, and sent to notifiers if there are any.
+func (st *state) getAlertEngine(cCtx *cli.Context, notifiers *notify.Dispatcher) (*alert.Engine, error) {
+	var rules []alert.Rule
+	if path := cCtx.String("alert-rules"); path != "" {
+		var err error
+		rules, err = alert.LoadRules(path)
+		if err != nil {
+			return nil, err
+		}
+	} else if st.profile != nil {
+		rules = st.profile.Alerts
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	for _, r := range rules {
+		if r.RadiusKm > 0 && cCtx.String("metadata-cache") == "" {
+			return nil, fmt.Errorf("alert rule %s has a radius_km, which needs sensor locations from --metadata-cache", r.Name)
+		}
+	}
+	e := alert.NewEngine(rules, cCtx.String("alert-state"))
+	if err := e.Load(); err != nil {
+		return nil, err
+	}
+	st.extraFields = append(st.extraFields, e.Fields()...)
+	e.OnEvent = func(ev alert.Event) {
+		fmt.Println(time.Now().Format(time.RFC3339), "alert", ev)
+		if notifiers != nil {
+			notifiers.Notify(ev)
+		}
+	}
+	return e, nil
+}
+
+// getSamples returns the response as well as its samples, for its timestamps and points
+func (st *state) getSamples(cCtx *cli.Context, meta *purpleair.MetadataCache) (*purpleair.Sensors, []purpleair.SensorSample, error) {
+	r, err := st.getSensors(cCtx)
+	if err != nil {
+		return nil, nil, err
+	}
+	samples := purpleair.SensorsToSensorSamples(r)
+	if meta != nil {
+		// stale tags are better than no samples, so only report refresh errors
+		if err := meta.Update(); err != nil {
+			fmt.Println("error refreshing sensor metadata", err)
+		}
+		meta.JoinSensorSamples(samples)
+	}
+	for _, c := range getCorrections(cCtx) {
+		c.Correct(samples)
+	}
+	return r, samples, nil
+}
+
+func (st *state) getSensors(cCtx *cli.Context) (*purpleair.Sensors, error) {
+	if path := cCtx.String("area"); path != "" {
+		return st.getAreaSensors(cCtx, path)
+	}
+	readkey, writekey, params, bounds, err := st.GetEnvToParams(cCtx)
 	if err != nil {
 		return nil, err
 	}
-	r, err := c.GetSensors(params)
+	c, err := st.newClient(readkey, writekey)
 	if err != nil {
 		return nil, err
 	}
-	samples := c.SensorsToSamples(r.DataTimeStamp, r.Fields, r.Data)
-	return samples, nil
+	return c.GetSensorsInBounds(params, bounds)
 }
 
-func samplesToPrettyJson(samples []purpleair.Sample) (string, error) {
-	samples_json, err := purpleair.SamplesJson(samples)
+func (st *state) getSensorsToJson(cCtx *cli.Context) error {
+	if cCtx.Bool("dry-run") {
+		return st.dryRun(cCtx)
+	}
+	meta, err := st.getMetadataCache(cCtx)
+	if err != nil {
+		return err
+	}
+	_, samples, err := st.getSamples(cCtx, meta)
 	if err != nil {
-		return "", err
+		return err
 	}
-	var prettyJSON bytes.Buffer
-	json.Indent(&prettyJSON, samples_json, "", "    ")
-	return fmt.Sprint(prettyJSON.String()), nil
+	return sink.NewJSONSink(os.Stdout, true).Write(samples)
 }
 
-func getSensorsToJson(cCtx *cli.Context) error {
-	samples, err := getSamples(cCtx)
+func (st *state) getNearestSensors(cCtx *cli.Context) error {
+	readkey := cCtx.String("readkey")
+	writekey := cCtx.String("writekey")
+	c, err := st.newClient(readkey, writekey)
 	if err != nil {
 		return err
 	}
-	js, err := samplesToPrettyJson(samples)
+	opts := purpleair.DefaultNearestOptions()
+	if f := cCtx.String("fields"); f != "" {
+		opts.Fields = strings.Split(f, ",")
+	}
+	opts.MaxRadiusKm = cCtx.Float64("max-range-km")
+	opts.MaxPoints = cCtx.Int("max-points")
+	nearest, err := c.NearestSensors(cCtx.Float64("lat"), cCtx.Float64("lon"), cCtx.Int("n"), opts)
+	if err != nil {
+		return err
+	}
+	js, err := json.MarshalIndent(nearest, "", "    ")
 	if err != nil {
 		return err
 	}
-	fmt.Println(js)
+	fmt.Println(string(js))
 	return nil
 }
 
-func publishInfluxDb(influx *InfluxDbClient, measurement string, tags map[string]string, samples []purpleair.Sample) error {
-	for _, s := range samples {
-		line := measurement
-		for key, val := range tags {
-			line += fmt.Sprintf(",%s=%s", key, val)
-		}
-		line += fmt.Sprintf(",sensor_index=%d ", int(math.Round(float64(s.Sampledata["sensor_index"]))))
-		j := 0
-		for key, val := range s.Sampledata {
-			if key != "sensor_index" {
-				if j != 0 {
-					line += ","
-				}
-				line += fmt.Sprintf("%s=%.2f", key, val)
-				j++
-			}
+// influxEnvOptions maps influx sink options to the environment variables that set them
+var influxEnvOptions = map[string]string{
+	"url":             "INFLUXDB_URL",
+	"host":            "INFLUXDB_HOST",
+	"port":            "INFLUXDB_PORT",
+	"version":         "INFLUXDB_VERSION",
+	"database":        "INFLUXDB_DB",
+	"rp":              "INFLUXDB_RP",
+	"username":        "INFLUXDB_USERNAME",
+	"password":        "INFLUXDB_PASSWORD",
+	"auth":            "INFLUXDB_AUTH",
+	"token":           "INFLUXDB_TOKEN",
+	"org":             "INFLUXDB_ORG",
+	"bucket":          "INFLUXDB_BUCKET",
+	"precision":       "INFLUXDB_PRECISION",
+	"tls_skip_verify": "INFLUXDB_TLS_SKIP_VERIFY",
+	"tls_ca":          "INFLUXDB_TLS_CA",
+	"tls_cert":        "INFLUXDB_TLS_CERT",
+	"tls_key":         "INFLUXDB_TLS_KEY",
+}
+
+// influxSinkFromEnv builds the influx command's sink from its environment variables
+func influxSinkFromEnv() (sink.Sink, error) {
+	measurement := os.Getenv("INFLUX_MEASUREMENT_NAME")
+	loc := os.Getenv("INFLUX_LOCATION_TAG")
+	if measurement == "" {
+		return nil, fmt.Errorf("measurement name must be set via env INFLUX_MEASUREMENT_NAME")
+	}
+	if loc == "" {
+		return nil, fmt.Errorf("location tag value must be set via env INFLUX_LOCATION_TAG")
+	}
+	options := map[string]string{
+		"measurement":  measurement,
+		"tag.location": loc,
+	}
+	for option, env := range influxEnvOptions {
+		if v := os.Getenv(env); v != "" {
+			options[option] = v
 		}
-		if val, ok := s.Sampledata["pm2.5_alt"]; ok {
-			line += fmt.Sprintf(",aqi_epa=%d", purpleair.Pm25ToAqi(float64(val)))
+	}
+	if options["url"] == "" && (options["host"] == "" || options["port"] == "") {
+		return nil, fmt.Errorf("host and port are required. Set env INFLUXDB_HOST, INFLUXDB_PORT or INFLUXDB_URL")
+	}
+	if options["version"] == "2" {
+		if options["org"] == "" || options["bucket"] == "" || options["token"] == "" {
+			return nil, fmt.Errorf("influx 2.x requires env INFLUXDB_ORG, INFLUXDB_BUCKET, INFLUXDB_TOKEN")
 		}
-		if val, ok := s.Sampledata["pm2.5"]; ok {
-			line += fmt.Sprintf(",aqi_raw=%d", purpleair.Pm25ToAqi(float64(val)))
+	} else if options["database"] == "" {
+		return nil, fmt.Errorf("database is required. Set env INFLUXDB_DB")
+	}
+	return sink.New("influx", options)
+}
+
+// addSink adds s to out, behind a disk queue when --queue-dir is set. Each sink's queue is a
+// directory named after its kind and a hash of its spec, so it is found again after a restart
+// without putting credentials from the spec in the path.
+func addSink(cCtx *cli.Context, out *sink.Fanout, kind string, spec string, s sink.Sink) error {
+	dir := cCtx.String("queue-dir")
+	if dir == "" {
+		out.Add(spec, s)
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(spec))
+	q, err := sink.NewDiskQueue(filepath.Join(dir, fmt.Sprintf("%s-%08x", kind, h.Sum32())), cCtx.Int64("queue-max-mb")*1024*1024)
+	if err != nil {
+		return err
+	}
+	if q.Len() > 0 {
+		fmt.Println(spec, "has", q.Len(), "batches queued from an earlier run")
+	}
+	out.Add(spec, sink.NewQueuedSink(s, q))
+	return nil
+}
+
+func (st *state) getSensorsToInflux(cCtx *cli.Context) error {
+	if cCtx.Bool("dry-run") {
+		return st.dryRun(cCtx)
+	}
+	influx, err := influxSinkFromEnv()
+	if err != nil {
+		return err
+	}
+	out := sink.NewFanout()
+	if err := addSink(cCtx, out, "influx", "influx", influx); err != nil {
+		return err
+	}
+	return st.poll(cCtx, out)
+}
+
+// getSpecs parses the specs given with the flag name, or returns the profile's when the flag
+// isn't set. The profile's are already split into options, as their values may have commas.
+func getSpecs(cCtx *cli.Context, name string, specs []config.Spec) ([]config.Spec, error) {
+	if !cCtx.IsSet(name) {
+		return specs, nil
+	}
+	specs = nil
+	for _, spec := range cCtx.StringSlice(name) {
+		kind, options, err := sink.ParseSpec(spec)
+		if err != nil {
+			return nil, err
 		}
+		specs = append(specs, config.Spec{Kind: kind, Options: options})
+	}
+	return specs, nil
+}
 
-		line += fmt.Sprintf(" %d", int(s.Timestamp*1e9))
-		fmt.Println(line)
-		url := fmt.Sprintf("http://%s:%d/write?db=%s", influx.host, influx.port, influx.database)
-		reader := strings.NewReader(line)
-		req, err := http.NewRequest("POST", url, reader)
+// addSinkSpecs adds a sink to out for each --sink, or each of the profile's sinks
+func (st *state) addSinkSpecs(cCtx *cli.Context, out *sink.Fanout) error {
+	var sinks []config.Spec
+	if st.profile != nil {
+		sinks = st.profile.Sinks
+	}
+	specs, err := getSpecs(cCtx, "sink", sinks)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		var s sink.Sink
+		if spec.Kind == "influx" && len(spec.Options) == 0 {
+			s, err = influxSinkFromEnv()
+		} else {
+			s, err = sink.New(spec.Kind, spec.Options)
+		}
 		if err != nil {
-			fmt.Println(err)
+			return err
+		}
+		if err := addSink(cCtx, out, spec.Kind, spec.String(), s); err != nil {
+			return err
 		}
-		influx.HTTPClient.Do(req)
 	}
 	return nil
 }
 
-func getSensorsToInflux(cCtx *cli.Context) error {
-	host := os.Getenv("INFLUXDB_HOST")
-	portstr := os.Getenv("INFLUXDB_PORT")
-	db := os.Getenv("INFLUXDB_DB")
-	measurement := os.Getenv("INFLUX_MEASUREMENT_NAME")
-	loc := os.Getenv("INFLUX_LOCATION_TAG")
-	if measurement == "" {
-		return fmt.Errorf("measurement name must be set via env INFLUX_MEASUREMENT_NAME")
+// getSensorsToSinks polls to every sink given with --sink
+func (st *state) getSensorsToSinks(cCtx *cli.Context) error {
+	if cCtx.Bool("dry-run") {
+		return st.dryRun(cCtx)
 	}
-	if loc == "" {
-		return fmt.Errorf("location tag value must be set via env INFLUX_LOCATION_TAG")
+	out := sink.NewFanout()
+	if err := st.addSinkSpecs(cCtx, out); err != nil {
+		return err
 	}
-	tags := map[string]string{"location": loc}
+	if out.Len() == 0 {
+		return fmt.Errorf("at least one --sink is required, one of %s", strings.Join(sink.Kinds(), ", "))
+	}
+	return st.poll(cCtx, out)
+}
+
+// serve polls like the poll command, and with --metrics also serves the latest samples and
+// api client metrics for Prometheus to scrape
+func (st *state) serve(cCtx *cli.Context) error {
+	if cCtx.Bool("dry-run") {
+		return st.dryRun(cCtx)
+	}
+	out := sink.NewFanout()
+	if err := st.addSinkSpecs(cCtx, out); err != nil {
+		return err
+	}
+	if cCtx.Bool("metrics") {
+		st.metrics = exporter.NewClientMetrics()
+		st.exp = exporter.NewExporter(cCtx.String("location"), st.metrics)
+		out.Add("metrics", st.exp)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", st.exp)
+		// listen before polling so a busy port is reported straight away
+		l, err := net.Listen("tcp", cCtx.String("listen"))
+		if err != nil {
+			return err
+		}
+		go func() {
+			log.Fatal(http.Serve(l, mux))
+		}()
+		fmt.Println("serving metrics on", l.Addr().String()+"/metrics")
+	}
+	if out.Len() == 0 {
+		return fmt.Errorf("--metrics or at least one --sink is required")
+	}
+	return st.poll(cCtx, out)
+}
+
+// getScheduler times polls every interval, or just after each of PurpleAir's refreshes with
+// --adaptive, spending at most points_per_day
+func getScheduler(cCtx *cli.Context, interval time.Duration, points_per_day int) *purpleair.Scheduler {
+	s := purpleair.NewScheduler()
+	if !cCtx.Bool("adaptive") {
+		s.Interval = interval
+	}
+	s.PointsPerDay = points_per_day
+	return s
+}
 
-	if host == "" || portstr == "" || db == "" {
-		return fmt.Errorf("host, port, and database are required. Set env INFLUXDB_HOST, INFLUXDB_PORT, INFLUXDB_DB")
+// poll gets samples every --poll-interval, or as PurpleAir refreshes with --adaptive, and
+// writes them to out. A sink that fails is logged and
+// tried again with the next samples, and doesn't hold up the others.
+func (st *state) poll(cCtx *cli.Context, out *sink.Fanout) error {
+	locations := st.profile != nil && len(st.profile.Locations) > 0
+	var meta *purpleair.MetadataCache
+	var backfill *purpleair.Backfiller
+	var err error
+	if locations {
+		if cCtx.String("backfill-state") != "" {
+			return fmt.Errorf("--backfill-state isn't supported with locations")
+		}
+	} else {
+		meta, err = st.getMetadataCache(cCtx)
+		if err != nil {
+			return err
+		}
+	}
+	notifiers, err := st.getNotifiers(cCtx, out)
+	if err != nil {
+		return err
 	}
-	port, err := strconv.Atoi(portstr)
+	alerts, err := st.getAlertEngine(cCtx, notifiers)
 	if err != nil {
 		return err
 	}
-	influxClient := NewInfluxClient(host, port, db, "", "")
+	if alerts != nil {
+		out.Add("alerts", alerts)
+	}
+	if !locations {
+		// after the notifiers and alerts, which add to the fields polled
+		backfill, err = st.getBackfiller(cCtx)
+		if err != nil {
+			return err
+		}
+	}
+	if notifiers != nil {
+		notifiers.Start()
+		defer notifiers.Close()
+	}
+	out.OnError = func(name string, err error) {
+		fmt.Println("error publishing to", name, err)
+	}
+	// a sink that fails to open has been reported by OnError, and is opened again each poll
+	out.Open()
+	defer out.Close()
+	if locations {
+		return st.pollLocations(cCtx, out)
+	}
+	// with a queue a sink that's down still has the samples, so only a failed write is a gap
+	queued := cCtx.String("queue-dir") != ""
+	sched := getScheduler(cCtx, cCtx.Duration("poll-interval"), cCtx.Int("points-per-day"))
+	st.scheds = append(st.scheds, sched)
+	st.watchBalance(cCtx)
 	sleep_time := 1 * time.Second
 	for 1 < 2 {
-		samples, err := getSamples(cCtx)
+		r, samples, err := st.getSamples(cCtx, meta)
 		if err != nil {
 			fmt.Println("error getting sensors", err)
-			sleep_time = time.Duration(rand.Float32()*20.0+5.0) * time.Second
+			sleep_time = retryWait(err)
 		} else {
-			err := publishInfluxDb(influxClient, measurement, tags, samples)
-			if err != nil {
-				fmt.Println("error publishing to InfluxdB")
-				sleep_time = time.Duration(rand.Float32()*20.0+5.0) * time.Second
-			} else {
-				sleep_time = 1 * time.Minute
+			if st.exp != nil {
+				st.exp.Polled()
+			}
+			// errors have already been reported per sink by OnError
+			werr := out.Write(samples)
+			ferr := out.Flush()
+			if backfill != nil && werr == nil && (queued || ferr == nil) {
+				backfill.Observe(samples)
+				runBackfill(backfill, meta, getCorrections(cCtx), out)
+			}
+			sleep_time = sched.Next(r, r.Points())
+		}
+		fmt.Println(time.Now().Format(time.RFC3339) + fmt.Sprintf(" sleeping %s", sleep_time) + st.spentNote())
+		time.Sleep(sleep_time)
+	}
+	return nil
+}
+
+// locationPoller is one of the profile's locations, with its own sinks and metadata cache
+type locationPoller struct {
+	name     string
+	region   *purpleair.Region
+	tags     map[string]string
+	interval time.Duration
+	out      *sink.Fanout
+	meta     *purpleair.MetadataCache
+}
+
+// locationInterval is the location's poll_interval, or --poll-interval
+func locationInterval(cCtx *cli.Context, l config.Location) time.Duration {
+	if l.PollInterval != nil {
+		return time.Duration(*l.PollInterval)
+	}
+	return cCtx.Duration("poll-interval")
+}
+
+// locationGroup is locations whose regions overlap, polled every interval, the shortest of
+// theirs. locations are indexes into the locations given to groupLocations.
+type locationGroup struct {
+	locations []int
+	interval  time.Duration
+}
+
+// groupLocations groups the locations whose regions overlap, whatever their intervals
+func groupLocations(regions []*purpleair.Region, intervals []time.Duration) []locationGroup {
+	var groups []locationGroup
+	for _, g := range purpleair.GroupRegions(regions) {
+		group := locationGroup{locations: g.Regions}
+		for _, l := range g.Regions {
+			if group.interval == 0 || intervals[l] < group.interval {
+				group.interval = intervals[l]
 			}
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// dueLocations are the locations of a group polled every interval that are due at now, given
+// when each is next due. A location within half an interval of being due is polled now rather
+// than an interval late.
+func dueLocations(next []time.Time, now time.Time, interval time.Duration) []int {
+	var due []int
+	for i, t := range next {
+		if !now.Add(interval / 2).Before(t) {
+			due = append(due, i)
+		}
+	}
+	return due
+}
+
+// newLocationPoller opens the location's sinks. Its metadata cache, with --metadata-cache, is
+// the file with the location's name added, e.g. metadata-home.json.
+func newLocationPoller(cCtx *cli.Context, c *purpleair.Client, l config.Location) (*locationPoller, error) {
+	region, err := l.Region()
+	if err != nil {
+		return nil, err
+	}
+	lp := &locationPoller{
+		name:     l.Name,
+		region:   region,
+		tags:     l.SampleTags(),
+		interval: locationInterval(cCtx, l),
+		out:      sink.NewFanout(),
+	}
+	for _, spec := range l.Sinks {
+		var s sink.Sink
+		if spec.Kind == "influx" && len(spec.Options) == 0 {
+			s, err = influxSinkFromEnv()
+		} else {
+			s, err = sink.New(spec.Kind, spec.Options)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("location %s: %s", l.Name, err)
+		}
+		if err := addSink(cCtx, lp.out, spec.Kind, l.Name+" "+spec.String(), s); err != nil {
+			return nil, err
+		}
+	}
+	lp.out.OnError = func(name string, err error) {
+		fmt.Println("error publishing to", name, err)
+	}
+	if path := cCtx.String("metadata-cache"); path != "" {
+		ext := filepath.Ext(path)
+		path = strings.TrimSuffix(path, ext) + "-" + l.Name + ext
+		lp.meta = purpleair.NewMetadataCache(c, map[string]string{"location_type": "0"}, path)
+		lp.meta.Bounds = region.Bounds
+		if err := lp.meta.Load(); err != nil {
+			return nil, err
+		}
+	}
+	// as in poll, a sink that fails to open is tried again each poll
+	lp.out.Open()
+	return lp, nil
+}
+
+// write tags the location's samples and writes them to its sinks, and to out under mu
+func (lp *locationPoller) write(cCtx *cli.Context, samples []purpleair.SensorSample, out *sink.Fanout, mu *sync.Mutex) {
+	if lp.meta != nil {
+		if err := lp.meta.Update(); err != nil {
+			fmt.Println("error refreshing sensor metadata for", lp.name, err)
+		}
+		lp.meta.JoinSensorSamples(samples)
+	}
+	for _, c := range getCorrections(cCtx) {
+		c.Correct(samples)
+	}
+	for i := range samples {
+		for k, v := range lp.tags {
+			samples[i].Tags[k] = v
+		}
+	}
+	// errors have already been reported per sink by OnError
+	lp.out.Write(samples)
+	lp.out.Flush()
+	mu.Lock()
+	defer mu.Unlock()
+	out.Write(samples)
+	out.Flush()
+}
+
+// pollLocations polls each of the profile's locations on its own interval. Locations whose
+// regions overlap are polled together at the shortest of their intervals, and those due at the
+// same time share one query, so the sensors they have in common are only paid for once.
+// --points-per-day is shared equally between the groups. It doesn't return.
+func (st *state) pollLocations(cCtx *cli.Context, out *sink.Fanout) error {
+	if cCtx.String("readkey") == "" {
+		return fmt.Errorf("read key is required. Set --readkey or env PURPLEAIR_READ_KEY")
+	}
+	c, err := st.newClient(cCtx.String("readkey"), cCtx.String("writekey"))
+	if err != nil {
+		return err
+	}
+	lps := make([]*locationPoller, len(st.profile.Locations))
+	regions := make([]*purpleair.Region, len(lps))
+	intervals := make([]time.Duration, len(lps))
+	for i, l := range st.profile.Locations {
+		lp, err := newLocationPoller(cCtx, c, l)
+		if err != nil {
+			return err
+		}
+		defer lp.out.Close()
+		lps[i], regions[i], intervals[i] = lp, lp.region, lp.interval
+	}
+	groups := groupLocations(regions, intervals)
+	// out is shared by every group's goroutine
+	var mu sync.Mutex
+	for _, g := range groups {
+		group := make([]*locationPoller, len(g.locations))
+		for i, l := range g.locations {
+			group[i] = lps[l]
+		}
+		sched := getScheduler(cCtx, g.interval, cCtx.Int("points-per-day")/len(groups))
+		st.scheds = append(st.scheds, sched)
+		go st.pollGroup(cCtx, c, sched, group, out, &mu)
+	}
+	st.watchBalance(cCtx)
+	select {}
+}
 
+// pollGroup polls a group of locations when sched says, with one query of the locations due
+func (st *state) pollGroup(cCtx *cli.Context, c *purpleair.Client, sched *purpleair.Scheduler, group []*locationPoller, out *sink.Fanout, mu *sync.Mutex) {
+	if len(group) > 1 {
+		names := make([]string, len(group))
+		for i, lp := range group {
+			names[i] = lp.name
+		}
+		fmt.Println(strings.Join(names, ", "), "overlap and share queries")
+	}
+	// with --adaptive sched has no interval, and every location is polled each time
+	next := make([]time.Time, len(group))
+	for 1 < 2 {
+		var sleep_time time.Duration
+		now := time.Now()
+		due := dueLocations(next, now, sched.Interval)
+		names := make([]string, len(due))
+		regions := make([]*purpleair.Region, len(due))
+		for i, l := range due {
+			names[i] = group[l].name
+			regions[i] = group[l].region
 		}
-		fmt.Println(time.Now().Format(time.RFC3339) + fmt.Sprintf(" sleeping %s", sleep_time))
+		name := strings.Join(names, ", ")
+		responses, err := c.GetSensorsInRegions(st.defaultSensorParams(cCtx), regions)
+		if err != nil {
+			fmt.Println("error getting sensors for", name, err)
+			sleep_time = retryWait(err)
+		} else {
+			if st.exp != nil {
+				st.exp.Polled()
+			}
+			for i, l := range due {
+				group[l].write(cCtx, purpleair.SensorsToSensorSamples(responses[i]), out, mu)
+				if sched.Interval > 0 {
+					next[l] = now.Add(group[l].interval)
+				}
+			}
+			// each response has the cost of the one query
+			sleep_time = sched.Next(responses[0], responses[0].Points())
+		}
+		fmt.Println(time.Now().Format(time.RFC3339) + fmt.Sprintf(" %s sleeping %s", name, sleep_time) + st.spentNote())
 		time.Sleep(sleep_time)
 	}
+}
+
+func runBackfill(backfill *purpleair.Backfiller, meta *purpleair.MetadataCache, corrections []purpleair.Correction, out *sink.Fanout) {
+	if backfill.Disabled() != nil {
+		return
+	}
+	if len(backfill.Gaps()) == 0 {
+		backfill.Save()
+		return
+	}
+	points, err := backfill.Backfill(func(samples []purpleair.SensorSample) error {
+		if meta != nil {
+			meta.JoinSensorSamples(samples)
+		}
+		for _, c := range corrections {
+			c.Correct(samples)
+		}
+		if err := out.Write(samples); err != nil {
+			return err
+		}
+		return out.Flush()
+	})
+	if err != nil {
+		fmt.Println("error backfilling", err)
+	}
+	if points > 0 {
+		fmt.Println("backfilled with", points, "points,", backfill.Spent(), "today,", len(backfill.Gaps()), "gaps left")
+	}
+}
+
+// getRegion is the --area, or the circle of --range-km around --lat,--lon
+func getRegion(cCtx *cli.Context) (*purpleair.Region, error) {
+	if path := cCtx.String("area"); path != "" {
+		area, err := purpleair.LoadGeoJSONArea(path)
+		if err != nil {
+			return nil, err
+		}
+		return purpleair.NewAreaRegion(area)
+	}
+	if !cCtx.IsSet("lat") || !cCtx.IsSet("lon") || !cCtx.IsSet("range-km") {
+		return nil, fmt.Errorf("lat,lon and range in km, or an area, are required. Set --lat, --lon, --range-km or --area")
+	}
+	return purpleair.NewCircleRegion(cCtx.Float64("lat"), cCtx.Float64("lon"), cCtx.Float64("range-km"))
+}
+
+// dryRun prints the queries a command would make and the points each is expected to cost,
+// without making them. The sensors a query returns are expected to be as many as it last
+// returned, with --points-state, or as many as are in the metadata cache.
+func (st *state) dryRun(cCtx *cli.Context) error {
+	tracker := st.points
+	if tracker == nil {
+		tracker = purpleair.NewPointsTracker("")
+	}
+	polls := cCtx.Command.Name != "sensors"
+	// each group of locations polled together, or just the one query
+	type dryGroup struct {
+		names     []string
+		regions   []*purpleair.Region
+		intervals []time.Duration
+		interval  time.Duration
+	}
+	var groups []dryGroup
+	cached := -1
+	if polls && st.profile != nil && len(st.profile.Locations) > 0 {
+		all := make([]*purpleair.Region, len(st.profile.Locations))
+		every := make([]time.Duration, len(st.profile.Locations))
+		for i, l := range st.profile.Locations {
+			r, err := l.Region()
+			if err != nil {
+				return err
+			}
+			all[i], every[i] = r, locationInterval(cCtx, l)
+		}
+		for _, g := range groupLocations(all, every) {
+			group := dryGroup{interval: g.interval}
+			for _, l := range g.locations {
+				group.names = append(group.names, st.profile.Locations[l].Name)
+				group.regions = append(group.regions, all[l])
+				group.intervals = append(group.intervals, every[l])
+			}
+			groups = append(groups, group)
+		}
+	} else {
+		r, err := getRegion(cCtx)
+		if err != nil {
+			return err
+		}
+		interval := cCtx.Duration("poll-interval")
+		groups = append(groups, dryGroup{
+			names:     []string{"query"},
+			regions:   []*purpleair.Region{r},
+			intervals: []time.Duration{interval},
+			interval:  interval,
+		})
+		// without a read key there's no cache, just no estimate from it
+		if meta, err := st.getMetadataCache(cCtx); err == nil && meta != nil && meta.Len() > 0 {
+			cached = meta.Len()
+		}
+	}
+	points_per_day := cCtx.Int("points-per-day")
+	total := 0
+	for _, g := range groups {
+		// the locations each poll of a day queries, and how many polls query them
+		var polled [][]int
+		counts := make(map[string]int)
+		every := g.interval
+		if !polls || cCtx.Bool("adaptive") {
+			if cCtx.Bool("adaptive") {
+				every = purpleair.NewScheduler().Period
+			}
+			all := make([]int, len(g.regions))
+			for i := range all {
+				all[i] = i
+			}
+			polled = [][]int{all}
+			counts[fmt.Sprint(all)] = 1
+			if polls {
+				counts[fmt.Sprint(all)] = int(24 * time.Hour / every)
+			}
+		} else {
+			start := time.Now()
+			next := make([]time.Time, len(g.regions))
+			for at := start; at.Before(start.Add(24 * time.Hour)); at = at.Add(every) {
+				due := dueLocations(next, at, every)
+				for _, l := range due {
+					next[l] = at.Add(g.intervals[l])
+				}
+				key := fmt.Sprint(due)
+				if counts[key] == 0 {
+					polled = append(polled, due)
+				}
+				counts[key]++
+			}
+		}
+		per_day := 0
+		known := true
+		for _, due := range polled {
+			var names []string
+			var regions []*purpleair.Region
+			for _, l := range due {
+				names = append(names, g.names[l])
+				regions = append(regions, g.regions[l])
+			}
+			name := strings.Join(names, ", ")
+			points := 0
+			for _, q := range purpleair.SensorsQueries(st.defaultSensorParams(cCtx), regions) {
+				fields := strings.Split(q["fields"], ",")
+				sensors, ok := tracker.Sensors("/sensors", q)
+				from := "as last requested"
+				if !ok && cached >= 0 {
+					sensors, ok, from = cached, true, "in the metadata cache"
+				}
+				if !ok {
+					known = false
+					fmt.Printf("%s: %d points a sensor for %s, sensors unknown\n", name, purpleair.EstimatePoints(fields, 1), q["fields"])
+					continue
+				}
+				points += purpleair.EstimatePoints(fields, sensors)
+				fmt.Printf("%s: %d points for %d sensors %s, for %s\n", name, purpleair.EstimatePoints(fields, sensors), sensors, from, q["fields"])
+			}
+			if polls && len(polled) > 1 {
+				fmt.Printf("%s: queried %d times a day\n", name, counts[fmt.Sprint(due)])
+			}
+			per_day += points * counts[fmt.Sprint(due)]
+		}
+		if !polls || !known {
+			continue
+		}
+		if limit := points_per_day / len(groups); points_per_day > 0 && per_day > limit {
+			per_day = limit
+		}
+		total += per_day
+		fmt.Printf("%s: about %d points a day polling every %s\n", strings.Join(g.names, ", "), per_day, every)
+	}
+	if polls && total > 0 {
+		fmt.Printf("about %d points a day in all\n", total)
+		if budget := cCtx.Int("budget-daily"); budget > 0 && total > budget {
+			fmt.Printf("over --budget-daily of %d, polls will stop when it's spent until the next day\n", budget)
+		}
+		if budget := cCtx.Int("budget-monthly"); budget > 0 && total*30 > budget {
+			fmt.Printf("about %d points a month is over --budget-monthly of %d\n", total*30, budget)
+		}
+	}
 	return nil
 }
 
-func main() {
+// checkKeys reports what kind of key each read and write key is, and whether the read keys may
+// use the endpoints the same flags and profile would poll with: /sensors, /organization unless
+// --balance-interval is 0, and a sensor's history with --backfill-state, of --history or the
+// first sensor it tracks. It shows each read key's organization and the points it has left.
+func (st *state) checkKeys(cCtx *cli.Context) error {
+	type check struct {
+		name   string
+		key    string
+		expect string
+	}
+	var checks []check
+	for _, k := range st.readKeys {
+		checks = append(checks, check{k.Name, k.Key, "READ"})
+	}
+	if len(checks) == 0 && cCtx.String("readkey") != "" {
+		checks = append(checks, check{"readkey", cCtx.String("readkey"), "READ"})
+	}
+	if cCtx.String("writekey") != "" {
+		checks = append(checks, check{"writekey", cCtx.String("writekey"), "WRITE"})
+	}
+	if len(checks) == 0 {
+		return fmt.Errorf("no keys to check. Set --readkey, --writekey or env PURPLEAIR_READ_KEY, PURPLEAIR_WRITE_KEY")
+	}
+	c, err := purpleair.NewClient(checks[0].key, "")
+	if err != nil {
+		return err
+	}
+	organization := cCtx.Duration("balance-interval") > 0
+	history := cCtx.Int("history")
+	if history == 0 && cCtx.String("backfill-state") != "" {
+		b, err := st.getBackfiller(cCtx)
+		if err != nil {
+			return err
+		}
+		if sensors := b.Sensors(); len(sensors) > 0 {
+			history = sensors[0]
+		} else {
+			fmt.Println("--backfill-state tracks no sensors yet, set --history <sensor_index> to check the read keys may get history")
+		}
+	}
+	failed := 0
+	for _, k := range checks {
+		info, err := c.GetKeyInfo(k.key)
+		if err != nil {
+			fmt.Printf("%s: %s\n", k.name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: %s key, api %s, checked at %s\n", k.name, info.Type, info.APIVersion, info.Time().Format(time.RFC3339))
+		if info.Type != k.expect {
+			fmt.Printf("  expected a %s key\n", k.expect)
+			failed++
+			continue
+		}
+		if k.expect != "READ" {
+			continue
+		}
+		endpoints := []string{"/sensors"}
+		errs := []error{c.CheckSensors(k.key)}
+		var org *purpleair.Organization
+		if organization {
+			org, err = c.GetKeyOrganization(k.key)
+			endpoints = append(endpoints, "/organization")
+			errs = append(errs, err)
+		}
+		if history > 0 {
+			endpoints = append(endpoints, fmt.Sprintf("/sensors/%d/history", history))
+			errs = append(errs, c.CheckHistory(k.key, history))
+		}
+		for i, err := range errs {
+			if err != nil {
+				var apiErr *purpleair.ResponseError
+				if errors.As(err, &apiErr) {
+					err = apiErr
+				}
+				fmt.Printf("  %s: %s\n", endpoints[i], err)
+				failed++
+			} else if endpoints[i] == "/organization" {
+				fmt.Printf("  /organization: %s, %d points left\n", org.Name, org.RemainingPoints)
+			} else {
+				fmt.Printf("  %s: ok\n", endpoints[i])
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
 
+func main() {
+	st := &state{}
+	// the flags of every command that queries sensors
+	sensorFlags := []cli.Flag{
+		&cli.StringFlag{Name: "area", EnvVars: []string{"PURPLEAIR_AREA"}, Usage: "GeoJSON Polygon or MultiPolygon file to query instead of lat,lon,range"},
+		&cli.Float64Flag{Name: "lat", EnvVars: []string{"PURPLEAIR_LATITUDE"}, Usage: "latitude in degrees of the center of the sensors to query"},
+		&cli.Float64Flag{Name: "lon", EnvVars: []string{"PURPLEAIR_LONGITUDE"}, Usage: "longitude in degrees of the center of the sensors to query"},
+		&cli.Float64Flag{Name: "range-km", EnvVars: []string{"PURPLEAIR_RANGE_KM"}, Usage: "distance from lat,lon to query sensors within"},
+		&cli.StringFlag{Name: "fields", EnvVars: []string{"PURPLEAIR_FIELDS"}, Usage: "comma separated fields to request instead of the defaults"},
+		&cli.StringFlag{Name: "corrections", EnvVars: []string{"PURPLEAIR_CORRECTIONS"}, Usage: "comma separated corrections to add to samples: epa adds pm2.5_corrected"},
+		&cli.StringFlag{Name: "metadata-cache", EnvVars: []string{"PURPLEAIR_METADATA_CACHE"}, Usage: "file to cache sensor names and locations in, which are added to samples as tags"},
+		&cli.BoolFlag{Name: "dry-run", Usage: "print the points each query is expected to cost, without requesting it"},
+	}
+	// and of those that poll
+	pollFlags := append(append([]cli.Flag{}, sensorFlags...),
+		&cli.DurationFlag{Name: "poll-interval", Value: time.Minute, EnvVars: []string{"PURPLEAIR_POLL_INTERVAL"}, Usage: "time to wait between polls"},
+		&cli.BoolFlag{Name: "adaptive", EnvVars: []string{"PURPLEAIR_ADAPTIVE"}, Usage: "instead of --poll-interval, poll just after PurpleAir is expected to refresh its data, learned from data_time_stamp"},
+		&cli.IntFlag{Name: "points-per-day", EnvVars: []string{"PURPLEAIR_POINTS_PER_DAY"}, Usage: "api points polling may spend per day, polls are spaced out to stay within it, 0 for no limit"},
+		&cli.StringFlag{Name: "queue-dir", EnvVars: []string{"PURPLEAIR_QUEUE_DIR"}, Usage: "directory to queue samples in while a sink is down, replayed in order when it's back"},
+		&cli.Int64Flag{Name: "queue-max-mb", Value: 64, EnvVars: []string{"PURPLEAIR_QUEUE_MAX_MB"}, Usage: "size each sink's queue may grow to before the oldest samples are dropped"},
+		&cli.StringFlag{Name: "backfill-state", EnvVars: []string{"PURPLEAIR_BACKFILL_STATE"}, Usage: "file to track written samples in, gaps are filled from the history api (needs a key with history access)"},
+		&cli.IntFlag{Name: "backfill-average", Value: 10, EnvVars: []string{"PURPLEAIR_BACKFILL_AVERAGE"}, Usage: "history averaging period in minutes: 0, 10, 30, 60, 360 or 1440"},
+		&cli.IntFlag{Name: "backfill-max-points", Value: 20000, EnvVars: []string{"PURPLEAIR_BACKFILL_MAX_POINTS"}, Usage: "api points to spend on backfill per day, 0 for no limit"},
+		&cli.StringFlag{Name: "alert-rules", EnvVars: []string{"PURPLEAIR_ALERT_RULES"}, Usage: "YAML or JSON file of alert rules to evaluate against each poll"},
+		&cli.StringFlag{Name: "alert-state", EnvVars: []string{"PURPLEAIR_ALERT_STATE"}, Usage: "file to keep alert state in across restarts"},
+		&cli.StringSliceFlag{Name: "notify", EnvVars: []string{"PURPLEAIR_NOTIFY"}, Usage: "kind:key=value,... notifier to send alerts and digests to, may be repeated"},
+	)
 	app := &cli.App{
 		Name:  "purpleair",
 		Usage: "interact with the purpleair api",
@@ -226,18 +1409,88 @@ func main() {
 				Name:    "influx",
 				Aliases: []string{"s"},
 				Usage:   "get sensors from the purpleair api and post to influx",
-				Action:  getSensorsToInflux,
+				Action:  st.getSensorsToInflux,
+				Before:  st.applyConfig,
+				Flags:   pollFlags,
 			},
 			{
 				Name:    "sensors",
 				Aliases: []string{"s"},
 				Usage:   "get sensors from the purpleair api and print JSON",
-				Action:  getSensorsToJson,
+				Action:  st.getSensorsToJson,
+				Before:  st.applyConfig,
+				Flags:   sensorFlags,
+			},
+			{
+				Name:   "poll",
+				Usage:  "get sensors from the purpleair api every poll interval and write them to each sink",
+				Action: st.getSensorsToSinks,
+				Before: st.applyConfig,
+				Flags: append([]cli.Flag{
+					&cli.StringSliceFlag{Name: "sink", EnvVars: []string{"PURPLEAIR_SINKS"}, Usage: "kind:key=value,... sink to write to, may be repeated. influx with no options uses the influx command's env"},
+				}, pollFlags...),
+			},
+			{
+				Name:   "serve",
+				Usage:  "poll like the poll command, and serve Prometheus metrics with --metrics",
+				Action: st.serve,
+				Before: st.applyConfig,
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{Name: "metrics", Usage: "serve the latest sample of each sensor and api client metrics at /metrics"},
+					&cli.StringFlag{Name: "listen", Value: ":9101", EnvVars: []string{"PURPLEAIR_METRICS_LISTEN"}, Usage: "address to serve metrics on"},
+					&cli.StringFlag{Name: "location", EnvVars: []string{"PURPLEAIR_LOCATION", "INFLUX_LOCATION_TAG"}, Usage: "location label for sensors without a location tag"},
+					&cli.StringSliceFlag{Name: "sink", EnvVars: []string{"PURPLEAIR_SINKS"}, Usage: "kind:key=value,... sink to write to, may be repeated. influx with no options uses the influx command's env"},
+				}, pollFlags...),
+			},
+			{
+				Name:   "nearest",
+				Usage:  "find the sensors closest to a location and print JSON sorted by distance",
+				Action: st.getNearestSensors,
+				Before: st.applyConfig,
+				Flags: []cli.Flag{
+					&cli.Float64Flag{Name: "lat", Required: true, Usage: "latitude in degrees"},
+					&cli.Float64Flag{Name: "lon", Required: true, Usage: "longitude in degrees"},
+					&cli.IntFlag{Name: "n", Value: 5, Usage: "number of sensors to find"},
+					&cli.Float64Flag{Name: "max-range-km", Value: 50, Usage: "stop searching past this distance"},
+					&cli.IntFlag{Name: "max-points", Value: 0, Usage: "approximate api points to spend, 0 for no limit"},
+					&cli.StringFlag{Name: "fields", Value: "pm2.5_alt,pm2.5", Usage: "comma separated fields to return"},
+				},
+			},
+			{
+				Name:   "keys",
+				Usage:  "check the read and write keys, and which endpoints the read keys may use",
+				Action: st.checkKeys,
+				Before: st.applyConfig,
+				Flags: append([]cli.Flag{
+					&cli.IntFlag{Name: "history", Usage: "sensor index to check the read keys may get the history of, instead of one --backfill-state tracks"},
+				}, pollFlags...),
+			},
+			{
+				Name:  "config",
+				Usage: "work with the --config file",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "validate",
+						Usage:  "check every profile in the config file and report all the errors",
+						Action: validateConfig,
+					},
+				},
 			},
 		},
 		Flags: []cli.Flag{
-			&cli.StringFlag{Name: "readkey", Aliases: []string{"r"}},
-			&cli.StringFlag{Name: "writekey", Aliases: []string{"w"}},
+			&cli.StringFlag{Name: "readkey", Aliases: []string{"r"}, EnvVars: []string{"PURPLEAIR_READ_KEY"}, Usage: "purpleair api read key"},
+			&cli.StringFlag{Name: "writekey", Aliases: []string{"w"}, EnvVars: []string{"PURPLEAIR_WRITE_KEY"}, Usage: "purpleair api write key"},
+			&cli.StringSliceFlag{Name: "readkeys", EnvVars: []string{"PURPLEAIR_READ_KEYS"}, Usage: "name=key read keys to take turns with as well as --readkey, may be repeated"},
+			&cli.StringSliceFlag{Name: "readkey-file", EnvVars: []string{"PURPLEAIR_READ_KEY_FILE"}, Usage: "file holding a read key, such as a Docker secret, named after the file, may be repeated"},
+			&cli.StringFlag{Name: "writekey-file", EnvVars: []string{"PURPLEAIR_WRITE_KEY_FILE"}, Usage: "file holding the write key, such as a Docker secret"},
+			&cli.StringFlag{Name: "key-rotation", Value: "round-robin", EnvVars: []string{"PURPLEAIR_KEY_ROTATION"}, Usage: "how read keys take turns: round-robin, or budget to use the key with the most of its budget left"},
+			&cli.StringSliceFlag{Name: "key-budget", EnvVars: []string{"PURPLEAIR_KEY_BUDGETS"}, Usage: "name=points a read key may spend per UTC day, may be repeated"},
+			&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"PURPLEAIR_CONFIG"}, Usage: "YAML file of profiles, which flags and env override"},
+			&cli.StringFlag{Name: "profile", Aliases: []string{"p"}, EnvVars: []string{"PURPLEAIR_PROFILE"}, Usage: "profile to use from --config, instead of its default"},
+			&cli.IntFlag{Name: "budget-daily", EnvVars: []string{"PURPLEAIR_BUDGET_DAILY"}, Usage: "api points to spend per UTC day, requests that would go over are refused, 0 for no limit"},
+			&cli.IntFlag{Name: "budget-monthly", EnvVars: []string{"PURPLEAIR_BUDGET_MONTHLY"}, Usage: "api points to spend per UTC month, requests that would go over are refused, 0 for no limit"},
+			&cli.DurationFlag{Name: "balance-interval", Value: time.Hour, EnvVars: []string{"PURPLEAIR_BALANCE_INTERVAL"}, Usage: "how often to check the points left with /organization, for polling, the budgets and metrics, 0 to not"},
+			&cli.StringFlag{Name: "points-state", EnvVars: []string{"PURPLEAIR_POINTS_STATE"}, Usage: "file to keep the points spent in across restarts, and how many sensors each query returns"},
 		},
 	}
 
@@ -0,0 +1,232 @@
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+// SensorDigest sums up one sensor's day. AQI is the US EPA AQI of pm2.5_alt.
+type SensorDigest struct {
+	SensorIndex int
+	Name        string
+	Samples     int
+	MinAqi      int
+	MeanAqi     float64
+	MaxAqi      int
+	// Hours is the time spent in each AQI category, indexed by purpleair.AqiCategory
+	Hours [6]float64
+	// QA issues: samples with a downgraded channel, and with a confidence below the digest's
+	// MinConfidence
+	Downgraded    int
+	LowConfidence int
+
+	sum float64
+}
+
+// HasQAIssues reports whether any of the sensor's samples failed QA
+func (s SensorDigest) HasQAIssues() bool {
+	return s.Downgraded > 0 || s.LowConfidence > 0
+}
+
+// DigestReport is the digest of every sensor between Start and End
+type DigestReport struct {
+	Start   time.Time
+	End     time.Time
+	Sensors []SensorDigest // by sensor_index
+}
+
+// Digest is a sink that sums up samples per sensor, and once a day at At after midnight in
+// Location hands the report to OnReport and starts over. Each sample's time counts towards its
+// AQI category for as long as since the sensor's previous sample, but at most MaxGap, so a
+// sensor that went quiet isn't counted in its last category all the while.
+//
+// OnReport is called from a goroutine, so a slow mail server doesn't hold up the other sinks. A
+// report it fails is kept and handed to it again by a Write at least RetryWait later, and the
+// error is returned by the next Write.
+type Digest struct {
+	At            time.Duration
+	Location      *time.Location
+	MinConfidence float64
+	MaxGap        time.Duration
+	RetryWait     time.Duration
+	OnReport      func(DigestReport) error
+
+	now     func() time.Time
+	mu      sync.Mutex
+	start   time.Time
+	next    time.Time
+	last    map[int]time.Time
+	sensors map[int]*SensorDigest
+	pending []DigestReport // for OnReport, oldest first
+	sending bool
+	retryAt time.Time
+	lastErr error
+	wg      sync.WaitGroup
+}
+
+func NewDigest(at time.Duration, location *time.Location) *Digest {
+	return &Digest{
+		At:            at,
+		Location:      location,
+		MinConfidence: 90,
+		MaxGap:        10 * time.Minute,
+		RetryWait:     5 * time.Minute,
+		now:           time.Now,
+		last:          make(map[int]time.Time),
+		sensors:       make(map[int]*SensorDigest),
+	}
+}
+
+// nextReport is the first report time after t
+func (d *Digest) nextReport(t time.Time) time.Time {
+	local := t.In(d.Location)
+	at := int(d.At / time.Minute)
+	next := time.Date(local.Year(), local.Month(), local.Day(), at/60, at%60, 0, 0, d.Location)
+	if !next.After(t) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, at/60, at%60, 0, 0, d.Location)
+	}
+	return next
+}
+
+func (d *Digest) Open() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	d.start = now
+	d.next = d.nextReport(now)
+	return nil
+}
+
+// Fields are the api fields the digest sums up
+func (d *Digest) Fields() []string {
+	return []string{"pm2.5_alt", "channel_flags", "confidence"}
+}
+
+func (d *Digest) add(s purpleair.SensorSample) {
+	pm, ok := s.Fields["pm2.5_alt"]
+	if !ok {
+		return
+	}
+	prev, seen := d.last[s.SensorIndex]
+	if seen && !s.Time.After(prev) {
+		return
+	}
+	d.last[s.SensorIndex] = s.Time
+	sd, ok := d.sensors[s.SensorIndex]
+	if !ok {
+		sd = &SensorDigest{SensorIndex: s.SensorIndex, MinAqi: 500}
+		d.sensors[s.SensorIndex] = sd
+	}
+	if name := s.Tags["name"]; name != "" {
+		sd.Name = name
+	}
+	aqi := purpleair.Pm25ToAqi(pm)
+	sd.Samples++
+	sd.sum += float64(aqi)
+	sd.MeanAqi = sd.sum / float64(sd.Samples)
+	if aqi < sd.MinAqi {
+		sd.MinAqi = aqi
+	}
+	if aqi > sd.MaxAqi {
+		sd.MaxAqi = aqi
+	}
+	if seen {
+		gap := s.Time.Sub(prev)
+		if gap > d.MaxGap {
+			gap = d.MaxGap
+		}
+		sd.Hours[purpleair.AqiToCategory(aqi)] += gap.Hours()
+	}
+	if v, ok := s.Fields["channel_flags"]; ok && purpleair.ChannelFlags(v) != purpleair.ChannelsNormal {
+		sd.Downgraded++
+	}
+	if v, ok := s.Fields["confidence"]; ok && v < d.MinConfidence {
+		sd.LowConfidence++
+	}
+}
+
+// Write adds the samples, and reports if it's time to, or sends a report that failed again.
+// It returns the error of the last failed report.
+func (d *Digest) Write(samples []purpleair.SensorSample) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range samples {
+		d.add(s)
+	}
+	now := d.now()
+	if !d.next.IsZero() && !now.Before(d.next) {
+		report := d.reportLocked(now)
+		d.next = d.nextReport(now)
+		if d.OnReport != nil {
+			d.pending = append(d.pending, report)
+		}
+	}
+	if len(d.pending) > 0 && !d.sending && !now.Before(d.retryAt) {
+		d.sending = true
+		d.wg.Add(1)
+		go d.send()
+	}
+	err := d.lastErr
+	d.lastErr = nil
+	return err
+}
+
+// send hands the pending reports to OnReport in order, until one fails
+func (d *Digest) send() {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		if len(d.pending) == 0 {
+			d.sending = false
+			d.mu.Unlock()
+			return
+		}
+		report := d.pending[0]
+		d.mu.Unlock()
+		err := d.OnReport(report)
+		d.mu.Lock()
+		if err != nil {
+			d.lastErr = fmt.Errorf("digest up to %s kept to send again: %w", report.End.Format(time.RFC3339), err)
+			d.retryAt = d.now().Add(d.RetryWait)
+			d.sending = false
+			d.mu.Unlock()
+			return
+		}
+		d.pending = d.pending[1:]
+		d.mu.Unlock()
+	}
+}
+
+// Report returns the digest since the last report and starts a new one
+func (d *Digest) Report() DigestReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.reportLocked(d.now())
+}
+
+func (d *Digest) reportLocked(end time.Time) DigestReport {
+	r := DigestReport{Start: d.start, End: end, Sensors: make([]SensorDigest, 0, len(d.sensors))}
+	for _, sd := range d.sensors {
+		r.Sensors = append(r.Sensors, *sd)
+	}
+	sort.Slice(r.Sensors, func(i, j int) bool { return r.Sensors[i].SensorIndex < r.Sensors[j].SensorIndex })
+	d.sensors = make(map[int]*SensorDigest)
+	d.start = end
+	return r
+}
+
+func (d *Digest) Flush() error {
+	return nil
+}
+
+// Close waits for a report being sent
+func (d *Digest) Close() error {
+	d.wg.Wait()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lastErr
+}
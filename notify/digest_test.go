@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigestNextReport(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	assert.Nil(t, err)
+	d := NewDigest(7*time.Hour+30*time.Minute, la)
+	assert.Equal(t, time.Date(2022, 9, 26, 7, 30, 0, 0, la), d.nextReport(time.Date(2022, 9, 26, 7, 0, 0, 0, la)))
+	assert.Equal(t, time.Date(2022, 9, 27, 7, 30, 0, 0, la), d.nextReport(time.Date(2022, 9, 26, 7, 30, 0, 0, la)))
+	// the day clocks go back is 25 hours long
+	assert.Equal(t, time.Date(2022, 11, 6, 7, 30, 0, 0, la), d.nextReport(time.Date(2022, 11, 5, 8, 0, 0, 0, la)))
+}
+
+func TestDigestGapsAndQA(t *testing.T) {
+	now := time.Date(2022, 9, 26, 12, 0, 0, 0, time.UTC)
+	d := NewDigest(0, time.UTC)
+	d.now = func() time.Time { return now }
+	var reports []DigestReport
+	d.OnReport = func(r DigestReport) error {
+		reports = append(reports, r)
+		return nil
+	}
+	assert.Nil(t, d.Open())
+	write := func(at time.Time, pm25 float64, confidence float64) {
+		s := purpleair.NewSensorSample(15111, at)
+		s.Fields["pm2.5_alt"] = pm25
+		s.Fields["confidence"] = confidence
+		assert.Nil(t, d.Write([]purpleair.SensorSample{*s}))
+	}
+	write(now, 5, 100)
+	write(now.Add(2*time.Minute), 5, 100)
+	// an hour without samples only counts MaxGap
+	write(now.Add(62*time.Minute), 40, 50)
+	// repeated and older samples are ignored
+	write(now.Add(62*time.Minute), 40, 50)
+	write(now.Add(30*time.Minute), 40, 50)
+
+	r := d.Report()
+	assert.Empty(t, reports)
+	assert.Equal(t, 1, len(r.Sensors))
+	s := r.Sensors[0]
+	assert.Equal(t, 3, s.Samples)
+	assert.InDelta(t, 2.0/60, s.Hours[purpleair.AqiGood], 1e-9)
+	assert.InDelta(t, 10.0/60, s.Hours[purpleair.AqiUnhealthyForSensitiveGroups], 1e-9)
+	assert.Equal(t, 1, s.LowConfidence)
+	assert.True(t, s.HasQAIssues())
+	assert.Empty(t, d.Report().Sensors)
+
+	// at midnight the day's report is handed on
+	now = time.Date(2022, 9, 27, 0, 1, 0, 0, time.UTC)
+	write(now, 5, 100)
+	assert.Nil(t, d.Close())
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, now, reports[0].End)
+	assert.Equal(t, 1, reports[0].Sensors[0].Samples)
+}
+
+func TestDigestReportFails(t *testing.T) {
+	now := time.Date(2022, 9, 26, 23, 0, 0, 0, time.UTC)
+	d := NewDigest(0, time.UTC)
+	d.now = func() time.Time { return now }
+	var reports []DigestReport
+	fail := true
+	d.OnReport = func(r DigestReport) error {
+		if fail {
+			return errors.New("connection refused")
+		}
+		reports = append(reports, r)
+		return nil
+	}
+	assert.Nil(t, d.Open())
+	s := purpleair.NewSensorSample(15111, now)
+	s.Fields["pm2.5_alt"] = 5
+	assert.Nil(t, d.Write([]purpleair.SensorSample{*s}))
+
+	// the failed report is kept, and its error returned by the next write
+	now = time.Date(2022, 9, 27, 0, 1, 0, 0, time.UTC)
+	assert.Nil(t, d.Write(nil))
+	assert.ErrorContains(t, d.Close(), "connection refused")
+	assert.ErrorContains(t, d.Write(nil), "connection refused")
+	assert.Nil(t, d.Write(nil))
+
+	// and sent again after RetryWait
+	fail = false
+	now = now.Add(d.RetryWait)
+	assert.Nil(t, d.Write(nil))
+	assert.Nil(t, d.Close())
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, 1, reports[0].Sensors[0].Samples)
+	assert.Equal(t, now.Add(-d.RetryWait), reports[0].End)
+}
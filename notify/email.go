@@ -0,0 +1,330 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/alert"
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+func init() {
+	Register("email", newEmailFromOptions)
+}
+
+// Email sends each alert as a mail to To as it happens, and with a Digest, a daily digest too.
+// Mail is multipart text and HTML. With StartTLS, which is the default, the connection must be
+// upgraded with STARTTLS before anything, credentials included, is sent.
+type Email struct {
+	Host          string
+	Port          int
+	Username      string
+	Password      string
+	From          string
+	To            []string
+	StartTLS      bool
+	TLSConfig     *tls.Config
+	SubjectPrefix string
+	Timeout       time.Duration
+	Digest        *Digest
+
+	now func() time.Time
+}
+
+func NewEmail(host string, port int, from string, to []string) *Email {
+	return &Email{
+		Host:          host,
+		Port:          port,
+		From:          from,
+		To:            to,
+		StartTLS:      true,
+		SubjectPrefix: "[PurpleAir]",
+		Timeout:       30 * time.Second,
+		now:           time.Now,
+	}
+}
+
+// options: host, port (default 587), username, password, from, to (addresses separated by ;),
+// starttls, tls_skip_verify, subject_prefix, timeout, and for a daily digest, digest as the
+// HH:MM to send it at, timezone (default local) and min_confidence for its QA
+func newEmailFromOptions(options map[string]string) (Notifier, error) {
+	host := options["host"]
+	from := options["from"]
+	var to []string
+	for _, addr := range strings.Split(options["to"], ";") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	if host == "" || from == "" || len(to) == 0 {
+		return nil, fmt.Errorf("email notifier requires host, from and to options")
+	}
+	for _, addr := range append([]string{from}, to...) {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("email notifier address %q: %s", addr, err)
+		}
+	}
+	port := 587
+	if v, ok := options["port"]; ok {
+		var err error
+		port, err = strconv.Atoi(v)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("email notifier option port must be a port number")
+		}
+	}
+	e := NewEmail(host, port, from, to)
+	e.Username = options["username"]
+	e.Password = options["password"]
+	if v, ok := options["subject_prefix"]; ok {
+		e.SubjectPrefix = v
+	}
+	if v, ok := options["starttls"]; ok {
+		starttls, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("email notifier option starttls: %s", err)
+		}
+		e.StartTLS = starttls
+	}
+	if v, ok := options["tls_skip_verify"]; ok {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("email notifier option tls_skip_verify: %s", err)
+		}
+		e.TLSConfig = &tls.Config{ServerName: host, InsecureSkipVerify: skip}
+	}
+	if v, ok := options["timeout"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("email notifier option timeout: %s", err)
+		}
+		e.Timeout = d
+	}
+	if at, ok := options["digest"]; ok {
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			return nil, fmt.Errorf("email notifier option digest must be a time like 07:30")
+		}
+		location := time.Local
+		if tz, ok := options["timezone"]; ok {
+			location, err = time.LoadLocation(tz)
+			if err != nil {
+				return nil, fmt.Errorf("email notifier option timezone: %s", err)
+			}
+		}
+		e.Digest = NewDigest(time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute, location)
+		if v, ok := options["min_confidence"]; ok {
+			e.Digest.MinConfidence, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("email notifier option min_confidence: %s", err)
+			}
+		}
+		e.Digest.OnReport = e.SendDigest
+	}
+	return e, nil
+}
+
+var alertHTML = htmltemplate.Must(htmltemplate.New("alert").Funcs(htmltemplate.FuncMap{"mapURL": MapURL}).Parse(
+	`<p>{{.Summary}}</p>
+<ul>
+{{range .Event.Sensors}}<li><a href="{{mapURL .SensorIndex}}">{{if .Name}}{{.Name}}{{else}}sensor {{.SensorIndex}}{{end}}</a>: {{printf "%.1f" .Value}}</li>
+{{end}}</ul>
+<p>{{.Event.Time.Format "2006-01-02 15:04 MST"}}</p>
+`))
+
+func (e *Email) Notify(ev alert.Event) error {
+	subject := fmt.Sprintf("%s %s %s: %s %s is %s", e.SubjectPrefix, ev.Rule, ev.Kind, ev.Aggregate, ev.Metric, formatValue(ev.Value))
+	if ev.Category != "" {
+		subject += " (" + ev.Category + ")"
+	}
+	text := Message(ev, plainLink) + "\n\n" + ev.Time.Format("2006-01-02 15:04 MST") + "\n"
+	var html bytes.Buffer
+	if err := alertHTML.Execute(&html, struct {
+		Summary string
+		Event   alert.Event
+	}{ev.String(), ev}); err != nil {
+		return err
+	}
+	return e.send(strings.TrimSpace(subject), text, html.String())
+}
+
+var digestText = template.Must(template.New("digest").Funcs(template.FuncMap{"mapURL": MapURL, "hours": formatHours}).Parse(
+	`Air quality from {{.Report.Start.Format "2006-01-02 15:04"}} to {{.Report.End.Format "2006-01-02 15:04 MST"}}
+{{range .Report.Sensors}}
+{{if .Name}}{{.Name}} ({{.SensorIndex}}){{else}}Sensor {{.SensorIndex}}{{end}} {{mapURL .SensorIndex}}
+  AQI min {{.MinAqi}}, mean {{printf "%.0f" .MeanAqi}}, max {{.MaxAqi}}
+{{range $i, $h := .Hours}}{{if $h}}  {{index $.Categories $i}}: {{hours $h}}
+{{end}}{{end}}{{if .HasQAIssues}}  QA issues: {{.Downgraded}} samples with a downgraded channel, {{.LowConfidence}} with low confidence
+{{end}}{{else}}
+No samples.
+{{end}}`))
+
+var digestHTML = htmltemplate.Must(htmltemplate.New("digest").Funcs(htmltemplate.FuncMap{"mapURL": MapURL, "hours": formatHours}).Parse(
+	`<p>Air quality from {{.Report.Start.Format "2006-01-02 15:04"}} to {{.Report.End.Format "2006-01-02 15:04 MST"}}</p>
+{{if .Report.Sensors}}<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Sensor</th><th>Min AQI</th><th>Mean AQI</th><th>Max AQI</th>{{range .Categories}}<th>{{.}}</th>{{end}}<th>QA issues</th></tr>
+{{range .Report.Sensors}}<tr><td><a href="{{mapURL .SensorIndex}}">{{if .Name}}{{.Name}}{{else}}{{.SensorIndex}}{{end}}</a></td><td>{{.MinAqi}}</td><td>{{printf "%.0f" .MeanAqi}}</td><td>{{.MaxAqi}}</td>{{range .Hours}}<td>{{if .}}{{hours .}}{{end}}</td>{{end}}<td>{{if .HasQAIssues}}{{.Downgraded}} downgraded, {{.LowConfidence}} low confidence{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p>No samples.</p>
+{{end}}`))
+
+func formatHours(h float64) string {
+	return strconv.FormatFloat(h, 'f', 1, 64) + "h"
+}
+
+// SendDigest mails a digest report
+func (e *Email) SendDigest(r DigestReport) error {
+	categories := make([]string, 6)
+	for i := range categories {
+		categories[i] = purpleair.AqiCategory(i).String()
+	}
+	r.Start = r.Start.In(e.location())
+	r.End = r.End.In(e.location())
+	data := struct {
+		Report     DigestReport
+		Categories []string
+	}{r, categories}
+	var text, html bytes.Buffer
+	if err := digestText.Execute(&text, data); err != nil {
+		return err
+	}
+	if err := digestHTML.Execute(&html, data); err != nil {
+		return err
+	}
+	subject := strings.TrimSpace(fmt.Sprintf("%s Daily digest for %s", e.SubjectPrefix, r.Start.Format("Mon 2 Jan 2006")))
+	return e.send(subject, text.String(), html.String())
+}
+
+func (e *Email) location() *time.Location {
+	if e.Digest != nil {
+		return e.Digest.Location
+	}
+	return time.Local
+}
+
+func writeQuotedPrintable(w *multipart.Writer, contentType string, body string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// message builds a multipart/alternative mail
+func (e *Email) message(subject string, text string, html string) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := writeQuotedPrintable(w, "text/plain", text); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(w, "text/html", html); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := "localhost"
+	if from, err := mail.ParseAddress(e.From); err == nil {
+		domain = from.Address[strings.LastIndex(from.Address, "@")+1:]
+	}
+	var msg bytes.Buffer
+	for _, h := range [][2]string{
+		{"From", e.From},
+		{"To", strings.Join(e.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", e.now().Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%x@%s>", id, domain)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + w.Boundary()},
+	} {
+		msg.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func (e *Email) send(subject string, text string, html string) error {
+	msg, err := e.message(subject, text, html)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	conn, err := net.DialTimeout("tcp", addr, e.Timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(e.Timeout))
+	c, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if e.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s doesn't support STARTTLS", addr)
+		}
+		config := e.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: e.Host}
+		}
+		if err := c.StartTLS(config); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return err
+		}
+	}
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return err
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, addr := range e.To {
+		to, err := mail.ParseAddress(addr)
+		if err != nil {
+			return err
+		}
+		if err := c.Rcpt(to.Address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,249 @@
+package notify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+	"github.com/stretchr/testify/assert"
+)
+
+type smtpMessage struct {
+	tls  bool
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+// setupSMTPServer is a stand-in SMTP server that offers STARTTLS with cert, if given, and only
+// offers AUTH over TLS when it does
+func setupSMTPServer(t *testing.T, cert *tls.Certificate) (string, func() []smtpMessage) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	var mu sync.Mutex
+	var messages []smtpMessage
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var msg smtpMessage
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(cmd) {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250-localhost")
+				if cert != nil && !msg.tls {
+					tp.PrintfLine("250-STARTTLS")
+				}
+				if cert == nil || msg.tls {
+					tp.PrintfLine("250-AUTH PLAIN")
+				}
+				tp.PrintfLine("250 8BITMIME")
+			case "STARTTLS":
+				tp.PrintfLine("220 ready")
+				tconn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}})
+				if tconn.Handshake() != nil {
+					return
+				}
+				conn = tconn
+				tp = textproto.NewConn(tconn)
+				msg.tls = true
+			case "AUTH":
+				b, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+				msg.auth = string(b)
+				tp.PrintfLine("235 ok")
+			case "MAIL":
+				msg.from, _, _ = strings.Cut(strings.TrimPrefix(arg, "FROM:<"), ">")
+				tp.PrintfLine("250 ok")
+			case "RCPT":
+				to, _, _ := strings.Cut(strings.TrimPrefix(arg, "TO:<"), ">")
+				msg.to = append(msg.to, to)
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				msg.data, _ = tp.ReadDotBytes()
+				mu.Lock()
+				messages = append(messages, msg)
+				mu.Unlock()
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("500 unknown command")
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return l.Addr().String(), func() []smtpMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]smtpMessage(nil), messages...)
+	}
+}
+
+// testCert borrows httptest's certificate, which is valid for 127.0.0.1
+func testCert() (*tls.Certificate, *x509.CertPool) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	return &server.TLS.Certificates[0], pool
+}
+
+// parseMail returns a mail's subject and its text and html parts
+func parseMail(t *testing.T, data []byte) (string, string, string) {
+	m, err := mail.ReadMessage(strings.NewReader(string(data)))
+	assert.Nil(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	assert.Nil(t, err)
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	parts := make(map[string]string)
+	r := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		b, err := io.ReadAll(p) // quoted-printable is decoded by the reader
+		assert.Nil(t, err)
+		parts[ct] = string(b)
+	}
+	return subject, parts["text/plain"], parts["text/html"]
+}
+
+func newTestEmail(t *testing.T, addr string, options map[string]string) *Email {
+	host, port, _ := net.SplitHostPort(addr)
+	opts := map[string]string{"host": host, "port": port, "from": "PurpleAir <air@example.com>", "to": "a@example.com; b@example.com"}
+	for k, v := range options {
+		opts[k] = v
+	}
+	n, err := New("email", opts)
+	assert.Nil(t, err)
+	return n.(*Email)
+}
+
+func TestEmailStartTLS(t *testing.T) {
+	cert, pool := testCert()
+	addr, messages := setupSMTPServer(t, cert)
+	e := newTestEmail(t, addr, map[string]string{"username": "air", "password": "secret"})
+	e.TLSConfig = &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}
+	assert.Nil(t, e.Notify(testEvent()))
+
+	got := messages()
+	assert.Equal(t, 1, len(got))
+	assert.True(t, got[0].tls)
+	assert.Equal(t, "\x00air\x00secret", got[0].auth)
+	assert.Equal(t, "air@example.com", got[0].from)
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, got[0].to)
+	subject, text, html := parseMail(t, got[0].data)
+	assert.Equal(t, "[PurpleAir] neighbourhood firing: median aqi is 163 (Unhealthy)", subject)
+	assert.Contains(t, text, "- Backyard https://map.purpleair.com/?select=15111: 170")
+	assert.Contains(t, html, `<a href="https://map.purpleair.com/?select=15111">Backyard</a>: 170.0`)
+	assert.Contains(t, html, `sensor 20755</a>`)
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+	addr, messages := setupSMTPServer(t, nil)
+	e := newTestEmail(t, addr, map[string]string{"username": "air", "password": "secret"})
+	err := e.Notify(testEvent())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "STARTTLS")
+	assert.Empty(t, messages())
+
+	// a local relay without TLS
+	e = newTestEmail(t, addr, map[string]string{"starttls": "false"})
+	assert.Nil(t, e.Notify(testEvent()))
+	assert.Equal(t, 1, len(messages()))
+	assert.False(t, messages()[0].tls)
+}
+
+func TestEmailDigest(t *testing.T) {
+	addr, messages := setupSMTPServer(t, nil)
+	e := newTestEmail(t, addr, map[string]string{"starttls": "false", "digest": "07:00", "timezone": "UTC"})
+	assert.NotNil(t, e.Digest)
+	now := time.Date(2022, 9, 26, 6, 0, 0, 0, time.UTC)
+	e.Digest.now = func() time.Time { return now }
+	assert.Nil(t, e.Digest.Open())
+
+	for i := 0; i <= 30; i++ {
+		now = now.Add(time.Minute)
+		a := purpleair.NewSensorSample(15111, now)
+		a.Tags["name"] = "Backyard & Porch"
+		a.Fields["pm2.5_alt"] = 5
+		if i > 10 {
+			a.Fields["pm2.5_alt"] = 60
+		}
+		b := purpleair.NewSensorSample(20755, now)
+		b.Fields["pm2.5_alt"] = 20
+		b.Fields["channel_flags"] = 1
+		assert.Nil(t, e.Digest.Write([]purpleair.SensorSample{*a, *b}))
+	}
+	assert.Empty(t, messages())
+	now = now.Add(29 * time.Minute)
+	assert.Nil(t, e.Digest.Write(nil))
+	assert.Nil(t, e.Digest.Close())
+
+	got := messages()
+	assert.Equal(t, 1, len(got))
+	subject, text, html := parseMail(t, got[0].data)
+	assert.Equal(t, "[PurpleAir] Daily digest for Mon 26 Sep 2022", subject)
+	assert.Contains(t, text, "Backyard & Porch (15111) https://map.purpleair.com/?select=15111\n"+
+		"  AQI min 21, mean 106, max "+strconv.Itoa(purpleair.Pm25ToAqi(60))+"\n"+
+		"  Good: 0.2h\n"+
+		"  Unhealthy: 0.3h\n")
+	assert.Contains(t, text, "Sensor 20755 https://map.purpleair.com/?select=20755\n"+
+		"  AQI min 68, mean 68, max 68\n"+
+		"  Moderate: 0.5h\n"+
+		"  QA issues: 31 samples with a downgraded channel, 0 with low confidence\n")
+	assert.Contains(t, html, "Backyard &amp; Porch</a>")
+	assert.Contains(t, html, "31 downgraded, 0 low confidence")
+}
+
+func TestEmailOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"host": "mail.example.com", "from": "air@example.com"},
+		{"host": "mail.example.com", "from": "not an address", "to": "a@example.com"},
+		{"host": "mail.example.com", "from": "air@example.com", "to": "a@example.com", "port": "smtp"},
+		{"host": "mail.example.com", "from": "air@example.com", "to": "a@example.com", "digest": "7am"},
+		{"host": "mail.example.com", "from": "air@example.com", "to": "a@example.com", "digest": "07:00", "timezone": "Nowhere/Special"},
+	} {
+		_, err := New("email", options)
+		assert.NotNil(t, err, options)
+	}
+	n, err := New("email", map[string]string{"host": "mail.example.com", "from": "air@example.com", "to": "a@example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, 587, n.(*Email).Port)
+	assert.Nil(t, n.(*Email).Digest)
+}
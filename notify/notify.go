@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/poynting/purpleair-api-go/alert"
+)
+
+// Notifier delivers alert events somewhere people will see them
+type Notifier interface {
+	Notify(ev alert.Event) error
+}
+
+// Factory makes a notifier from its options, as given in a notifier spec
+type Factory func(options map[string]string) (Notifier, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a kind of notifier available to New
+func Register(kind string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[kind] = f
+}
+
+// Kinds lists the registered kinds of notifier
+func Kinds() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	kinds := make([]string, 0, len(factories))
+	for k := range factories {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func New(kind string, options map[string]string) (Notifier, error) {
+	factoriesMu.RLock()
+	f, ok := factories[kind]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown notifier %s, expected one of %s", kind, strings.Join(Kinds(), ", "))
+	}
+	return f(options)
+}
+
+// MapURL links to a sensor on the PurpleAir map
+func MapURL(sensor_index int) string {
+	return fmt.Sprintf("https://map.purpleair.com/?select=%d", sensor_index)
+}
+
+// maxListedSensors is how many sensors a message lists before "and N more"
+const maxListedSensors = 10
+
+// Message is the text of an event: its summary, then a line per sensor, worst first, each with
+// a link made by link from the sensor's name and map url
+func Message(ev alert.Event, link func(text string, url string) string) string {
+	var b strings.Builder
+	b.WriteString(ev.String())
+	for i, s := range ev.Sensors {
+		if i == maxListedSensors {
+			fmt.Fprintf(&b, "\nand %d more", len(ev.Sensors)-i)
+			break
+		}
+		name := s.Name
+		if name == "" {
+			name = fmt.Sprintf("sensor %d", s.SensorIndex)
+		}
+		fmt.Fprintf(&b, "\n- %s: %s", link(name, MapURL(s.SensorIndex)), formatValue(s.Value))
+	}
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.1f", v), "0"), ".")
+}
+
+func plainLink(text string, url string) string {
+	return text + " " + url
+}
+
+type namedNotifier struct {
+	name     string
+	notifier Notifier
+	events   chan alert.Event
+}
+
+// Dispatcher delivers events to each of its notifiers from a goroutine of its own, so a slow or
+// retrying notifier holds up neither polling nor the other notifiers. A notifier's events are
+// queued up to QueueSize, and events past that are dropped. A failed delivery or a dropped
+// event goes to OnError.
+type Dispatcher struct {
+	OnError   func(name string, err error)
+	QueueSize int
+
+	notifiers []*namedNotifier
+	wg        sync.WaitGroup
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{QueueSize: 64}
+}
+
+// Add a notifier before Start
+func (d *Dispatcher) Add(name string, n Notifier) {
+	d.notifiers = append(d.notifiers, &namedNotifier{name: name, notifier: n})
+}
+
+func (d *Dispatcher) Len() int {
+	return len(d.notifiers)
+}
+
+func (d *Dispatcher) Start() {
+	for _, nn := range d.notifiers {
+		nn.events = make(chan alert.Event, d.QueueSize)
+		d.wg.Add(1)
+		go func(nn *namedNotifier) {
+			defer d.wg.Done()
+			for ev := range nn.events {
+				if err := nn.notifier.Notify(ev); err != nil && d.OnError != nil {
+					d.OnError(nn.name, err)
+				}
+			}
+		}(nn)
+	}
+}
+
+// Notify queues an event for delivery to each notifier without waiting, dropping it for
+// notifiers whose queue is full
+func (d *Dispatcher) Notify(ev alert.Event) {
+	for _, nn := range d.notifiers {
+		select {
+		case nn.events <- ev:
+		default:
+			if d.OnError != nil {
+				d.OnError(nn.name, fmt.Errorf("queue full, dropped %s", ev))
+			}
+		}
+	}
+}
+
+// Close delivers the queued events and stops
+func (d *Dispatcher) Close() {
+	for _, nn := range d.notifiers {
+		close(nn.events)
+	}
+	d.wg.Wait()
+}
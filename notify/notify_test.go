@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/alert"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageListsSensors(t *testing.T) {
+	ev := testEvent()
+	ev.Sensors = nil
+	for i := 0; i < 12; i++ {
+		ev.Sensors = append(ev.Sensors, alert.SensorValue{SensorIndex: 100 + i, Value: float64(200 - i)})
+	}
+	lines := strings.Split(Message(ev, plainLink), "\n")
+	assert.Equal(t, 12, len(lines))
+	assert.Equal(t, "- sensor 100 https://map.purpleair.com/?select=100: 200", lines[1])
+	assert.Equal(t, "and 2 more", lines[11])
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	events []alert.Event
+	err    error
+}
+
+func (r *recorder) Notify(ev alert.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+	return r.err
+}
+
+func TestDispatcher(t *testing.T) {
+	good := &recorder{}
+	bad := &recorder{err: fmt.Errorf("down")}
+	d := NewDispatcher()
+	d.Add("good", good)
+	d.Add("bad", bad)
+	assert.Equal(t, 2, d.Len())
+	var failed []string
+	d.OnError = func(name string, err error) { failed = append(failed, name+": "+err.Error()) }
+	d.Start()
+	d.Notify(testEvent())
+	ev := testEvent()
+	ev.Kind = alert.Resolved
+	d.Notify(ev)
+	d.Close()
+	assert.Equal(t, 2, len(good.events))
+	assert.Equal(t, alert.Resolved, good.events[1].Kind)
+	assert.Equal(t, 2, len(bad.events))
+	assert.Equal(t, []string{"bad: down", "bad: down"}, failed)
+}
+
+// blocker doesn't return until it's released, as a webhook that keeps retrying
+type blocker struct {
+	release chan struct{}
+}
+
+func (b *blocker) Notify(ev alert.Event) error {
+	<-b.release
+	return nil
+}
+
+func TestDispatcherSlowNotifier(t *testing.T) {
+	slow := &blocker{release: make(chan struct{})}
+	good := &recorder{}
+	d := NewDispatcher()
+	d.QueueSize = 1
+	d.Add("slow", slow)
+	d.Add("good", good)
+	var mu sync.Mutex
+	var failed []string
+	d.OnError = func(name string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, name)
+	}
+	d.Start()
+	// the slow notifier takes one event, queues one and drops the rest, without blocking
+	for i := 0; i < 4; i++ {
+		d.Notify(testEvent())
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	assert.Equal(t, []string{"slow", "slow"}, failed)
+	mu.Unlock()
+	close(slow.release)
+	d.Close()
+	assert.Equal(t, 4, len(good.events))
+}
+
+func TestUnknownNotifier(t *testing.T) {
+	_, err := New("pager", nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, Kinds(), "webhook")
+}
@@ -0,0 +1,309 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/alert"
+)
+
+func init() {
+	Register("webhook", newWebhookFromOptions)
+}
+
+// WebhookError is a delivery the receiver answered with a 4xx or 5xx
+type WebhookError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e WebhookError) Error() string {
+	return fmt.Sprintf("webhook returned %d: %s", e.StatusCode, e.Message)
+}
+
+// TemplateData is what a webhook template is executed with: the event's fields, and Message,
+// the text the built-in formats send. Templates may also call mapURL with a sensor_index and
+// json with any value.
+type TemplateData struct {
+	alert.Event
+	Message string
+}
+
+var templateFuncs = template.FuncMap{
+	"mapURL": MapURL,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// ParseTemplate reads a webhook payload template
+func ParseTemplate(path string) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(path).Funcs(templateFuncs).Parse(string(b))
+}
+
+// Webhook POSTs events to a url, formatted for Slack, Discord or ntfy, as generic JSON, or
+// from a user Template. A delivery that fails with a 5xx, a 429 or a network error is retried
+// with exponential backoff; one that still fails is appended to the DeadLetter file, if set,
+// as a JSON line holding the event, the payload and the error.
+type Webhook struct {
+	Format      string // slack, discord, ntfy or json
+	Template    *template.Template
+	ContentType string // of templated payloads, by default application/json, or text/plain for ntfy
+	Headers     map[string]string
+	MaxRetries  int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	DeadLetter  string
+	HTTPClient  *http.Client
+
+	url   string
+	sleep func(time.Duration)
+	mu    sync.Mutex // for the dead letter file
+}
+
+func NewWebhook(url string, format string) (*Webhook, error) {
+	switch format {
+	case "slack", "discord", "ntfy", "json":
+	default:
+		return nil, fmt.Errorf("webhook format must be slack, discord, ntfy or json, not %q", format)
+	}
+	return &Webhook{
+		Format:     format,
+		Headers:    make(map[string]string),
+		MaxRetries: 5,
+		MinBackoff: 1 * time.Second,
+		MaxBackoff: 1 * time.Minute,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		url:        url,
+		sleep:      time.Sleep,
+	}, nil
+}
+
+// options: url, format, template (a file), content_type, header.<name>=<value>, max_retries,
+// min_backoff, max_backoff, timeout and dead_letter (a file)
+func newWebhookFromOptions(options map[string]string) (Notifier, error) {
+	url := options["url"]
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("webhook notifier requires an http(s) url option")
+	}
+	format := options["format"]
+	if format == "" {
+		format = "json"
+	}
+	w, err := NewWebhook(url, format)
+	if err != nil {
+		return nil, err
+	}
+	if path := options["template"]; path != "" {
+		w.Template, err = ParseTemplate(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.ContentType = options["content_type"]
+	w.DeadLetter = options["dead_letter"]
+	for k, v := range options {
+		if strings.HasPrefix(k, "header.") {
+			w.Headers[strings.TrimPrefix(k, "header.")] = v
+		}
+	}
+	if v, ok := options["max_retries"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("webhook notifier option max_retries must be a non-negative integer")
+		}
+		w.MaxRetries = n
+	}
+	for option, d := range map[string]*time.Duration{
+		"min_backoff": &w.MinBackoff,
+		"max_backoff": &w.MaxBackoff,
+		"timeout":     &w.HTTPClient.Timeout,
+	} {
+		if v, ok := options[option]; ok {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("webhook notifier option %s: %s", option, err)
+			}
+			*d = parsed
+		}
+	}
+	return w, nil
+}
+
+func slackLink(text string, url string) string {
+	return "<" + url + "|" + text + ">"
+}
+
+func markdownLink(text string, url string) string {
+	return "[" + text + "](" + url + ")"
+}
+
+// jsonSensor is a sensor in the generic json payload
+type jsonSensor struct {
+	alert.SensorValue
+	MapURL string `json:"map_url"`
+}
+
+// payload is the body and headers of the request for an event
+func (w *Webhook) payload(ev alert.Event) ([]byte, map[string]string, error) {
+	headers := map[string]string{"Content-Type": "application/json"}
+	if w.Format == "ntfy" {
+		headers["Content-Type"] = "text/plain; charset=utf-8"
+		headers["Title"] = ev.Rule + " " + string(ev.Kind)
+		headers["Priority"] = "default"
+		headers["Tags"] = "white_check_mark"
+		if ev.Kind == alert.Firing {
+			headers["Priority"] = "high"
+			headers["Tags"] = "warning"
+		}
+		if len(ev.Sensors) > 0 {
+			headers["Click"] = MapURL(ev.Sensors[0].SensorIndex)
+		}
+	}
+	if w.Template != nil {
+		if w.ContentType != "" {
+			headers["Content-Type"] = w.ContentType
+		}
+		var b bytes.Buffer
+		if err := w.Template.Execute(&b, TemplateData{Event: ev, Message: Message(ev, plainLink)}); err != nil {
+			return nil, nil, err
+		}
+		return b.Bytes(), headers, nil
+	}
+	var body interface{}
+	switch w.Format {
+	case "slack":
+		body = map[string]string{"text": Message(ev, slackLink)}
+	case "discord":
+		msg := Message(ev, markdownLink)
+		// discord rejects content over 2000 characters
+		if r := []rune(msg); len(r) > 2000 {
+			msg = string(r[:1997]) + "..."
+		}
+		body = map[string]string{"content": msg, "username": "PurpleAir"}
+	case "ntfy":
+		return []byte(Message(ev, plainLink)), headers, nil
+	default:
+		sensors := make([]jsonSensor, len(ev.Sensors))
+		for i, s := range ev.Sensors {
+			sensors[i] = jsonSensor{SensorValue: s, MapURL: MapURL(s.SensorIndex)}
+		}
+		body = struct {
+			alert.Event
+			Sensors []jsonSensor `json:"sensors"`
+			Message string       `json:"message"`
+		}{ev, sensors, Message(ev, plainLink)}
+	}
+	b, err := json.Marshal(body)
+	return b, headers, err
+}
+
+func (w *Webhook) send(body []byte, headers map[string]string) (retry bool, wait time.Duration, err error) {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("User-Agent", "purpleair-api-go")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.HTTPClient.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, 0, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	err = WebhookError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil {
+			wait = time.Duration(secs) * time.Second
+		}
+		return true, wait, err
+	}
+	return resp.StatusCode >= 500, 0, err
+}
+
+// deadLetter is a line of the dead letter file
+type deadLetter struct {
+	Time     time.Time   `json:"time"`
+	Format   string      `json:"format"`
+	Event    alert.Event `json:"event"`
+	Payload  string      `json:"payload"`
+	Error    string      `json:"error"`
+	Attempts int         `json:"attempts"`
+}
+
+func (w *Webhook) deadLetter(dl deadLetter) error {
+	if w.DeadLetter == "" {
+		return nil
+	}
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, err := os.OpenFile(w.DeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (w *Webhook) Notify(ev alert.Event) error {
+	body, headers, err := w.payload(ev)
+	if err != nil {
+		return fmt.Errorf("webhook payload: %w", err)
+	}
+	backoff := w.MinBackoff
+	for attempt := 1; ; attempt++ {
+		retry, wait, err := w.send(body, headers)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt > w.MaxRetries {
+			err = fmt.Errorf("webhook failed after %d attempts: %w", attempt, err)
+			if dlerr := w.deadLetter(deadLetter{Time: time.Now().UTC(), Format: w.Format, Event: ev, Payload: string(body), Error: err.Error(), Attempts: attempt}); dlerr != nil {
+				return fmt.Errorf("%s, and writing the dead letter log failed: %s", err, dlerr)
+			}
+			return err
+		}
+		// a receiver's Retry-After is kept to MaxBackoff, so one can't hold up notifications for long
+		if wait > w.MaxBackoff {
+			wait = w.MaxBackoff
+		}
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+			if backoff > w.MaxBackoff {
+				backoff = w.MaxBackoff
+			}
+		}
+		w.sleep(wait)
+	}
+}
@@ -0,0 +1,219 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/alert"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEvent() alert.Event {
+	return alert.Event{
+		Rule:      "neighbourhood",
+		Kind:      alert.Firing,
+		Time:      time.Unix(1664200000, 0).UTC(),
+		Metric:    "aqi",
+		Aggregate: "median",
+		Op:        ">",
+		Threshold: 150,
+		Value:     163,
+		Category:  "Unhealthy",
+		Sensors: []alert.SensorValue{
+			{SensorIndex: 15111, Name: "Backyard", Value: 170},
+			{SensorIndex: 20755, Value: 163},
+		},
+	}
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// setupReceiver records each request, answering the first failures with status
+func setupReceiver(t *testing.T, failures int, status int) (*httptest.Server, *[]received) {
+	var mu sync.Mutex
+	var got []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, received{header: r.Header, body: body})
+		if len(got) <= failures {
+			w.WriteHeader(status)
+			w.Write([]byte("try later"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &got
+}
+
+func TestWebhookSlack(t *testing.T) {
+	server, got := setupReceiver(t, 0, 0)
+	n, err := New("webhook", map[string]string{"url": server.URL, "format": "slack"})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(testEvent()))
+	assert.Equal(t, 1, len(*got))
+	assert.Equal(t, "application/json", (*got)[0].header.Get("Content-Type"))
+	var body map[string]string
+	assert.Nil(t, json.Unmarshal((*got)[0].body, &body))
+	assert.Equal(t, "neighbourhood firing: median aqi is 163 (Unhealthy), threshold > 150, across 2 sensors\n"+
+		"- <https://map.purpleair.com/?select=15111|Backyard>: 170\n"+
+		"- <https://map.purpleair.com/?select=20755|sensor 20755>: 163", body["text"])
+}
+
+func TestWebhookDiscord(t *testing.T) {
+	server, got := setupReceiver(t, 0, 0)
+	n, err := New("webhook", map[string]string{"url": server.URL, "format": "discord"})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(testEvent()))
+	var body map[string]string
+	assert.Nil(t, json.Unmarshal((*got)[0].body, &body))
+	assert.Contains(t, body["content"], "- [Backyard](https://map.purpleair.com/?select=15111): 170")
+	assert.Equal(t, "PurpleAir", body["username"])
+}
+
+func TestWebhookNtfy(t *testing.T) {
+	server, got := setupReceiver(t, 0, 0)
+	n, err := New("webhook", map[string]string{"url": server.URL + "/purpleair", "format": "ntfy", "header.Authorization": "Bearer tk_secret"})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(testEvent()))
+	h := (*got)[0].header
+	assert.Equal(t, "neighbourhood firing", h.Get("Title"))
+	assert.Equal(t, "high", h.Get("Priority"))
+	assert.Equal(t, "warning", h.Get("Tags"))
+	assert.Equal(t, "https://map.purpleair.com/?select=15111", h.Get("Click"))
+	assert.Equal(t, "Bearer tk_secret", h.Get("Authorization"))
+	assert.True(t, strings.HasPrefix(string((*got)[0].body), "neighbourhood firing: median aqi is 163"))
+	assert.Contains(t, string((*got)[0].body), "- Backyard https://map.purpleair.com/?select=15111: 170")
+}
+
+func TestWebhookJSON(t *testing.T) {
+	server, got := setupReceiver(t, 0, 0)
+	n, err := New("webhook", map[string]string{"url": server.URL})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(testEvent()))
+	var body struct {
+		Rule    string
+		Kind    string
+		Value   float64
+		Message string
+		Sensors []struct {
+			SensorIndex int    `json:"sensor_index"`
+			MapURL      string `json:"map_url"`
+		}
+	}
+	assert.Nil(t, json.Unmarshal((*got)[0].body, &body))
+	assert.Equal(t, "neighbourhood", body.Rule)
+	assert.Equal(t, "firing", body.Kind)
+	assert.Equal(t, 163.0, body.Value)
+	assert.Contains(t, body.Message, "Backyard")
+	assert.Equal(t, 2, len(body.Sensors))
+	assert.Equal(t, "https://map.purpleair.com/?select=20755", body.Sensors[1].MapURL)
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	server, got := setupReceiver(t, 0, 0)
+	path := filepath.Join(t.TempDir(), "payload.tmpl")
+	os.WriteFile(path, []byte(`{"title": {{json .Rule}}, "aqi": {{.Value}}, "links": [{{range $i, $s := .Sensors}}{{if $i}}, {{end}}{{json (mapURL $s.SensorIndex)}}{{end}}]}`), 0644)
+	n, err := New("webhook", map[string]string{"url": server.URL, "template": path})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(testEvent()))
+	assert.JSONEq(t, `{"title": "neighbourhood", "aqi": 163, "links": ["https://map.purpleair.com/?select=15111", "https://map.purpleair.com/?select=20755"]}`, string((*got)[0].body))
+
+	os.WriteFile(path, []byte(`{{.Message}}`), 0644)
+	n, err = New("webhook", map[string]string{"url": server.URL, "template": path, "content_type": "text/plain"})
+	assert.Nil(t, err)
+	assert.Nil(t, n.Notify(testEvent()))
+	assert.Equal(t, "text/plain", (*got)[1].header.Get("Content-Type"))
+	assert.Contains(t, string((*got)[1].body), "sensor 20755 https://map.purpleair.com/?select=20755: 163")
+}
+
+func TestWebhookRetry(t *testing.T) {
+	server, got := setupReceiver(t, 3, http.StatusBadGateway)
+	w, err := NewWebhook(server.URL, "json")
+	assert.Nil(t, err)
+	var sleeps []time.Duration
+	w.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	w.MaxBackoff = 3 * time.Second
+	assert.Nil(t, w.Notify(testEvent()))
+	assert.Equal(t, 4, len(*got))
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, sleeps)
+}
+
+func TestWebhookRetryAfter(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+	w, err := NewWebhook(server.URL, "json")
+	assert.Nil(t, err)
+	var sleeps []time.Duration
+	w.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	assert.Nil(t, w.Notify(testEvent()))
+	assert.Equal(t, []time.Duration{w.MaxBackoff}, sleeps)
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	server, got := setupReceiver(t, 100, http.StatusServiceUnavailable)
+	dead := filepath.Join(t.TempDir(), "dead.jsonl")
+	w, err := NewWebhook(server.URL, "slack")
+	assert.Nil(t, err)
+	w.sleep = func(time.Duration) {}
+	w.MaxRetries = 2
+	w.DeadLetter = dead
+	err = w.Notify(testEvent())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "after 3 attempts")
+	assert.Equal(t, 3, len(*got))
+
+	// a 4xx isn't retried
+	bad, got := setupReceiver(t, 100, http.StatusNotFound)
+	w.url = bad.URL
+	ev := testEvent()
+	ev.Kind = alert.Resolved
+	err = w.Notify(ev)
+	assert.Equal(t, http.StatusNotFound, err.(interface{ Unwrap() error }).Unwrap().(WebhookError).StatusCode)
+	assert.Equal(t, 1, len(*got))
+
+	b, err := os.ReadFile(dead)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Equal(t, 2, len(lines))
+	var dl deadLetter
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &dl))
+	assert.Equal(t, "neighbourhood", dl.Event.Rule)
+	assert.Equal(t, 3, dl.Attempts)
+	assert.Contains(t, dl.Payload, "Backyard")
+	assert.Contains(t, dl.Error, "503")
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &dl))
+	assert.Equal(t, alert.Resolved, dl.Event.Kind)
+}
+
+func TestWebhookOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{},
+		{"url": "ftp://example.com"},
+		{"url": "http://example.com", "format": "teams"},
+		{"url": "http://example.com", "max_retries": "-1"},
+		{"url": "http://example.com", "min_backoff": "soon"},
+		{"url": "http://example.com", "template": "/nonexistent.tmpl"},
+	} {
+		_, err := New("webhook", options)
+		assert.NotNil(t, err, options)
+	}
+}
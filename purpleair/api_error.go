@@ -1,5 +1,11 @@
 package purpleair
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 type ApiError int64
 
 const (
@@ -29,3 +35,56 @@ func (e ApiError) String() string {
 	}
 	return "unknown"
 }
+
+func (e ApiError) Error() string {
+	return e.String()
+}
+
+// ParseApiError is the ApiError the api names name, or Undefined
+func ParseApiError(name string) ApiError {
+	for e := ApiKeyMissingError; e <= InvalidTokenError; e++ {
+		if e.String() == name {
+			return e
+		}
+	}
+	return Undefined
+}
+
+// ResponseError is an error response from the api. errors.Is matches its ApiError, e.g.
+// errors.Is(err, ApiKeyInvalidError).
+type ResponseError struct {
+	StatusCode  int
+	Type        ApiError
+	Name        string // the error as the api names it, which may not be an ApiError
+	Description string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, e.Description)
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Name, e.Description)
+}
+
+func (e *ResponseError) Is(target error) bool {
+	t, ok := target.(ApiError)
+	return ok && t != Undefined && t == e.Type
+}
+
+// parseResponseError reads an error response's body, which is JSON naming the error or,
+// from proxies in front of the api, any text
+func parseResponseError(status int, body []byte) *ResponseError {
+	e := &ResponseError{StatusCode: status}
+	var r struct {
+		Error       string `json:"error"`
+		Description string `json:"description"`
+	}
+	if json.Unmarshal(body, &r) == nil && r.Error != "" {
+		e.Name = r.Error
+		e.Type = ParseApiError(r.Error)
+		e.Description = r.Description
+	} else {
+		e.Description = strings.TrimSpace(string(body))
+	}
+	return e
+}
@@ -1,7 +1,11 @@
 package purpleair
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -21,6 +25,121 @@ type Sensors struct {
 	FirmwareDefaultVersion string       `json:"firmware_default_version"`
 	Fields                 []string     `json:"fields"`
 	Data                   [][]*float32 `json:"data"`
+
+	lastSeen map[int]uint // exact last_seen by sensor_index, float32 only resolves to ~2 minutes
+	points   int          // charged for the responses this came from, before any rows were filtered out
+}
+
+// Points is what the request, or requests, for s were charged: a point per field per sensor
+// returned. Rows dropped afterwards, outside an area, were still paid for.
+func (s Sensors) Points() int {
+	if s.points > 0 {
+		return s.points
+	}
+	return len(s.Fields) * len(s.Data)
+}
+
+// sensorValue is a data value, kept as float64 so last_seen stays exact. null is NaN, which
+// JSON can't hold.
+type sensorValue float64
+
+func (v *sensorValue) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*v = sensorValue(math.NaN())
+		return nil
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	*v = sensorValue(f)
+	return err
+}
+
+// sensorRows is the data array, decoded a row at a time into one scratch row and kept as one
+// slice of every row's values
+type sensorRows struct {
+	values []sensorValue
+	widths []int
+}
+
+func (r *sensorRows) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	// rows hold numbers, so each [ but the outer one starts a row
+	rows := bytes.Count(b, []byte("[")) - 1
+	r.widths = make([]int, 0, rows)
+	var row []sensorValue
+	for dec.More() {
+		row = row[:0]
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		if r.values == nil {
+			r.values = make([]sensorValue, 0, rows*len(row))
+		}
+		r.values = append(r.values, row...)
+		r.widths = append(r.widths, len(row))
+	}
+	return expectDelim(dec, ']')
+}
+
+// UnmarshalJSON decodes the data as float64, to keep last_seen exact in the same pass
+func (s *Sensors) UnmarshalJSON(b []byte) error {
+	type plain Sensors
+	var raw struct {
+		*plain
+		Data *sensorRows `json:"data"`
+	}
+	raw.plain = (*plain)(s)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	idx := s.FieldIndex("sensor_index")
+	iseen := s.FieldIndex("last_seen")
+	exact := idx >= 0 && iseen >= 0
+	s.Data = nil
+	if raw.Data != nil {
+		// every row points into the same two slices
+		values := make([]float32, len(raw.Data.values))
+		ptrs := make([]*float32, len(raw.Data.values))
+		s.Data = make([][]*float32, len(raw.Data.widths))
+		if exact {
+			s.lastSeen = make(map[int]uint, len(s.Data))
+		}
+		start := 0
+		for i, w := range raw.Data.widths {
+			d := raw.Data.values[start : start+w]
+			for j, v := range d {
+				if !math.IsNaN(float64(v)) {
+					values[start+j] = float32(v)
+					ptrs[start+j] = &values[start+j]
+				}
+			}
+			s.Data[i] = ptrs[start : start+w : start+w]
+			if exact && idx < w && iseen < w && s.Data[i][idx] != nil && s.Data[i][iseen] != nil {
+				s.lastSeen[int(math.Round(float64(d[idx])))] = uint(d[iseen])
+			}
+			start += w
+		}
+	}
+	s.points = len(s.Fields) * len(s.Data)
+	return nil
+}
+
+// LastSeen returns the exact last_seen time of a sensor if the field was requested
+func (s Sensors) LastSeen(sensor_index int) (uint, bool) {
+	ts, ok := s.lastSeen[sensor_index]
+	return ts, ok
+}
+
+// FieldIndex returns the column of the named field in Data, or -1 if the field wasn't returned
+func (s Sensors) FieldIndex(field string) int {
+	for i, f := range s.Fields {
+		if f == field {
+			return i
+		}
+	}
+	return -1
 }
 
 type Bounds struct {
@@ -76,9 +195,7 @@ func NewBounds(nwlng float32, nwlat float32, selng float32, selat float32) (*Bou
 	if (nwlat - selat) < 0 {
 		return nil, fmt.Errorf("selat must be less than nwlat")
 	}
-	if (selng - nwlng) < 0 {
-		return nil, fmt.Errorf("nwlng must be less than selng")
-	}
+	// nwlng > selng is a box that crosses the antimeridian, see Split
 	return &Bounds{
 		nwlng: nwlng,
 		nwlat: nwlat,
@@ -194,6 +311,11 @@ func allValidFields() []string {
 	return v
 }
 
+// ValidField reports whether field can be requested from /sensors
+func ValidField(field string) bool {
+	return contains(allValidFields(), field)
+}
+
 func validateParams(params map[string]string) error {
 	// validate params
 	for p, v := range params {
@@ -0,0 +1,161 @@
+package purpleair
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AqiCategory is the US EPA AQI category, from Good to Hazardous, so categories compare in order
+type AqiCategory int
+
+const (
+	AqiGood AqiCategory = iota
+	AqiModerate
+	AqiUnhealthyForSensitiveGroups
+	AqiUnhealthy
+	AqiVeryUnhealthy
+	AqiHazardous
+)
+
+var aqiCategoryNames = []string{
+	"Good",
+	"Moderate",
+	"Unhealthy for Sensitive Groups",
+	"Unhealthy",
+	"Very Unhealthy",
+	"Hazardous",
+}
+
+func (c AqiCategory) String() string {
+	if c < 0 || int(c) >= len(aqiCategoryNames) {
+		return fmt.Sprintf("AqiCategory(%d)", int(c))
+	}
+	return aqiCategoryNames[c]
+}
+
+// AqiToCategory is the category an AQI value falls in
+func AqiToCategory(aqi int) AqiCategory {
+	switch {
+	case aqi <= 50:
+		return AqiGood
+	case aqi <= 100:
+		return AqiModerate
+	case aqi <= 150:
+		return AqiUnhealthyForSensitiveGroups
+	case aqi <= 200:
+		return AqiUnhealthy
+	case aqi <= 300:
+		return AqiVeryUnhealthy
+	}
+	return AqiHazardous
+}
+
+// ParseAqiCategory accepts a category's name in any case, with spaces, dashes or underscores,
+// e.g. "unhealthy_for_sensitive_groups", or the abbreviation "usg"
+func ParseAqiCategory(s string) (AqiCategory, error) {
+	name := strings.ToLower(strings.NewReplacer("_", " ", "-", " ").Replace(strings.TrimSpace(s)))
+	if name == "usg" {
+		return AqiUnhealthyForSensitiveGroups, nil
+	}
+	for i, n := range aqiCategoryNames {
+		if strings.ToLower(n) == name {
+			return AqiCategory(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown AQI category %q", s)
+}
+
+// CorrectedPm25 applies the US EPA correction for PurpleAir sensors (Barkjohn et al. 2021, with
+// the 2022 extension for smoke) to pm2.5_cf_1 and humidity. Use the average of the A and B
+// channels' pm2.5_cf_1, which is what the pm2.5_cf_1 field is.
+func CorrectedPm25(pm25_cf1 float64, humidity float64) float64 {
+	x := pm25_cf1
+	var c float64
+	if x < 30 {
+		c = 0.524*x - 0.0862*humidity + 5.75
+	} else if x < 50 {
+		w := x/20 - 3./2
+		c = (0.786*w+0.524*(1-w))*x - 0.0862*humidity + 5.75
+	} else if x < 210 {
+		c = 0.786*x - 0.0862*humidity + 5.75
+	} else if x < 260 {
+		w := x/50 - 21./5
+		c = (0.69*w+0.786*(1-w))*x - 0.0862*humidity*(1-w) + 2.966*w + 5.75*(1-w) + 8.84e-4*x*x*w
+	} else {
+		c = 2.966 + 0.69*x + 8.84e-4*x*x
+	}
+	if c < 0 {
+		return 0
+	}
+	return c
+}
+
+// Correction adds a corrected field to samples that have the fields it's computed from
+type Correction struct {
+	Field  string   // the field it adds
+	Fields []string // the fields it needs
+	Apply  func(s SensorSample) float64
+}
+
+// Corrections are the corrections by name: epa adds pm2.5_corrected with CorrectedPm25
+var Corrections = map[string]Correction{
+	"epa": {
+		Field:  "pm2.5_corrected",
+		Fields: []string{"pm2.5_cf_1", "humidity"},
+		Apply: func(s SensorSample) float64 {
+			return CorrectedPm25(s.Fields["pm2.5_cf_1"], s.Fields["humidity"])
+		},
+	},
+}
+
+// Correct adds the corrected field to each sample that has the fields it needs
+func (c Correction) Correct(samples []SensorSample) {
+	for i := range samples {
+		ok := true
+		for _, f := range c.Fields {
+			_, has := samples[i].Fields[f]
+			ok = ok && has
+		}
+		if ok {
+			samples[i].Fields[c.Field] = c.Apply(samples[i])
+		}
+	}
+}
+
+// ChannelFlags is the channel_flags field: which of a sensor's A and B laser counters PurpleAir
+// has downgraded for disagreeing with the other
+type ChannelFlags int
+
+const (
+	ChannelsNormal ChannelFlags = iota
+	ChannelADowngraded
+	ChannelBDowngraded
+	ChannelsDowngraded
+)
+
+func (f ChannelFlags) String() string {
+	switch f {
+	case ChannelsNormal:
+		return "Normal"
+	case ChannelADowngraded:
+		return "A-Downgraded"
+	case ChannelBDowngraded:
+		return "B-Downgraded"
+	case ChannelsDowngraded:
+		return "A+B-Downgraded"
+	}
+	return fmt.Sprintf("ChannelFlags(%d)", int(f))
+}
+
+// SampleQuality reports whether a sample passes QA: with exclude_flagged its channels aren't
+// downgraded, and with min_confidence above 0 its confidence is at least min_confidence. Fields
+// that weren't requested don't fail QA.
+func SampleQuality(s SensorSample, exclude_flagged bool, min_confidence float64) bool {
+	if v, ok := s.Fields["channel_flags"]; exclude_flagged && ok && ChannelFlags(v) != ChannelsNormal {
+		return false
+	}
+	if v, ok := s.Fields["confidence"]; min_confidence > 0 && ok && v < min_confidence {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package purpleair
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAqiToCategory(t *testing.T) {
+	assert.Equal(t, AqiGood, AqiToCategory(0))
+	assert.Equal(t, AqiGood, AqiToCategory(50))
+	assert.Equal(t, AqiModerate, AqiToCategory(51))
+	assert.Equal(t, AqiUnhealthyForSensitiveGroups, AqiToCategory(150))
+	assert.Equal(t, AqiUnhealthy, AqiToCategory(151))
+	assert.Equal(t, AqiVeryUnhealthy, AqiToCategory(300))
+	assert.Equal(t, AqiHazardous, AqiToCategory(500))
+	assert.Equal(t, AqiUnhealthy, AqiToCategory(Pm25ToAqi(60)))
+	assert.Equal(t, "Unhealthy for Sensitive Groups", AqiUnhealthyForSensitiveGroups.String())
+}
+
+func TestParseAqiCategory(t *testing.T) {
+	for s, want := range map[string]AqiCategory{
+		"Good":                           AqiGood,
+		"very_unhealthy":                 AqiVeryUnhealthy,
+		"Unhealthy-for-Sensitive-Groups": AqiUnhealthyForSensitiveGroups,
+		"USG":                            AqiUnhealthyForSensitiveGroups,
+	} {
+		c, err := ParseAqiCategory(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, c, s)
+	}
+	_, err := ParseAqiCategory("smoky")
+	assert.NotNil(t, err)
+}
+
+func TestCorrectedPm25(t *testing.T) {
+	assert.InDelta(t, 6.68, CorrectedPm25(10, 50), 0.001)
+	assert.InDelta(t, 0.786*100-0.0862*40+5.75, CorrectedPm25(100, 40), 0.001)
+	assert.InDelta(t, 2.966+0.69*300+8.84e-4*300*300, CorrectedPm25(300, 20), 0.001)
+	assert.Equal(t, 0.0, CorrectedPm25(0, 100))
+	// the pieces meet at their boundaries
+	for _, x := range []float64{30, 50, 210, 260} {
+		assert.InDelta(t, CorrectedPm25(x-1e-9, 45), CorrectedPm25(x, 45), 1e-6, x)
+	}
+}
+
+func TestSampleQuality(t *testing.T) {
+	s := NewSensorSample(15111, time.Unix(1664170800, 0))
+	assert.True(t, SampleQuality(*s, true, 90))
+	s.Fields["channel_flags"] = 0
+	s.Fields["confidence"] = 100
+	assert.True(t, SampleQuality(*s, true, 90))
+	s.Fields["confidence"] = 50
+	assert.False(t, SampleQuality(*s, true, 90))
+	assert.True(t, SampleQuality(*s, true, 0))
+	s.Fields["channel_flags"] = float64(ChannelBDowngraded)
+	assert.False(t, SampleQuality(*s, true, 0))
+	// each check only applies when its own option is set
+	assert.True(t, SampleQuality(*s, false, 40))
+	assert.False(t, SampleQuality(*s, false, 90))
+	s.Fields["channel_flags"] = 0
+	s.Fields["confidence"] = 0
+	assert.True(t, SampleQuality(*s, true, 0))
+	assert.Equal(t, "B-Downgraded", ChannelBDowngraded.String())
+}
+
+func TestCorrections(t *testing.T) {
+	a := NewSensorSample(15111, time.Unix(1664170800, 0))
+	a.Fields["pm2.5_cf_1"] = 10
+	a.Fields["humidity"] = 50
+	b := NewSensorSample(20755, time.Unix(1664170800, 0))
+	b.Fields["pm2.5_cf_1"] = 10
+	samples := []SensorSample{*a, *b}
+	Corrections["epa"].Correct(samples)
+	assert.InDelta(t, 6.68, samples[0].Fields["pm2.5_corrected"], 0.001)
+	_, ok := samples[1].Fields["pm2.5_corrected"]
+	assert.False(t, ok) // no humidity
+}
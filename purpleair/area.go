@@ -0,0 +1,260 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Ring is a closed list of [lon, lat] positions in degrees, as in GeoJSON
+type Ring [][2]float64
+
+// Polygon is an outer ring followed by zero or more holes
+type Polygon []Ring
+
+// Area is a set of polygons, any of which may cross the antimeridian
+type Area struct {
+	Polygons []Polygon
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometries  []geoJSONObject `json:"geometries"`
+}
+
+type geoJSONObject struct {
+	geoJSONGeometry
+	Geometry *geoJSONGeometry `json:"geometry"`
+	Features []geoJSONObject  `json:"features"`
+}
+
+// unwrapRing makes longitudes continuous so a ring that crosses the antimeridian
+// runs past +/-180 instead of jumping across the map
+func unwrapRing(r Ring) Ring {
+	u := make(Ring, len(r))
+	offset := 0.0
+	for i, p := range r {
+		if i > 0 {
+			d := p[0] - r[i-1][0]
+			if d > 180 {
+				offset -= 360
+			} else if d < -180 {
+				offset += 360
+			}
+		}
+		u[i] = [2]float64{p[0] + offset, p[1]}
+	}
+	return u
+}
+
+func (a *Area) addPolygon(rings [][][2]float64) error {
+	if len(rings) == 0 {
+		return fmt.Errorf("polygon has no rings")
+	}
+	p := make(Polygon, len(rings))
+	for i, r := range rings {
+		if len(r) < 4 {
+			return fmt.Errorf("polygon ring must have at least 4 positions")
+		}
+		for _, pos := range r {
+			if !latValid(float32(pos[1])) || !lngValid(float32(pos[0])) {
+				return fmt.Errorf("position %v out of bounds", pos)
+			}
+		}
+		p[i] = unwrapRing(r)
+	}
+	// keep holes in the same longitude frame as the outer ring
+	for i := 1; i < len(p); i++ {
+		shift := 360 * math.Round((p[0][0][0]-p[i][0][0])/360)
+		for j := range p[i] {
+			p[i][j][0] += shift
+		}
+	}
+	a.Polygons = append(a.Polygons, p)
+	return nil
+}
+
+func (a *Area) addGeometry(g geoJSONGeometry) error {
+	switch g.Type {
+	case "Polygon":
+		var rings [][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &rings); err != nil {
+			return fmt.Errorf("can not unmarshal Polygon coordinates: %s", err)
+		}
+		return a.addPolygon(rings)
+	case "MultiPolygon":
+		var polygons [][][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return fmt.Errorf("can not unmarshal MultiPolygon coordinates: %s", err)
+		}
+		for _, rings := range polygons {
+			if err := a.addPolygon(rings); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "GeometryCollection":
+		for _, sub := range g.Geometries {
+			if err := a.addObject(sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported geometry type %s", g.Type)
+}
+
+func (a *Area) addObject(o geoJSONObject) error {
+	switch o.Type {
+	case "FeatureCollection":
+		for _, f := range o.Features {
+			if err := a.addObject(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Feature":
+		if o.Geometry == nil {
+			return fmt.Errorf("feature has no geometry")
+		}
+		return a.addGeometry(*o.Geometry)
+	}
+	return a.addGeometry(o.geoJSONGeometry)
+}
+
+// ParseGeoJSONArea reads the Polygon and MultiPolygon geometries out of a GeoJSON
+// geometry, Feature or FeatureCollection
+func ParseGeoJSONArea(data []byte) (*Area, error) {
+	var o geoJSONObject
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("can not unmarshal GeoJSON: %s", err)
+	}
+	a := &Area{}
+	if err := a.addObject(o); err != nil {
+		return nil, err
+	}
+	if len(a.Polygons) == 0 {
+		return nil, fmt.Errorf("GeoJSON contains no polygons")
+	}
+	return a, nil
+}
+
+func LoadGeoJSONArea(path string) (*Area, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGeoJSONArea(data)
+}
+
+// ray casting test, positions on the edge may land on either side
+func ringContains(r Ring, lat float64, lon float64) bool {
+	in := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi := r[i][0], r[i][1]
+		xj, yj := r[j][0], r[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+func (p Polygon) contains(lat float64, lon float64) bool {
+	if !ringContains(p[0], lat, lon) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if ringContains(hole, lat, lon) {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains reports whether a point in degrees is inside any polygon of the area
+func (a Area) Contains(lat float64, lon float64) bool {
+	for _, p := range a.Polygons {
+		// unwrapped rings may extend past +/-180, so try the point in the neighbouring frames too
+		for _, shift := range []float64{0, 360, -360} {
+			if p.contains(lat, lon+shift) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p Polygon) lngRange() (float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, pos := range p[0] {
+		lo = math.Min(lo, pos[0])
+		hi = math.Max(hi, pos[0])
+	}
+	return lo, hi
+}
+
+// envelope of the polygons with each polygon's west edge placed in [frame, frame+360)
+func (a Area) lngEnvelope(frame float64) (float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range a.Polygons {
+		plo, phi := p.lngRange()
+		shift := 360 * math.Floor((plo-frame)/360)
+		lo = math.Min(lo, plo-shift)
+		hi = math.Max(hi, phi-shift)
+	}
+	return lo, hi
+}
+
+// Envelope returns the bounding box of the area, which crosses the antimeridian
+// if the area does
+func (a Area) Envelope() (*Bounds, error) {
+	if len(a.Polygons) == 0 {
+		return nil, fmt.Errorf("area contains no polygons")
+	}
+	nlat, slat := math.Inf(-1), math.Inf(1)
+	for _, p := range a.Polygons {
+		for _, pos := range p[0] {
+			nlat = math.Max(nlat, pos[1])
+			slat = math.Min(slat, pos[1])
+		}
+	}
+	// pick whichever of the -180 or 0 based frames gives the narrower envelope, so areas
+	// on both sides of the antimeridian don't wrap the long way around the globe
+	wlng, elng := a.lngEnvelope(-180)
+	if lo, hi := a.lngEnvelope(0); hi-lo < elng-wlng {
+		wlng, elng = lo, hi
+	}
+	return boundsFromWidth(wlng, elng-wlng, nlat, slat), nil
+}
+
+// GetSensorsInArea queries the envelope of the area and keeps only the sensors inside it.
+// latitude and longitude are added to the requested fields if they are missing.
+func (c Client) GetSensorsInArea(params map[string]string, area *Area) (*Sensors, error) {
+	envelope, err := area.Envelope()
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.GetSensorsInBounds(withLocationFields(params), envelope)
+	if err != nil {
+		return nil, err
+	}
+	ilat := s.FieldIndex("latitude")
+	ilon := s.FieldIndex("longitude")
+	if ilat < 0 || ilon < 0 {
+		return nil, fmt.Errorf("response is missing latitude or longitude")
+	}
+	inside := make([][]*float32, 0, len(s.Data))
+	for _, d := range s.Data {
+		if d[ilat] == nil || d[ilon] == nil {
+			continue
+		}
+		if area.Contains(float64(*d[ilat]), float64(*d[ilon])) {
+			inside = append(inside, d)
+		}
+	}
+	s.Data = inside
+	return s, nil
+}
@@ -0,0 +1,108 @@
+package purpleair
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGeoJSONPolygon(t *testing.T) {
+	a, err := ParseGeoJSONArea([]byte(`{
+		"type": "Feature",
+		"properties": {"name": "triangle"},
+		"geometry": {
+			"type": "Polygon",
+			"coordinates": [[[-97.0, 33.0], [-96.0, 33.0], [-96.5, 34.0], [-97.0, 33.0]]]
+		}
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(a.Polygons))
+	assert.True(t, a.Contains(33.2, -96.5))
+	assert.False(t, a.Contains(33.9, -96.9)) // inside the envelope, outside the triangle
+	envelope, err := a.Envelope()
+	assert.Nil(t, err)
+	assert.Equal(t, "nwlng=-97.00000&nwlat=34.00000&selng=-96.00000&selat=33.00000", envelope.UrlString())
+}
+
+func TestParseGeoJSONMultiPolygonWithHole(t *testing.T) {
+	a, err := ParseGeoJSONArea([]byte(`{
+		"type": "FeatureCollection",
+		"features": [{
+			"type": "Feature",
+			"geometry": {
+				"type": "MultiPolygon",
+				"coordinates": [
+					[[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]], [[4, 4], [6, 4], [6, 6], [4, 6], [4, 4]]],
+					[[[20, 0], [30, 0], [30, 10], [20, 10], [20, 0]]]
+				]
+			}
+		}]
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(a.Polygons))
+	assert.True(t, a.Contains(2, 2))
+	assert.False(t, a.Contains(5, 5)) // in the hole
+	assert.False(t, a.Contains(5, 15))
+	assert.True(t, a.Contains(5, 25))
+	envelope, err := a.Envelope()
+	assert.Nil(t, err)
+	assert.Equal(t, "nwlng=0.00000&nwlat=10.00000&selng=30.00000&selat=0.00000", envelope.UrlString())
+}
+
+func TestParseGeoJSONAntimeridian(t *testing.T) {
+	a, err := ParseGeoJSONArea([]byte(`{
+		"type": "Polygon",
+		"coordinates": [[[170, -10], [-170, -10], [-170, 10], [170, 10], [170, -10]]]
+	}`))
+	assert.Nil(t, err)
+	assert.True(t, a.Contains(0, 179))
+	assert.True(t, a.Contains(0, -179))
+	assert.False(t, a.Contains(0, 0))
+	assert.False(t, a.Contains(0, 160))
+	envelope, err := a.Envelope()
+	assert.Nil(t, err)
+	assert.True(t, envelope.CrossesAntimeridian())
+	envelopes := envelope.Split()
+	assert.Equal(t, 2, len(envelopes))
+	assert.Equal(t, "nwlng=170.00000&nwlat=10.00000&selng=180.00000&selat=-10.00000", envelopes[0].UrlString())
+	assert.Equal(t, "nwlng=-180.00000&nwlat=10.00000&selng=-170.00000&selat=-10.00000", envelopes[1].UrlString())
+}
+
+func TestParseGeoJSONBad(t *testing.T) {
+	_, err := ParseGeoJSONArea([]byte(`{"type": "Point", "coordinates": [0, 0]}`))
+	assert.NotNil(t, err)
+	_, err = ParseGeoJSONArea([]byte(`{"type": "Polygon", "coordinates": [[[0, 0], [1, 1], [0, 0]]]}`))
+	assert.NotNil(t, err)
+	_, err = ParseGeoJSONArea([]byte(`{"type": "FeatureCollection", "features": []}`))
+	assert.NotNil(t, err)
+}
+
+func TestGetSensorsInArea(t *testing.T) {
+	rows := [][]float32{
+		{1, 0, 179, 5.0},
+		{2, 0, -179, 6.0},
+		{3, 0, 160, 7.0},
+		{4, 0, -160, 8.0},
+		{5, 9.5, 179.5, 9.0},
+	}
+	requests := 0
+	server := setupBoundsServer(t, rows, &requests)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	a, err := ParseGeoJSONArea([]byte(`{
+		"type": "Polygon",
+		"coordinates": [[[170, -10], [-170, -10], [-170, 10], [170, 10], [170, -10]]]
+	}`))
+	assert.Nil(t, err)
+
+	s, err := c.GetSensorsInArea(map[string]string{"fields": "pm2.5"}, a)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+	idx := s.FieldIndex("sensor_index")
+	got := []float32{}
+	for _, d := range s.Data {
+		got = append(got, *d[idx])
+	}
+	assert.ElementsMatch(t, []float32{1, 2, 5}, got)
+}
@@ -0,0 +1,248 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Gap is a time range with no samples written for a sensor, exclusive of Start and End which
+// are the samples either side of it
+type Gap struct {
+	SensorIndex int       `json:"sensor_index"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+}
+
+type backfillFile struct {
+	Last  map[int]time.Time `json:"last"`
+	Gaps  []Gap             `json:"gaps"`
+	Day   string            `json:"day"`
+	Spent int               `json:"spent"`
+}
+
+// Backfiller notes when each sensor's samples were written, and fills the gaps left by an
+// outage from the history endpoint. Its state is kept in a file so gaps across restarts are
+// found too. Points spent on history are capped per UTC day. A key the history endpoint refuses,
+// because it has no history access, disables backfilling until restart.
+type Backfiller struct {
+	Fields          []string      // history fields, pm*_atm are written as pm*
+	Average         int           // minutes, see HistoryMaxSpan
+	MaxInterval     time.Duration // samples further apart than this leave a gap
+	MaxPointsPerDay int           // 0 for no limit
+	Path            string
+
+	client   *Client
+	now      func() time.Time
+	mu       sync.Mutex
+	last     map[int]time.Time
+	gaps     []Gap
+	day      string
+	spent    int
+	disabled error
+}
+
+// NewBackfiller makes a backfiller keeping its state in path, which may be empty to only
+// fill gaps seen while running
+func NewBackfiller(c *Client, path string) *Backfiller {
+	return &Backfiller{
+		Fields:          []string{"humidity", "temperature", "voc", "pm1.0_atm", "pm2.5_atm", "pm10.0_atm", "pm2.5_alt"},
+		Average:         10,
+		MaxInterval:     10 * time.Minute,
+		MaxPointsPerDay: 20000,
+		Path:            path,
+		client:          c,
+		now:             time.Now,
+		last:            make(map[int]time.Time),
+	}
+}
+
+// Load reads the state file. A missing file is not an error.
+func (b *Backfiller) Load() error {
+	if b.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(b.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f backfillFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("can not unmarshal backfill state %s: %s", b.Path, err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last = f.Last
+	if b.last == nil {
+		b.last = make(map[int]time.Time)
+	}
+	b.gaps = f.Gaps
+	b.day = f.Day
+	b.spent = f.Spent
+	return nil
+}
+
+func (b *Backfiller) Save() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.saveLocked()
+}
+
+func (b *Backfiller) saveLocked() error {
+	if b.Path == "" {
+		return nil
+	}
+	data, err := json.Marshal(backfillFile{Last: b.last, Gaps: b.gaps, Day: b.day, Spent: b.spent})
+	if err != nil {
+		return err
+	}
+	// write then rename so a crash doesn't leave a truncated state
+	tmp := b.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.Path)
+}
+
+// Observe records samples that were written, noting a gap for any sensor whose previous
+// sample is more than MaxInterval older
+func (b *Backfiller) Observe(samples []SensorSample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range samples {
+		last, ok := b.last[s.SensorIndex]
+		if ok && s.Time.Sub(last) > b.MaxInterval {
+			b.gaps = append(b.gaps, Gap{SensorIndex: s.SensorIndex, Start: last, End: s.Time})
+		}
+		if !ok || s.Time.After(last) {
+			b.last[s.SensorIndex] = s.Time
+		}
+	}
+}
+
+// Gaps lists the gaps not yet filled, oldest noted first
+func (b *Backfiller) Gaps() []Gap {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Gap{}, b.gaps...)
+}
+
+// Sensors are the sensors whose samples have been observed, by sensor_index
+func (b *Backfiller) Sensors() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sensors := make([]int, 0, len(b.last))
+	for sensor_index := range b.last {
+		sensors = append(sensors, sensor_index)
+	}
+	sort.Ints(sensors)
+	return sensors
+}
+
+// Disabled is the error that disabled backfilling, nil while it isn't
+func (b *Backfiller) Disabled() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.disabled
+}
+
+// Spent is the points spent on history today
+func (b *Backfiller) Spent() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.day != b.now().UTC().Format("2006-01-02") {
+		return 0
+	}
+	return b.spent
+}
+
+// Backfill fetches the gaps from the history endpoint in order, in requests no longer than the
+// averaging period allows, and passes each request's samples to write. A range is only marked
+// filled once write succeeds. It stops without error when the next request would go over
+// MaxPointsPerDay, leaving the rest for another day, and returns the points spent.
+func (b *Backfiller) Backfill(write func(samples []SensorSample) error) (int, error) {
+	span, err := HistoryMaxSpan(b.Average)
+	if err != nil {
+		return 0, err
+	}
+	// real-time history has a row for every 2 minute reading
+	step := 2 * time.Minute
+	if b.Average > 0 {
+		step = time.Duration(b.Average) * time.Minute
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.disabled != nil {
+		return 0, nil
+	}
+	if today := b.now().UTC().Format("2006-01-02"); b.day != today {
+		b.day = today
+		b.spent = 0
+	}
+	points := 0
+	for len(b.gaps) > 0 {
+		g := b.gaps[0]
+		// averaged rows are on multiples of the period, so a gap may have none in it
+		if b.Average > 0 && !g.Start.Truncate(step).Add(step).Before(g.End) {
+			b.gaps = b.gaps[1:]
+			continue
+		}
+		end := g.End
+		if end.Sub(g.Start) > span {
+			end = g.Start.Add(span)
+		}
+		if b.MaxPointsPerDay > 0 {
+			// one point per field per row, time_stamp included, so shorten the request to the
+			// rows that fit in what's left of today's points
+			rows := (b.MaxPointsPerDay-b.spent)/(len(b.Fields)+1) - 1
+			if rows < 1 {
+				break
+			}
+			if limit := g.Start.Add(time.Duration(rows) * step); limit.Before(end) {
+				end = limit
+			}
+		}
+		h, err := b.client.GetSensorHistory(g.SensorIndex, g.Start, end, b.Average, b.Fields)
+		var apiErr *ResponseError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == 401 || apiErr.StatusCode == 403) {
+			// asking again won't help, and would be refused every poll
+			b.disabled = err
+			return points, fmt.Errorf("backfill disabled: %w", err)
+		}
+		if err != nil {
+			return points, err
+		}
+		cost := len(h.Data) * len(h.Fields)
+		points += cost
+		b.spent += cost
+		samples := make([]SensorSample, 0, len(h.Data))
+		for _, s := range h.SensorSamples() {
+			if s.Time.After(g.Start) && s.Time.Before(end) {
+				samples = append(samples, s)
+			}
+		}
+		if len(samples) > 0 {
+			if err := write(samples); err != nil {
+				b.saveLocked()
+				return points, err
+			}
+		}
+		if end.Equal(g.End) {
+			b.gaps = b.gaps[1:]
+		} else {
+			// a row at end goes with the next request
+			b.gaps[0].Start = end.Add(-time.Second)
+		}
+		if err := b.saveLocked(); err != nil {
+			return points, err
+		}
+	}
+	return points, b.saveLocked()
+}
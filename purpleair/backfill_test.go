@@ -0,0 +1,142 @@
+package purpleair
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func observed(t int64) []SensorSample {
+	return []SensorSample{*NewSensorSample(15111, time.Unix(t, 0).UTC())}
+}
+
+func TestBackfill(t *testing.T) {
+	var queries []string
+	server := setupHistoryServer(t, &queries)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	path := filepath.Join(t.TempDir(), "backfill.json")
+
+	b := NewBackfiller(c, path)
+	b.Fields = []string{"pm2.5_atm", "humidity"}
+	b.now = func() time.Time { return time.Unix(1664200000, 0) }
+	b.Observe(observed(1664170800))
+	b.Observe(observed(1664170920)) // 2 minutes, not a gap
+	assert.Nil(t, b.Save())
+
+	// restarted an hour later
+	b = NewBackfiller(c, path)
+	b.Fields = []string{"pm2.5_atm", "humidity"}
+	b.now = func() time.Time { return time.Unix(1664200000, 0) }
+	assert.Nil(t, b.Load())
+	assert.Equal(t, []int{15111}, b.Sensors())
+	b.Observe(observed(1664174520))
+	assert.Equal(t, []Gap{{SensorIndex: 15111, Start: time.Unix(1664170920, 0).UTC(), End: time.Unix(1664174520, 0).UTC()}}, b.Gaps())
+
+	var written []SensorSample
+	write := func(samples []SensorSample) error {
+		written = append(written, samples...)
+		return nil
+	}
+	points, err := b.Backfill(write)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(queries))
+	// 05:50 to 06:40, inside the gap
+	assert.Equal(t, 6, len(written))
+	assert.Equal(t, time.Unix(1664171400, 0).UTC(), written[0].Time)
+	assert.Equal(t, time.Unix(1664174400, 0).UTC(), written[5].Time)
+	assert.Equal(t, 18, points)
+	assert.Equal(t, 18, b.Spent())
+	assert.Empty(t, b.Gaps())
+
+	// nothing left to do
+	points, err = b.Backfill(write)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, points)
+	assert.Equal(t, 1, len(queries))
+}
+
+func TestBackfillPointsCap(t *testing.T) {
+	var queries []string
+	server := setupHistoryServer(t, &queries)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+
+	now := time.Unix(1664200000, 0)
+	b := NewBackfiller(c, "")
+	b.Fields = []string{"pm2.5_atm", "humidity"}
+	b.now = func() time.Time { return now }
+	b.MaxPointsPerDay = 12 // 3 rows at 3 points a row, plus one for a row at the end
+	b.Observe(observed(1664170800))
+	b.Observe(observed(1664174400))
+
+	var written []SensorSample
+	write := func(samples []SensorSample) error {
+		written = append(written, samples...)
+		return nil
+	}
+	_, err := b.Backfill(write)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(queries))
+	assert.Equal(t, 2, len(written)) // 05:50 and 06:00, the 06:10 row is picked up next time
+	assert.Equal(t, time.Unix(1664172599, 0).UTC(), b.Gaps()[0].Start)
+
+	// the rest waits for tomorrow
+	_, err = b.Backfill(write)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(queries))
+
+	now = now.Add(24 * time.Hour)
+	_, err = b.Backfill(write)
+	assert.Nil(t, err)
+	assert.Empty(t, b.Gaps())
+	assert.Equal(t, 5, len(written))
+	for i, s := range written {
+		assert.Equal(t, time.Unix(1664171400+600*int64(i), 0).UTC(), s.Time)
+	}
+}
+
+func TestBackfillWriteError(t *testing.T) {
+	var queries []string
+	server := setupHistoryServer(t, &queries)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+
+	b := NewBackfiller(c, "")
+	b.Fields = []string{"pm2.5_atm"}
+	b.Observe(observed(1664170800))
+	b.Observe(observed(1664174400))
+	_, err := b.Backfill(func(samples []SensorSample) error { return errors.New("sink down") })
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(b.Gaps()))
+}
+
+func TestBackfillDisabled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"ApiDisabledError","description":"History is not enabled for this key"}`))
+	}))
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+
+	b := NewBackfiller(c, "")
+	b.Observe(observed(1664170800))
+	b.Observe(observed(1664174400))
+	_, err := b.Backfill(func(samples []SensorSample) error { return nil })
+	assert.ErrorIs(t, err, ApiDisabledError)
+	assert.ErrorIs(t, b.Disabled(), ApiDisabledError)
+	_, err = b.Backfill(func(samples []SensorSample) error { return nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,212 @@
+package purpleair
+
+import (
+	"fmt"
+	"math"
+)
+
+func (b Bounds) NwLng() float32 {
+	return b.nwlng
+}
+
+func (b Bounds) NwLat() float32 {
+	return b.nwlat
+}
+
+func (b Bounds) SeLng() float32 {
+	return b.selng
+}
+
+func (b Bounds) SeLat() float32 {
+	return b.selat
+}
+
+// CrossesAntimeridian is true when the box runs east from nwlng across +/-180 to selng
+func (b Bounds) CrossesAntimeridian() bool {
+	return b.nwlng > b.selng
+}
+
+// width of the box in degrees of longitude
+func (b Bounds) lngWidth() float64 {
+	w := float64(b.selng) - float64(b.nwlng)
+	if w < 0 {
+		w += 360
+	}
+	return w
+}
+
+// builds a box from its west edge and width in degrees, wrapping it across the antimeridian if needed
+func boundsFromWidth(wlng float64, width float64, nlat float64, slat float64) *Bounds {
+	if width >= 360 {
+		return &Bounds{nwlng: -180, nwlat: float32(nlat), selng: 180, selat: float32(slat)}
+	}
+	wlng = math.Mod(wlng+180, 360)
+	if wlng < 0 {
+		wlng += 360
+	}
+	wlng -= 180
+	elng := wlng + width
+	if elng > 180 {
+		elng -= 360
+	}
+	return &Bounds{nwlng: float32(wlng), nwlat: float32(nlat), selng: float32(elng), selat: float32(slat)}
+}
+
+// NewBoundsAroundPoint returns the smallest box that contains a circle of radius_km
+// centered on lat_deg,lon_deg
+func NewBoundsAroundPoint(lat_deg float64, lon_deg float64, radius_km float64) (*Bounds, error) {
+	b, err := NewBounds(float32(lon_deg), float32(lat_deg), float32(lon_deg), float32(lat_deg))
+	if err != nil {
+		return nil, err
+	}
+	return b.Expand(radius_km), nil
+}
+
+func (b Bounds) Contains(lat float32, lon float32) bool {
+	if lat > b.nwlat || lat < b.selat {
+		return false
+	}
+	if b.CrossesAntimeridian() {
+		return lon >= b.nwlng || lon <= b.selng
+	}
+	return lon >= b.nwlng && lon <= b.selng
+}
+
+func (b Bounds) Intersects(o Bounds) bool {
+	if b.selat > o.nwlat || o.selat > b.nwlat {
+		return false
+	}
+	bw, bwidth := float64(b.nwlng), b.lngWidth()
+	ow, owidth := float64(o.nwlng), o.lngWidth()
+	for _, shift := range []float64{-360, 0, 360} {
+		if ow+shift <= bw+bwidth && bw <= ow+shift+owidth {
+			return true
+		}
+	}
+	return false
+}
+
+// Union returns the smallest box containing both boxes, going whichever way around
+// the globe is narrower
+func (b Bounds) Union(o Bounds) *Bounds {
+	bw, bwidth := float64(b.nwlng), b.lngWidth()
+	ow, owidth := float64(o.nwlng), o.lngWidth()
+	wlng, width := 0.0, math.Inf(1)
+	for _, shift := range []float64{-360, 0, 360} {
+		w := math.Min(bw, ow+shift)
+		e := math.Max(bw+bwidth, ow+shift+owidth)
+		if e-w < width {
+			wlng, width = w, e-w
+		}
+	}
+	nlat := math.Max(float64(b.nwlat), float64(o.nwlat))
+	slat := math.Min(float64(b.selat), float64(o.selat))
+	return boundsFromWidth(wlng, width, nlat, slat)
+}
+
+// Expand grows the box by distance_km on every side. Boxes that reach a pole
+// grow to cover all longitudes.
+func (b Bounds) Expand(distance_km float64) *Bounds {
+	d_rad := distanceKmToRadians(distance_km)
+	nlat := math.Min(90, float64(b.nwlat)+Degrees(d_rad))
+	slat := math.Max(-90, float64(b.selat)-Degrees(d_rad))
+	// a circle's longitude extent is widest on the side of the box nearest a pole
+	lat_rad := Radians(math.Max(math.Abs(float64(b.nwlat)), math.Abs(float64(b.selat))))
+	if nlat >= 90 || slat <= -90 || math.Sin(d_rad) >= math.Cos(lat_rad) {
+		return boundsFromWidth(-180, 360, nlat, slat)
+	}
+	dlng := Degrees(math.Asin(math.Sin(d_rad) / math.Cos(lat_rad)))
+	return boundsFromWidth(float64(b.nwlng)-dlng, b.lngWidth()+2*dlng, nlat, slat)
+}
+
+// Center returns the lat,lon at the middle of the box
+func (b Bounds) Center() (float32, float32) {
+	lat := (float64(b.nwlat) + float64(b.selat)) / 2
+	c := boundsFromWidth(float64(b.nwlng)+b.lngWidth()/2, 0, lat, lat)
+	return c.nwlat, c.nwlng
+}
+
+// Tile splits the box into nx columns by ny rows, ordered west to east
+// then north to south
+func (b Bounds) Tile(nx int, ny int) ([]*Bounds, error) {
+	if nx < 1 || ny < 1 {
+		return nil, fmt.Errorf("tile counts must be at least 1")
+	}
+	dlng := b.lngWidth() / float64(nx)
+	dlat := (float64(b.nwlat) - float64(b.selat)) / float64(ny)
+	tiles := make([]*Bounds, 0, nx*ny)
+	for j := 0; j < ny; j++ {
+		nlat := float64(b.nwlat) - float64(j)*dlat
+		slat := float64(b.nwlat) - float64(j+1)*dlat
+		if j == ny-1 {
+			slat = float64(b.selat)
+		}
+		for i := 0; i < nx; i++ {
+			tiles = append(tiles, boundsFromWidth(float64(b.nwlng)+float64(i)*dlng, dlng, nlat, slat))
+		}
+	}
+	return tiles, nil
+}
+
+// Split returns the box as boxes that can be sent to the api, which is two
+// boxes either side of the antimeridian if the box crosses it
+func (b Bounds) Split() []*Bounds {
+	if !b.CrossesAntimeridian() {
+		return []*Bounds{{nwlng: b.nwlng, nwlat: b.nwlat, selng: b.selng, selat: b.selat}}
+	}
+	return []*Bounds{
+		{nwlng: b.nwlng, nwlat: b.nwlat, selng: 180, selat: b.selat},
+		{nwlng: -180, nwlat: b.nwlat, selng: b.selng, selat: b.selat},
+	}
+}
+
+// merges the rows of responses made with the same parameters, skipping sensors seen before
+func mergeSensors(responses []*Sensors) *Sensors {
+	if len(responses) == 0 {
+		return nil
+	}
+	merged := *responses[0]
+	merged.Data = nil
+	merged.lastSeen = nil
+	merged.points = 0
+	idx := merged.FieldIndex("sensor_index")
+	seen := make(map[float32]bool)
+	for _, s := range responses {
+		merged.points += s.Points()
+		for _, d := range s.Data {
+			if idx >= 0 && d[idx] != nil {
+				if seen[*d[idx]] {
+					continue
+				}
+				seen[*d[idx]] = true
+			}
+			merged.Data = append(merged.Data, d)
+		}
+		for k, v := range s.lastSeen {
+			if merged.lastSeen == nil {
+				merged.lastSeen = make(map[int]uint)
+			}
+			merged.lastSeen[k] = v
+		}
+	}
+	return &merged
+}
+
+// GetSensorsInBounds is GetSensors for the sensors within b. Boxes that cross the
+// antimeridian are queried as two boxes and the results merged.
+func (c Client) GetSensorsInBounds(params map[string]string, b *Bounds) (*Sensors, error) {
+	split := b.Split()
+	responses := make([]*Sensors, 0, len(split))
+	for _, sb := range split {
+		p := map[string]string{}
+		for k, v := range params {
+			p[k] = v
+		}
+		s, err := c.GetSensors(AppendBoundsParams(p, sb))
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, s)
+	}
+	return mergeSensors(responses), nil
+}
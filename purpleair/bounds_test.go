@@ -0,0 +1,127 @@
+package purpleair
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoundsAccessors(t *testing.T) {
+	b, err := NewBounds(12.3, 45.6, 78.9, -1.2)
+	assert.Nil(t, err)
+	assert.Equal(t, float32(12.3), b.NwLng())
+	assert.Equal(t, float32(45.6), b.NwLat())
+	assert.Equal(t, float32(78.9), b.SeLng())
+	assert.Equal(t, float32(-1.2), b.SeLat())
+	assert.False(t, b.CrossesAntimeridian())
+}
+
+func TestBoundsAntimeridian(t *testing.T) {
+	b, err := NewBounds(170, 10, -170, -10)
+	assert.Nil(t, err)
+	assert.True(t, b.CrossesAntimeridian())
+	assert.True(t, b.Contains(0, 175))
+	assert.True(t, b.Contains(0, -175))
+	assert.False(t, b.Contains(0, 0))
+	lat, lon := b.Center()
+	assert.InDelta(t, 0, lat, 1e-4)
+	assert.InDelta(t, -180, lon, 1e-4) // +/-180 are the same meridian
+
+	split := b.Split()
+	assert.Equal(t, 2, len(split))
+	assert.Equal(t, "nwlng=170.00000&nwlat=10.00000&selng=180.00000&selat=-10.00000", split[0].UrlString())
+	assert.Equal(t, "nwlng=-180.00000&nwlat=10.00000&selng=-170.00000&selat=-10.00000", split[1].UrlString())
+}
+
+func TestBoundsIntersects(t *testing.T) {
+	a, _ := NewBounds(0, 10, 10, 0)
+	b, _ := NewBounds(5, 15, 15, 5)
+	c, _ := NewBounds(20, 10, 30, 0)
+	d, _ := NewBounds(170, 10, -170, -10)
+	e, _ := NewBounds(-175, 5, -160, -5)
+	assert.True(t, a.Intersects(*b))
+	assert.False(t, a.Intersects(*c))
+	assert.False(t, a.Intersects(*d))
+	assert.True(t, d.Intersects(*e))
+	assert.True(t, e.Intersects(*d))
+}
+
+func TestBoundsUnion(t *testing.T) {
+	a, _ := NewBounds(0, 10, 10, 0)
+	c, _ := NewBounds(20, 20, 30, 5)
+	assert.Equal(t, "nwlng=0.00000&nwlat=20.00000&selng=30.00000&selat=0.00000", a.Union(*c).UrlString())
+
+	// the short way around is across the antimeridian
+	w, _ := NewBounds(170, 10, 175, 0)
+	e, _ := NewBounds(-175, 10, -170, 0)
+	u := w.Union(*e)
+	assert.True(t, u.CrossesAntimeridian())
+	assert.Equal(t, "nwlng=170.00000&nwlat=10.00000&selng=-170.00000&selat=0.00000", u.UrlString())
+}
+
+func TestBoundsExpand(t *testing.T) {
+	b, _ := NewBounds(-96.7, 33.4, -96.6, 33.3)
+	x := b.Expand(10)
+	assert.InDelta(t, 33.4+0.0899, x.NwLat(), 1e-3)
+	assert.InDelta(t, 33.3-0.0899, x.SeLat(), 1e-3)
+	assert.InDelta(t, -96.7-0.108, x.NwLng(), 1e-3)
+	assert.InDelta(t, -96.6+0.108, x.SeLng(), 1e-3)
+
+	// reaching the pole covers all longitudes
+	p, _ := NewBounds(10, 89.9, 20, 89)
+	x = p.Expand(50)
+	assert.Equal(t, float32(90), x.NwLat())
+	assert.Equal(t, float32(-180), x.NwLng())
+	assert.Equal(t, float32(180), x.SeLng())
+
+	// growing across the antimeridian wraps
+	a, _ := NewBounds(179.95, 1, 179.99, 0)
+	x = a.Expand(10)
+	assert.True(t, x.CrossesAntimeridian())
+}
+
+func TestBoundsAroundPoint(t *testing.T) {
+	b, err := NewBoundsAroundPoint(0, 179.99, 5)
+	assert.Nil(t, err)
+	assert.True(t, b.CrossesAntimeridian())
+	assert.True(t, b.Contains(0, -179.97))
+	_, err = NewBoundsAroundPoint(95, 0, 5)
+	assert.NotNil(t, err)
+}
+
+func TestBoundsTile(t *testing.T) {
+	b, _ := NewBounds(0, 10, 20, 0)
+	tiles, err := b.Tile(2, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(tiles))
+	assert.Equal(t, "nwlng=0.00000&nwlat=10.00000&selng=10.00000&selat=5.00000", tiles[0].UrlString())
+	assert.Equal(t, "nwlng=10.00000&nwlat=10.00000&selng=20.00000&selat=5.00000", tiles[1].UrlString())
+	assert.Equal(t, "nwlng=0.00000&nwlat=5.00000&selng=10.00000&selat=0.00000", tiles[2].UrlString())
+
+	d, _ := NewBounds(170, 10, -170, -10)
+	tiles, err = d.Tile(4, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "nwlng=175.00000&nwlat=10.00000&selng=180.00000&selat=-10.00000", tiles[1].UrlString())
+	assert.Equal(t, "nwlng=-180.00000&nwlat=10.00000&selng=-175.00000&selat=-10.00000", tiles[2].UrlString())
+
+	_, err = b.Tile(0, 1)
+	assert.NotNil(t, err)
+}
+
+func TestGetSensorsInBounds(t *testing.T) {
+	rows := [][]float32{
+		{1, 0, 179, 5.0},
+		{2, 0, -179, 6.0},
+		{3, 0, 160, 7.0},
+	}
+	requests := 0
+	server := setupBoundsServer(t, rows, &requests)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	b, _ := NewBounds(170, 10, -170, -10)
+	s, err := c.GetSensorsInBounds(map[string]string{"fields": "latitude,longitude,pm2.5"}, b)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 2, len(s.Data))
+}
@@ -15,6 +15,9 @@ type Client struct {
 	WriteKey   string
 	BaseURL    string
 	HTTPClient *http.Client
+	Points     *PointsTracker   // nil to not track or limit points
+	Keys       *KeyPool         // read keys to use instead of ReadKey, nil for just ReadKey
+	OnPoints   func(points int) // called with the points each response was charged, if set
 }
 
 func NewClient(readkey string, writekey string) (*Client, error) {
@@ -59,18 +62,53 @@ func (c Client) NewGetRequest(endpoint string, params map[string]string) *http.R
 	return req
 }
 
-func (c Client) KeysValid() (bool, error) {
-	resp, err := c.HTTPClient.Do(c.NewGetRequest("/keys", nil))
+// get requests endpoint with the read key, or the next of the pool's keys. An error response
+// is returned as a *ResponseError. A pool key the api says is invalid or disabled is taken out
+// of use, and the request is tried again with the next.
+func (c Client) get(endpoint string, params map[string]string) (*http.Response, error) {
+	for {
+		key := c.ReadKey
+		if c.Keys != nil {
+			var err error
+			key, err = c.Keys.Next()
+			if err != nil {
+				return nil, err
+			}
+		}
+		resp, err := c.getWithKey(endpoint, params, key)
+		var apiErr *ResponseError
+		if errors.As(err, &apiErr) && c.Keys != nil && c.Keys.Disable(key, apiErr) {
+			continue
+		}
+		return resp, err
+	}
+}
+
+// getWithKey requests endpoint with key. An error response is returned as a *ResponseError.
+func (c Client) getWithKey(endpoint string, params map[string]string, key string) (*http.Response, error) {
+	req := c.NewGetRequest(endpoint, params)
+	req.Header.Set("X-API-Key", key)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return false, err
+		return nil, fmt.Errorf("error getting %s: %s", endpoint, err)
 	}
-	if resp.StatusCode == http.StatusForbidden {
-		return false, nil
+	if resp.StatusCode < 400 {
+		return resp, nil
 	}
-	if resp.StatusCode != http.StatusCreated {
-		return false, fmt.Errorf("expected return code 201 or 403 got %d", resp.StatusCode)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return nil, fmt.Errorf("error getting %s: %w", endpoint, parseResponseError(resp.StatusCode, body))
+}
+
+// KeysValid reports whether the api accepts the read key. Use GetKeyInfo for what kind of key
+// it is.
+func (c Client) KeysValid() (bool, error) {
+	_, err := c.GetKeyInfo(c.ReadKey)
+	var apiErr *ResponseError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden {
+		return false, nil
 	}
-	return true, nil
+	return err == nil, err
 }
 
 func (c Client) GetSensors(params map[string]string) (*Sensors, error) {
@@ -79,10 +117,16 @@ func (c Client) GetSensors(params map[string]string) (*Sensors, error) {
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.HTTPClient.Do(c.NewGetRequest("/sensors", params))
+	estimate, err := c.reservePoints("/sensors", params)
 	if err != nil {
-		return nil, fmt.Errorf("error getting /sensors: %s", err)
+		return nil, err
+	}
+	defer c.releasePoints(estimate)
+	resp, err := c.get("/sensors", params)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("error reading response body: %s", err)
@@ -91,6 +135,7 @@ func (c Client) GetSensors(params map[string]string) (*Sensors, error) {
 	if err := json.Unmarshal(body, &s); err != nil { // Parse []byte to go struct pointer
 		return nil, fmt.Errorf("can not unmarshal response JSON")
 	}
+	c.recordPoints(resp, "/sensors", params, len(s.Data), s.Points())
 	return &s, err
 
 }
@@ -98,6 +143,7 @@ func (c Client) GetSensors(params map[string]string) (*Sensors, error) {
 type Sample struct {
 	Timestamp  uint               `json:"time_stamp"`
 	Sampledata map[string]float32 `json:"data"`
+	Tags       map[string]string  `json:"tags,omitempty"`
 }
 
 func NewSample(ts uint) *Sample {
@@ -0,0 +1,171 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyMaxSpan is the longest time range the history endpoint returns in one request for
+// each averaging period in minutes
+var historyMaxSpan = map[int]time.Duration{
+	0:    2 * 24 * time.Hour,
+	10:   3 * 24 * time.Hour,
+	30:   7 * 24 * time.Hour,
+	60:   14 * 24 * time.Hour,
+	360:  90 * 24 * time.Hour,
+	1440: 365 * 24 * time.Hour,
+}
+
+// HistoryMaxSpan returns the longest range one history request may cover at an averaging period
+func HistoryMaxSpan(average int) (time.Duration, error) {
+	span, ok := historyMaxSpan[average]
+	if !ok {
+		return 0, fmt.Errorf("invalid history average %d, expected 0, 10, 30, 60, 360 or 1440", average)
+	}
+	return span, nil
+}
+
+// historyFieldNames maps history fields to the real-time field that has the same values for
+// outside sensors, so backfilled samples line up with polled ones
+var historyFieldNames = map[string]string{
+	"pm1.0_atm":  "pm1.0",
+	"pm2.5_atm":  "pm2.5",
+	"pm10.0_atm": "pm10.0",
+}
+
+// historyFields are the fields the history endpoint has
+var historyFields = []string{
+	"humidity", "temperature", "pressure", "voc", "scattering_coefficient", "deciviews", "visual_range",
+	"rssi", "uptime", "pa_latency", "memory", "analog_input",
+	"0.3_um_count", "0.5_um_count", "1.0_um_count", "2.5_um_count", "5.0_um_count", "10.0_um_count",
+	"pm1.0_atm", "pm1.0_cf_1", "pm2.5_alt", "pm2.5_atm", "pm2.5_cf_1", "pm10.0_atm", "pm10.0_cf_1",
+}
+
+// HistoryFields are the history fields to request for samples with the same fields as polling
+// for fields: pm1.0, pm2.5 and pm10.0 become their _atm fields, and fields history doesn't
+// have, such as last_seen, are left out
+func HistoryFields(fields []string) []string {
+	var h []string
+	for _, f := range fields {
+		for hf, name := range historyFieldNames {
+			if name == f {
+				f = hf
+			}
+		}
+		if contains(historyFields, f) && !contains(h, f) {
+			h = append(h, f)
+		}
+	}
+	return h
+}
+
+// SensorHistory is a /sensors/:sensor_index/history response. Rows start with time_stamp, which
+// is decoded as float64 so it keeps its precision.
+type SensorHistory struct {
+	TimeStamp      uint         `json:"time_stamp"`
+	DataTimeStamp  uint         `json:"data_time_stamp"`
+	SensorIndex    int          `json:"sensor_index"`
+	StartTimestamp uint         `json:"start_timestamp"`
+	EndTimestamp   uint         `json:"end_timestamp"`
+	Average        int          `json:"average"`
+	Fields         []string     `json:"fields"`
+	Data           [][]*float64 `json:"data"`
+}
+
+// GetSensorHistory gets a sensor's readings from start to end averaged over average minutes.
+// It needs a read key with history access.
+func (c Client) GetSensorHistory(sensor_index int, start time.Time, end time.Time, average int, fields []string) (*SensorHistory, error) {
+	if _, err := HistoryMaxSpan(average); err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if !contains(allValidFields(), f) {
+			return nil, fmt.Errorf("invalid field %s", f)
+		}
+	}
+	params := map[string]string{
+		"start_timestamp": strconv.FormatInt(start.Unix(), 10),
+		"end_timestamp":   strconv.FormatInt(end.Unix(), 10),
+		"average":         strconv.Itoa(average),
+		"fields":          strings.Join(fields, ","),
+	}
+	endpoint := fmt.Sprintf("/sensors/%d/history", sensor_index)
+	if c.Points != nil {
+		estimate := estimateHistoryPoints(start, end, average, fields)
+		if err := c.Points.Allow(estimate); err != nil {
+			return nil, err
+		}
+		defer c.Points.Release(estimate)
+	}
+	resp, err := c.get(endpoint, params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %s", err)
+	}
+	var h SensorHistory
+	if err := json.Unmarshal(body, &h); err != nil {
+		return nil, fmt.Errorf("can not unmarshal response JSON")
+	}
+	if h.SensorIndex == 0 {
+		h.SensorIndex = sensor_index
+	}
+	c.recordPoints(resp, endpoint, params, -1, len(h.Fields)*len(h.Data))
+	return &h, nil
+}
+
+// estimateHistoryPoints is a point per field, and time_stamp, for each row the range can
+// return. Real-time history has a row about every 2 minutes.
+func estimateHistoryPoints(start time.Time, end time.Time, average int, fields []string) int {
+	step := time.Duration(average) * time.Minute
+	if average == 0 {
+		step = 2 * time.Minute
+	}
+	rows := int(end.Sub(start) / step)
+	if rows < 1 {
+		rows = 1
+	}
+	return rows * (len(fields) + 1)
+}
+
+// SensorSamples converts the history rows to samples at their own times, oldest first
+func (h *SensorHistory) SensorSamples() []SensorSample {
+	its := -1
+	for i, f := range h.Fields {
+		if f == "time_stamp" {
+			its = i
+		}
+	}
+	if its < 0 {
+		return nil
+	}
+	samples := make([]SensorSample, 0, len(h.Data))
+	for _, row := range h.Data {
+		if its >= len(row) || row[its] == nil {
+			continue
+		}
+		s := NewSensorSample(h.SensorIndex, time.Unix(int64(*row[its]), 0).UTC())
+		for i, v := range row {
+			if i == its || i >= len(h.Fields) || v == nil {
+				continue
+			}
+			name := h.Fields[i]
+			if n, ok := historyFieldNames[name]; ok {
+				name = n
+			}
+			s.Fields[name] = *v
+		}
+		samples = append(samples, *s)
+	}
+	// the api doesn't promise an order
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples
+}
@@ -0,0 +1,85 @@
+package purpleair
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serves a time_stamp,pm2.5_atm,humidity row every average minutes in the requested range,
+// newest first like the api, and records each request's query
+func setupHistoryServer(t *testing.T, queries *[]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sensors/15111/history" {
+			t.Errorf("Expected to request '/sensors/15111/history', got: %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		*queries = append(*queries, r.URL.RawQuery)
+		start, _ := strconv.ParseInt(q.Get("start_timestamp"), 10, 64)
+		end, _ := strconv.ParseInt(q.Get("end_timestamp"), 10, 64)
+		average, _ := strconv.ParseInt(q.Get("average"), 10, 64)
+		var rows []string
+		for ts := end - end%(average*60); ts >= start; ts -= average * 60 {
+			rows = append(rows, fmt.Sprintf("[%d,8.5,null]", ts))
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{
+			"time_stamp": 1664200000,
+			"sensor_index": 15111,
+			"start_timestamp": %d,
+			"end_timestamp": %d,
+			"average": %d,
+			"fields": ["time_stamp","pm2.5_atm","humidity"],
+			"data": [%s]
+		}`, start, end, average, strings.Join(rows, ","))
+	}))
+	return server
+}
+
+func TestGetSensorHistory(t *testing.T) {
+	var queries []string
+	server := setupHistoryServer(t, &queries)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+
+	h, err := c.GetSensorHistory(15111, time.Unix(1664170800, 0), time.Unix(1664172600, 0), 10, []string{"pm2.5_atm", "humidity"})
+	assert.Nil(t, err)
+	assert.Equal(t, "average=10&end_timestamp=1664172600&fields=pm2.5_atm,humidity&start_timestamp=1664170800", queries[0])
+	assert.Equal(t, 4, len(h.Data))
+
+	samples := h.SensorSamples()
+	assert.Equal(t, 4, len(samples))
+	assert.Equal(t, time.Unix(1664170800, 0).UTC(), samples[0].Time)
+	assert.Equal(t, time.Unix(1664172600, 0).UTC(), samples[3].Time)
+	assert.Equal(t, map[string]float64{"pm2.5": 8.5}, samples[0].Fields)
+
+	_, err = c.GetSensorHistory(15111, time.Unix(1664170800, 0), time.Unix(1664172600, 0), 15, []string{"pm2.5_atm"})
+	assert.NotNil(t, err)
+	_, err = c.GetSensorHistory(15111, time.Unix(1664170800, 0), time.Unix(1664172600, 0), 10, []string{"pm3.0"})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(queries))
+}
+
+func TestGetSensorHistoryError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"ApiDisabledError","description":"History is not enabled for this key"}`))
+	}))
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	_, err := c.GetSensorHistory(15111, time.Unix(1664170800, 0), time.Unix(1664172600, 0), 10, []string{"pm2.5_atm"})
+	assert.ErrorContains(t, err, "History is not enabled")
+}
+
+func TestHistoryFields(t *testing.T) {
+	assert.Equal(t, []string{"humidity", "pm2.5_atm", "pm2.5_alt", "pm10.0_cf_1"},
+		HistoryFields([]string{"humidity", "pm2.5", "pm2.5_alt", "last_seen", "confidence", "pm10.0_cf_1", "pm2.5_atm"}))
+}
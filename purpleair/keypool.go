@@ -0,0 +1,192 @@
+package purpleair
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// KeyPool is read keys a client takes turns with, or with ByBudget uses the key with the most
+// of its daily budget left. A key the api says is invalid or disabled is taken out of use.
+// Keys have names for logging, so the keys themselves aren't logged.
+type KeyPool struct {
+	ByBudget  bool
+	OnDisable func(name string, err error) // called when a key is taken out of use
+
+	mu   sync.Mutex
+	now  func() time.Time
+	keys []*poolKey
+	next int
+	day  string
+}
+
+type poolKey struct {
+	name     string
+	key      string
+	budget   int
+	requests int
+	points   int
+	disabled error
+}
+
+// KeyUsage is what one of a pool's keys has been used for today
+type KeyUsage struct {
+	Name     string
+	Budget   int // points a day, 0 for no limit
+	Requests int
+	Points   int
+	Disabled error // why the key was taken out of use, nil while it's in use
+}
+
+func NewKeyPool() *KeyPool {
+	return &KeyPool{now: time.Now}
+}
+
+// Add adds key to the pool with budget points a UTC day, 0 for no limit
+func (p *KeyPool) Add(name string, key string, budget int) error {
+	if key == "" {
+		return fmt.Errorf("read key %s is empty", name)
+	}
+	if budget < 0 {
+		return fmt.Errorf("read key %s budget can't be negative", name)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range p.keys {
+		if k.name == name {
+			return fmt.Errorf("read key %s is in the pool twice", name)
+		}
+		if k.key == key {
+			return fmt.Errorf("read keys %s and %s are the same key", k.name, name)
+		}
+	}
+	p.keys = append(p.keys, &poolKey{name: name, key: key, budget: budget})
+	return nil
+}
+
+func (p *KeyPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.keys)
+}
+
+// rollLocked starts each key's usage again on a new UTC day
+func (p *KeyPool) rollLocked() {
+	day := p.now().UTC().Format("2006-01-02")
+	if p.day == day {
+		return
+	}
+	p.day = day
+	for _, k := range p.keys {
+		k.requests = 0
+		k.points = 0
+	}
+}
+
+// left is the key's budget that's left today, or what's most for a key without a budget
+func (k *poolKey) left() int {
+	if k.budget == 0 {
+		return math.MaxInt - k.points
+	}
+	return k.budget - k.points
+}
+
+// Next is the key to make the next request with. It returns a *BudgetError when every key
+// that's in use has spent its budget.
+func (p *KeyPool) Next() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rollLocked()
+	var use *poolKey
+	in_use := 0
+	start := p.next
+	for i := range p.keys {
+		k := p.keys[(start+i)%len(p.keys)]
+		if k.disabled != nil {
+			continue
+		}
+		in_use++
+		if k.left() <= 0 {
+			continue
+		}
+		if use == nil {
+			use = k
+			p.next = (start + i + 1) % len(p.keys)
+			if !p.ByBudget {
+				break
+			}
+		} else if k.left() > use.left() {
+			use = k
+		}
+	}
+	if use == nil {
+		if in_use == 0 {
+			return "", fmt.Errorf("no read keys left in use")
+		}
+		e := &BudgetError{Period: "day"}
+		for _, k := range p.keys {
+			if k.disabled == nil {
+				e.Budget += k.budget
+				e.Spent += k.points
+			}
+		}
+		now := p.now().UTC()
+		e.Reset = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return "", e
+	}
+	use.requests++
+	return use.key, nil
+}
+
+// Record adds the points a request made with key was charged
+func (p *KeyPool) Record(key string, points int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rollLocked()
+	for _, k := range p.keys {
+		if k.key == key {
+			k.points += points
+		}
+	}
+}
+
+// Disable takes key out of use if err is ApiKeyInvalidError or ApiDisabledError, and reports
+// whether the key is out of use, so the request can be tried with another
+func (p *KeyPool) Disable(key string, err error) bool {
+	if !errors.Is(err, ApiKeyInvalidError) && !errors.Is(err, ApiDisabledError) {
+		return false
+	}
+	p.mu.Lock()
+	found := false
+	var disabled *poolKey
+	for _, k := range p.keys {
+		if k.key != key {
+			continue
+		}
+		found = true
+		// requests in flight with the key may fail after it's been taken out
+		if k.disabled == nil {
+			k.disabled = err
+			disabled = k
+		}
+	}
+	p.mu.Unlock()
+	if disabled != nil && p.OnDisable != nil {
+		p.OnDisable(disabled.name, err)
+	}
+	return found
+}
+
+// Usage is what each key has been used for today, in the order they were added
+func (p *KeyPool) Usage() []KeyUsage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rollLocked()
+	usage := make([]KeyUsage, len(p.keys))
+	for i, k := range p.keys {
+		usage[i] = KeyUsage{Name: k.name, Budget: k.budget, Requests: k.requests, Points: k.points, Disabled: k.disabled}
+	}
+	return usage
+}
@@ -0,0 +1,114 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPoolRoundRobin(t *testing.T) {
+	p := NewKeyPool()
+	assert.Nil(t, p.Add("prod", "key-a", 0))
+	assert.Nil(t, p.Add("dev", "key-b", 0))
+	assert.NotNil(t, p.Add("dev", "key-c", 0))
+	assert.NotNil(t, p.Add("other", "key-a", 0))
+	var keys []string
+	for i := 0; i < 4; i++ {
+		k, err := p.Next()
+		assert.Nil(t, err)
+		keys = append(keys, k)
+	}
+	assert.Equal(t, []string{"key-a", "key-b", "key-a", "key-b"}, keys)
+
+	// only invalid or disabled keys are taken out of use
+	assert.False(t, p.Disable("key-a", &ResponseError{StatusCode: 403, Type: ApiKeyRestrictedError}))
+	assert.True(t, p.Disable("key-a", &ResponseError{StatusCode: 403, Type: ApiKeyInvalidError}))
+	k, _ := p.Next()
+	assert.Equal(t, "key-b", k)
+	k, _ = p.Next()
+	assert.Equal(t, "key-b", k)
+	p.Disable("key-b", &ResponseError{StatusCode: 403, Type: ApiDisabledError})
+	_, err := p.Next()
+	assert.NotNil(t, err)
+}
+
+func TestKeyPoolByBudget(t *testing.T) {
+	p := NewKeyPool()
+	p.ByBudget = true
+	now := time.Date(2022, 9, 26, 12, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	p.Add("small", "key-a", 1000)
+	p.Add("big", "key-b", 5000)
+	k, _ := p.Next()
+	assert.Equal(t, "key-b", k)
+	p.Record("key-b", 4500)
+	k, _ = p.Next()
+	assert.Equal(t, "key-a", k)
+	p.Record("key-a", 1000)
+	k, _ = p.Next()
+	assert.Equal(t, "key-b", k)
+	p.Record("key-b", 500)
+	_, err := p.Next()
+	var e *BudgetError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, 6000, e.Spent)
+	assert.Equal(t, time.Date(2022, 9, 27, 0, 0, 0, 0, time.UTC), e.Reset)
+	assert.Equal(t, []KeyUsage{
+		{Name: "small", Budget: 1000, Requests: 1, Points: 1000},
+		{Name: "big", Budget: 5000, Requests: 2, Points: 5000},
+	}, p.Usage())
+
+	now = now.Add(12 * time.Hour)
+	k, err = p.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "key-b", k)
+}
+
+func TestClientKeyPool(t *testing.T) {
+	var used []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		used = append(used, key)
+		if key == "revoked" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"api_version":"V1.0.11-0.0.41","error":"ApiKeyInvalidError","description":"The provided api_key was not valid."}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		v := float32(8.5)
+		json.NewEncoder(w).Encode(Sensors{Fields: []string{"sensor_index", "pm2.5"}, Data: [][]*float32{{&v, &v}}})
+	}))
+	defer server.Close()
+	c, _ := NewClient("revoked", "")
+	c.BaseURL = server.URL
+	c.Keys = NewKeyPool()
+	c.Keys.Add("old", "revoked", 0)
+	c.Keys.Add("new", "good", 0)
+	var disabled []string
+	c.Keys.OnDisable = func(name string, err error) {
+		disabled = append(disabled, name)
+	}
+
+	_, err := c.GetSensors(map[string]string{"fields": "pm2.5"})
+	assert.Nil(t, err)
+	_, err = c.GetSensors(map[string]string{"fields": "pm2.5"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"revoked", "good", "good"}, used)
+	assert.Equal(t, []string{"old"}, disabled)
+	usage := c.Keys.Usage()
+	assert.True(t, errors.Is(usage[0].Disabled, ApiKeyInvalidError))
+	assert.Equal(t, 4, usage[1].Points)
+
+	// without a pool the error is returned
+	c.Keys = nil
+	_, err = c.GetSensors(map[string]string{"fields": "pm2.5"})
+	var re *ResponseError
+	assert.True(t, errors.As(err, &re))
+	assert.Equal(t, ApiKeyInvalidError, re.Type)
+	assert.ErrorContains(t, err, "The provided api_key was not valid.")
+}
@@ -0,0 +1,71 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// KeyInfo is what the api says about a key
+type KeyInfo struct {
+	APIVersion string `json:"api_version"`
+	TimeStamp  uint   `json:"time_stamp"`
+	Type       string `json:"api_key_type"` // READ or WRITE
+}
+
+// Time is when the api checked the key
+func (k KeyInfo) Time() time.Time {
+	return time.Unix(int64(k.TimeStamp), 0).UTC()
+}
+
+// GetKeyInfo asks the api about key, which needn't be the client's. A key the api doesn't
+// accept is a *ResponseError such as ApiKeyInvalidError.
+func (c Client) GetKeyInfo(key string) (*KeyInfo, error) {
+	resp, err := c.getWithKey("/keys", nil, key)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %s", err)
+	}
+	var k KeyInfo
+	if err := json.Unmarshal(body, &k); err != nil {
+		return nil, fmt.Errorf("can not unmarshal response JSON")
+	}
+	return &k, nil
+}
+
+// check requests endpoint with key and discards the response, to see whether the key may use it
+func (c Client) check(key string, endpoint string, params map[string]string) error {
+	resp, err := c.getWithKey(endpoint, params, key)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// CheckSensors checks key may use /sensors, with a box in the ocean that has no sensors in it
+// so it costs no points
+func (c Client) CheckSensors(key string) error {
+	params := map[string]string{"fields": "sensor_index"}
+	b, _ := NewBounds(-10, 0.001, -9.999, 0)
+	return c.check(key, "/sensors", AppendBoundsParams(params, b))
+}
+
+// CheckHistory checks key may use a sensor's history, which needs history access, with the
+// sensor's last hour
+func (c Client) CheckHistory(key string, sensor_index int) error {
+	end := time.Now()
+	params := map[string]string{
+		"start_timestamp": strconv.FormatInt(end.Add(-time.Hour).Unix(), 10),
+		"end_timestamp":   strconv.FormatInt(end.Unix(), 10),
+		"average":         "60",
+		"fields":          "pm2.5_atm",
+	}
+	return c.check(key, fmt.Sprintf("/sensors/%d/history", sensor_index), params)
+}
@@ -0,0 +1,81 @@
+package purpleair
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serves /keys for a READ key and a WRITE key, which may only use /keys
+func setupKeysServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key != "read-key" && key != "write-key" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"ApiKeyInvalidError","description":"The provided api_key was not valid."}`))
+			return
+		}
+		if r.URL.Path == "/keys" {
+			w.WriteHeader(http.StatusCreated)
+			if key == "read-key" {
+				w.Write([]byte(`{"api_version":"V1.0.11-0.0.40","time_stamp":1663477141,"api_key_type":"READ"}`))
+			} else {
+				w.Write([]byte(`{"api_version":"V1.0.11-0.0.40","time_stamp":1663477141,"api_key_type":"WRITE"}`))
+			}
+			return
+		}
+		if key == "write-key" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"ApiKeyTypeMismatchError","description":"The provided api_key was not a READ key."}`))
+			return
+		}
+		if r.URL.Path == "/sensors/15111/history" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"ApiDisabledError","description":"History is not enabled for this key"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"fields":["sensor_index"],"data":[]}`))
+	}))
+	return server
+}
+
+func TestGetKeyInfo(t *testing.T) {
+	server := setupKeysServer(t)
+	defer server.Close()
+	c, _ := NewClient("read-key", "write-key")
+	c.BaseURL = server.URL
+
+	k, err := c.GetKeyInfo(c.ReadKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "READ", k.Type)
+	assert.Equal(t, "V1.0.11-0.0.40", k.APIVersion)
+	assert.Equal(t, time.Unix(1663477141, 0).UTC(), k.Time())
+	k, err = c.GetKeyInfo(c.WriteKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "WRITE", k.Type)
+	_, err = c.GetKeyInfo("nosuch")
+	assert.True(t, errors.Is(err, ApiKeyInvalidError))
+
+	c.ReadKey = "nosuch"
+	valid, err := c.KeysValid()
+	assert.Nil(t, err)
+	assert.False(t, valid)
+}
+
+func TestCheckEndpoints(t *testing.T) {
+	server := setupKeysServer(t)
+	defer server.Close()
+	c, _ := NewClient("read-key", "write-key")
+	c.BaseURL = server.URL
+
+	assert.Nil(t, c.CheckSensors("read-key"))
+	assert.True(t, errors.Is(c.CheckSensors("write-key"), ApiKeyTypeMismatchError))
+	err := c.CheckHistory("read-key", 15111)
+	assert.True(t, errors.Is(err, ApiDisabledError))
+	assert.ErrorContains(t, err, "History is not enabled")
+}
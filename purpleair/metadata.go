@@ -0,0 +1,332 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SensorMetadata holds the sensor attributes that rarely change
+type SensorMetadata struct {
+	SensorIndex     int      `json:"sensor_index"`
+	Name            string   `json:"name"`
+	Latitude        float64  `json:"latitude"`
+	Longitude       float64  `json:"longitude"`
+	Model           string   `json:"model"`
+	FirmwareVersion string   `json:"firmware_version"`
+	LocationType    Location `json:"location_type"`
+	LastModified    uint     `json:"last_modified"`
+}
+
+func metadataFields() []string {
+	return []string{"name", "latitude", "longitude", "model", "firmware_version", "location_type", "last_modified"}
+}
+
+// Tags returns the metadata as string tags for output sinks
+func (m SensorMetadata) Tags() map[string]string {
+	return map[string]string{
+		"name":             m.Name,
+		"latitude":         strconv.FormatFloat(m.Latitude, 'f', -1, 64),
+		"longitude":        strconv.FormatFloat(m.Longitude, 'f', -1, 64),
+		"model":            m.Model,
+		"firmware_version": m.FirmwareVersion,
+		"location_type":    fmt.Sprintf("%d", m.LocationType),
+	}
+}
+
+// a /sensors response with string fields, which Sensors can't hold
+type metadataResponse struct {
+	TimeStamp uint            `json:"time_stamp"`
+	Fields    []string        `json:"fields"`
+	Data      [][]interface{} `json:"data"`
+}
+
+func (r metadataResponse) sensors() []SensorMetadata {
+	sensors := make([]SensorMetadata, 0, len(r.Data))
+	for _, row := range r.Data {
+		var m SensorMetadata
+		valid := false
+		for i, v := range row {
+			if v == nil || i >= len(r.Fields) {
+				continue
+			}
+			switch r.Fields[i] {
+			case "sensor_index":
+				if f, ok := v.(float64); ok {
+					m.SensorIndex = int(math.Round(f))
+					valid = true
+				}
+			case "name":
+				m.Name, _ = v.(string)
+			case "latitude":
+				m.Latitude, _ = v.(float64)
+			case "longitude":
+				m.Longitude, _ = v.(float64)
+			case "model":
+				m.Model, _ = v.(string)
+			case "firmware_version":
+				m.FirmwareVersion, _ = v.(string)
+			case "location_type":
+				if f, ok := v.(float64); ok {
+					m.LocationType = Location(f)
+				}
+			case "last_modified":
+				if f, ok := v.(float64); ok {
+					m.LastModified = uint(f)
+				}
+			}
+		}
+		if valid {
+			sensors = append(sensors, m)
+		}
+	}
+	return sensors
+}
+
+// GetSensorsMetadata requests the metadata fields for the sensors matching params
+func (c Client) GetSensorsMetadata(params map[string]string) ([]SensorMetadata, uint, error) {
+	p := map[string]string{}
+	for k, v := range params {
+		p[k] = v
+	}
+	p["fields"] = strings.Join(metadataFields(), ",")
+	if err := validateParams(p); err != nil {
+		return nil, 0, err
+	}
+	estimate, err := c.reservePoints("/sensors", p)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer c.releasePoints(estimate)
+	resp, err := c.get("/sensors", p)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading response body: %s", err)
+	}
+	var r metadataResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, 0, fmt.Errorf("can not unmarshal response JSON")
+	}
+	c.recordPoints(resp, "/sensors", p, len(r.Data), len(r.Fields)*len(r.Data))
+	return r.sensors(), r.TimeStamp, nil
+}
+
+type metadataFile struct {
+	TimeStamp     uint             `json:"time_stamp"`
+	FullTimeStamp uint             `json:"full_time_stamp"`
+	Sensors       []SensorMetadata `json:"sensors"`
+}
+
+// MetadataCache keeps the metadata for the sensors matching a set of /sensors parameters.
+// Every FullInterval the whole set is requested again, which drops sensors that have gone
+// away. In between, every ModifiedInterval only the sensors modified since the last request
+// are fetched using modified_since. The cache is saved to Path after each refresh so it can be
+// loaded on startup without spending points. With Bounds set, each box of Bounds.Split is
+// requested with the parameters, so a box across the antimeridian is cached too.
+type MetadataCache struct {
+	FullInterval     time.Duration
+	ModifiedInterval time.Duration
+	Path             string
+	Bounds           *Bounds
+
+	client        *Client
+	params        map[string]string
+	now           func() time.Time
+	mu            sync.RWMutex
+	sensors       map[int]SensorMetadata
+	timeStamp     uint // api time of the last refresh, used for modified_since
+	fullTimeStamp uint // api time of the last full refresh
+}
+
+// NewMetadataCache makes a cache for the sensors matching params, which should select sensors
+// (bounds, location_type, show_only) but not fields. path may be empty to keep the cache in memory.
+func NewMetadataCache(c *Client, params map[string]string, path string) *MetadataCache {
+	return &MetadataCache{
+		FullInterval:     24 * time.Hour,
+		ModifiedInterval: 1 * time.Hour,
+		Path:             path,
+		client:           c,
+		params:           params,
+		now:              time.Now,
+		sensors:          make(map[int]SensorMetadata),
+	}
+}
+
+// Load reads the cache file. A missing file is not an error, the next Update does a full refresh.
+func (m *MetadataCache) Load() error {
+	if m.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f metadataFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("can not unmarshal metadata cache %s: %s", m.Path, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sensors = make(map[int]SensorMetadata, len(f.Sensors))
+	for _, s := range f.Sensors {
+		m.sensors[s.SensorIndex] = s
+	}
+	m.timeStamp = f.TimeStamp
+	m.fullTimeStamp = f.FullTimeStamp
+	return nil
+}
+
+func (m *MetadataCache) Save() error {
+	if m.Path == "" {
+		return nil
+	}
+	m.mu.RLock()
+	f := metadataFile{
+		TimeStamp:     m.timeStamp,
+		FullTimeStamp: m.fullTimeStamp,
+		Sensors:       make([]SensorMetadata, 0, len(m.sensors)),
+	}
+	for _, s := range m.sensors {
+		f.Sensors = append(f.Sensors, s)
+	}
+	m.mu.RUnlock()
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	// write then rename so a crash doesn't leave a truncated cache
+	tmp := m.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.Path)
+}
+
+// Refresh requests the metadata again, all of it if full is true or only the sensors
+// modified since the last refresh otherwise
+func (m *MetadataCache) Refresh(full bool) error {
+	m.mu.RLock()
+	since := m.timeStamp
+	m.mu.RUnlock()
+	p := map[string]string{}
+	for k, v := range m.params {
+		p[k] = v
+	}
+	if !full {
+		p["modified_since"] = fmt.Sprintf("%d", since)
+	}
+	boxes := []*Bounds{nil}
+	if m.Bounds != nil {
+		boxes = m.Bounds.Split()
+	}
+	var sensors []SensorMetadata
+	var ts uint
+	for _, b := range boxes {
+		bp := p
+		if b != nil {
+			bp = AppendBoundsParams(map[string]string{}, b)
+			for k, v := range p {
+				bp[k] = v
+			}
+		}
+		s, t, err := m.client.GetSensorsMetadata(bp)
+		if err != nil {
+			return err
+		}
+		sensors = append(sensors, s...)
+		// the earliest, so modified_since misses nothing in either box
+		if ts == 0 || t < ts {
+			ts = t
+		}
+	}
+	m.mu.Lock()
+	if full {
+		m.sensors = make(map[int]SensorMetadata, len(sensors))
+		m.fullTimeStamp = ts
+	}
+	for _, s := range sensors {
+		m.sensors[s.SensorIndex] = s
+	}
+	m.timeStamp = ts
+	m.mu.Unlock()
+	return m.Save()
+}
+
+// Update refreshes the cache if either interval has passed, and is cheap to call every poll
+func (m *MetadataCache) Update() error {
+	now := m.now().Unix()
+	m.mu.RLock()
+	ts, fullTs := int64(m.timeStamp), int64(m.fullTimeStamp)
+	m.mu.RUnlock()
+	if fullTs == 0 || now-fullTs >= int64(m.FullInterval.Seconds()) {
+		return m.Refresh(true)
+	}
+	if now-ts >= int64(m.ModifiedInterval.Seconds()) {
+		return m.Refresh(false)
+	}
+	return nil
+}
+
+func (m *MetadataCache) Get(sensor_index int) (SensorMetadata, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sensors[sensor_index]
+	return s, ok
+}
+
+func (m *MetadataCache) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.sensors)
+}
+
+// Join adds the metadata tags of each sample's sensor to the sample. Samples from
+// sensors not in the cache are left as they are.
+func (m *MetadataCache) Join(samples []Sample) {
+	for i := range samples {
+		idx, ok := samples[i].Sampledata["sensor_index"]
+		if !ok {
+			continue
+		}
+		meta, ok := m.Get(int(math.Round(float64(idx))))
+		if !ok {
+			continue
+		}
+		if samples[i].Tags == nil {
+			samples[i].Tags = make(map[string]string)
+		}
+		for k, v := range meta.Tags() {
+			samples[i].Tags[k] = v
+		}
+	}
+}
+
+// JoinSensorSamples adds the metadata tags of each sample's sensor to the sample. Samples from
+// sensors not in the cache are left as they are.
+func (m *MetadataCache) JoinSensorSamples(samples []SensorSample) {
+	for i := range samples {
+		meta, ok := m.Get(samples[i].SensorIndex)
+		if !ok {
+			continue
+		}
+		if samples[i].Tags == nil {
+			samples[i].Tags = make(map[string]string)
+		}
+		for k, v := range meta.Tags() {
+			samples[i].Tags[k] = v
+		}
+	}
+}
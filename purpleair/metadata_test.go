@@ -0,0 +1,139 @@
+package purpleair
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupMetadataServer(t *testing.T, modifiedSince *[]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sensors" {
+			t.Errorf("Expected to request '/sensors', got: %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("fields") != "name,latitude,longitude,model,firmware_version,location_type,last_modified" {
+			t.Errorf("unexpected fields %s", q.Get("fields"))
+		}
+		*modifiedSince = append(*modifiedSince, q.Get("modified_since"))
+		w.WriteHeader(http.StatusOK)
+		if q.Get("modified_since") != "" {
+			w.Write([]byte(`{
+				"time_stamp" : 1664174400,
+				"fields" : ["sensor_index","name","latitude","longitude","model","firmware_version","location_type","last_modified"],
+				"data" : [
+					[20755,"Renamed",33.3501,-96.6601,"PA-II","7.02",0,1664172000]
+				]
+			}`))
+			return
+		}
+		w.Write([]byte(`{
+			"time_stamp" : 1664170828,
+			"fields" : ["sensor_index","name","latitude","longitude","model","firmware_version","location_type","last_modified"],
+			"data" : [
+				[15111,"Backyard, North",33.3333,-96.6666,"PA-II","7.02",0,1660000000],
+				[20755,"Porch",33.35,-96.66,"PA-II-SD",null,0,1660000000]
+			]
+		}`))
+	}))
+	return server
+}
+
+func TestMetadataCache(t *testing.T) {
+	var modifiedSince []string
+	server := setupMetadataServer(t, &modifiedSince)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	path := filepath.Join(t.TempDir(), "metadata.json")
+
+	now := time.Unix(1664170900, 0)
+	m := NewMetadataCache(c, map[string]string{"location_type": "0"}, path)
+	m.now = func() time.Time { return now }
+	assert.Nil(t, m.Load())
+	assert.Nil(t, m.Update())
+	assert.Equal(t, 2, m.Len())
+	s, ok := m.Get(15111)
+	assert.True(t, ok)
+	assert.Equal(t, "Backyard, North", s.Name)
+	assert.InDelta(t, 33.3333, s.Latitude, 1e-6)
+	assert.Equal(t, "7.02", s.FirmwareVersion)
+
+	// nothing due yet
+	assert.Nil(t, m.Update())
+	assert.Equal(t, []string{""}, modifiedSince)
+
+	// an hour later only modified sensors are fetched
+	now = now.Add(time.Hour)
+	assert.Nil(t, m.Update())
+	assert.Equal(t, []string{"", "1664170828"}, modifiedSince)
+	s, _ = m.Get(20755)
+	assert.Equal(t, "Renamed", s.Name)
+	assert.Equal(t, 2, m.Len())
+
+	// a new cache starts from the file without a request
+	m2 := NewMetadataCache(c, map[string]string{"location_type": "0"}, path)
+	m2.now = func() time.Time { return now }
+	assert.Nil(t, m2.Load())
+	assert.Equal(t, 2, m2.Len())
+	assert.Nil(t, m2.Update())
+	assert.Equal(t, 2, len(modifiedSince))
+
+	// a day after the full refresh everything is fetched again
+	now = now.Add(24 * time.Hour)
+	assert.Nil(t, m2.Update())
+	assert.Equal(t, []string{"", "1664170828", ""}, modifiedSince)
+}
+
+func TestMetadataCacheAntimeridian(t *testing.T) {
+	var boxes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		boxes = append(boxes, q.Get("nwlng")+","+q.Get("selng"))
+		index := 1
+		if q.Get("nwlng") == "-180.000000" {
+			index = 2
+		}
+		w.Write([]byte(fmt.Sprintf(`{"time_stamp":1664170828,"fields":["sensor_index","name"],"data":[[%d,"Sensor %d"]]}`, index, index)))
+	}))
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	b, _ := NewBounds(179, 1, -179, -1)
+	m := NewMetadataCache(c, map[string]string{"location_type": "0"}, "")
+	m.Bounds = b
+	assert.Nil(t, m.Refresh(true))
+	assert.Equal(t, []string{"179.000000,180.000000", "-180.000000,-179.000000"}, boxes)
+	assert.Equal(t, 2, m.Len())
+	s, _ := m.Get(2)
+	assert.Equal(t, "Sensor 2", s.Name)
+}
+
+func TestMetadataCacheJoin(t *testing.T) {
+	var modifiedSince []string
+	server := setupMetadataServer(t, &modifiedSince)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	m := NewMetadataCache(c, map[string]string{}, "")
+	assert.Nil(t, m.Refresh(true))
+
+	samples := []Sample{
+		{Timestamp: 1664170800, Sampledata: map[string]float32{"sensor_index": 15111, "pm2.5": 8.7}},
+		{Timestamp: 1664170800, Sampledata: map[string]float32{"sensor_index": 90011, "pm2.5": 10.3}},
+	}
+	m.Join(samples)
+	assert.Equal(t, "Backyard, North", samples[0].Tags["name"])
+	assert.Equal(t, "33.3333", samples[0].Tags["latitude"])
+	assert.Equal(t, "PA-II", samples[0].Tags["model"])
+	assert.Nil(t, samples[1].Tags)
+
+	sensorSamples := []SensorSample{*NewSensorSample(20755, time.Unix(1664170800, 0))}
+	m.JoinSensorSamples(sensorSamples)
+	assert.Equal(t, "Porch", sensorSamples[0].Tags["name"])
+}
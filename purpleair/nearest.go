@@ -0,0 +1,128 @@
+package purpleair
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+type NearestOptions struct {
+	Fields          []string          // fields to return in addition to latitude and longitude
+	Params          map[string]string // extra /sensors parameters such as location_type or max_age
+	InitialRadiusKm float64           // radius of the first search, doubled on each expansion
+	MaxRadiusKm     float64           // the search stops expanding past this radius
+	MaxPoints       int               // approximate api points to spend before giving up, 0 for no limit
+}
+
+func DefaultNearestOptions() NearestOptions {
+	return NearestOptions{
+		Fields:          []string{},
+		Params:          map[string]string{"location_type": "0"},
+		InitialRadiusKm: 1,
+		MaxRadiusKm:     50,
+		MaxPoints:       0,
+	}
+}
+
+type NearbySensor struct {
+	SensorIndex int                `json:"sensor_index"`
+	DistanceKm  float64            `json:"distance_km"`
+	Latitude    float64            `json:"latitude"`
+	Longitude   float64            `json:"longitude"`
+	Sensordata  map[string]float32 `json:"data"`
+}
+
+func nearbySensors(s *Sensors, lat_deg float64, lon_deg float64) []NearbySensor {
+	idx := s.FieldIndex("sensor_index")
+	ilat := s.FieldIndex("latitude")
+	ilon := s.FieldIndex("longitude")
+	if idx < 0 || ilat < 0 || ilon < 0 {
+		return nil
+	}
+	nearby := make([]NearbySensor, 0, len(s.Data))
+	for _, d := range s.Data {
+		if d[idx] == nil || d[ilat] == nil || d[ilon] == nil {
+			continue
+		}
+		n := NearbySensor{
+			SensorIndex: int(math.Round(float64(*d[idx]))),
+			Latitude:    float64(*d[ilat]),
+			Longitude:   float64(*d[ilon]),
+			Sensordata:  make(map[string]float32),
+		}
+		n.DistanceKm = GreatCircleDistanceKm(Radians(lat_deg), Radians(lon_deg), Radians(n.Latitude), Radians(n.Longitude))
+		for j, v := range d {
+			if v != nil && j != idx && j != ilat && j != ilon {
+				n.Sensordata[s.Fields[j]] = *v
+			}
+		}
+		nearby = append(nearby, n)
+	}
+	sort.SliceStable(nearby, func(i, j int) bool {
+		return nearby[i].DistanceKm < nearby[j].DistanceKm
+	})
+	return nearby
+}
+
+// NearestSensors finds the n sensors closest to lat_deg,lon_deg by searching a box around
+// the point that doubles in radius until n sensors are inside the radius, the maximum radius
+// is reached, or the point budget is spent. Results are sorted nearest first and may hold
+// fewer than n sensors if the search gave up.
+func (c Client) NearestSensors(lat_deg float64, lon_deg float64, n int, opts NearestOptions) ([]NearbySensor, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("n must be greater than zero")
+	}
+	if opts.InitialRadiusKm <= 0 {
+		return nil, fmt.Errorf("initial radius must be greater than zero")
+	}
+	if opts.MaxRadiusKm < opts.InitialRadiusKm {
+		return nil, fmt.Errorf("max radius must be at least the initial radius")
+	}
+	fields := []string{"latitude", "longitude"}
+	for _, f := range opts.Fields {
+		if !contains(fields, f) {
+			fields = append(fields, f)
+		}
+	}
+
+	var found []NearbySensor
+	spent := 0
+	radius_km := opts.InitialRadiusKm
+	for {
+		b, err := NewBoundsAroundPoint(lat_deg, lon_deg, radius_km)
+		if err != nil {
+			return nil, err
+		}
+		params := map[string]string{}
+		for k, v := range opts.Params {
+			params[k] = v
+		}
+		params["fields"] = strings.Join(fields, ",")
+		s, err := c.GetSensorsInBounds(params, b)
+		if err != nil {
+			return nil, err
+		}
+		spent += s.Points()
+
+		// sensors in the corners of the box may be further away than sensors just
+		// outside it, so only those inside the radius are known to be the nearest
+		found = found[:0]
+		for _, ns := range nearbySensors(s, lat_deg, lon_deg) {
+			if ns.DistanceKm <= radius_km {
+				found = append(found, ns)
+			}
+		}
+		if len(found) >= n || radius_km >= opts.MaxRadiusKm {
+			break
+		}
+		if opts.MaxPoints > 0 && spent >= opts.MaxPoints {
+			break
+		}
+		radius_km = math.Min(2*radius_km, opts.MaxRadiusKm)
+	}
+	if len(found) > n {
+		found = found[:n]
+	}
+	return found, nil
+}
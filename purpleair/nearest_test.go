@@ -0,0 +1,123 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serves sensor_index,latitude,longitude,pm2.5 rows that fall within the requested bounds
+func setupBoundsServer(t *testing.T, rows [][]float32, requests *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sensors" {
+			t.Errorf("Expected to request '/sensors', got: %s", r.URL.Path)
+		}
+		*requests++
+		q := r.URL.Query()
+		nwlng, _ := strconv.ParseFloat(q.Get("nwlng"), 32)
+		nwlat, _ := strconv.ParseFloat(q.Get("nwlat"), 32)
+		selng, _ := strconv.ParseFloat(q.Get("selng"), 32)
+		selat, _ := strconv.ParseFloat(q.Get("selat"), 32)
+		data := [][]*float32{}
+		for _, row := range rows {
+			lat, lon := float64(row[1]), float64(row[2])
+			if lat <= nwlat && lat >= selat && lon >= nwlng && lon <= selng {
+				d := make([]*float32, len(row))
+				for i := range row {
+					d[i] = &row[i]
+				}
+				data = append(data, d)
+			}
+		}
+		s := Sensors{
+			APIVersion:    "V1.0.11-0.0.40",
+			TimeStamp:     1664170828,
+			DataTimeStamp: 1664170800,
+			Fields:        []string{"sensor_index", "latitude", "longitude", "pm2.5"},
+			Data:          data,
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(s)
+	}))
+	return server
+}
+
+func TestNearestSensors(t *testing.T) {
+	rows := [][]float32{
+		{1, 33.3500, -96.6666, 5.0},  // ~1.8km north
+		{2, 33.3340, -96.6666, 6.0},  // ~0.1km north
+		{3, 33.4500, -96.6666, 7.0},  // ~13km north
+		{4, 33.3333, -96.7000, 8.0},  // ~3.1km west
+		{5, 34.3333, -96.6666, 9.0},  // ~111km north
+		{6, 33.3333, -96.6300, 10.0}, // ~3.4km east
+	}
+	requests := 0
+	server := setupBoundsServer(t, rows, &requests)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+
+	opts := DefaultNearestOptions()
+	opts.Fields = []string{"pm2.5"}
+	nearest, err := c.NearestSensors(33.3333, -96.6666, 3, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(nearest))
+	assert.Equal(t, 2, nearest[0].SensorIndex)
+	assert.Equal(t, 1, nearest[1].SensorIndex)
+	assert.Equal(t, 4, nearest[2].SensorIndex)
+	assert.InDelta(t, 0.08, nearest[0].DistanceKm, 0.02)
+	assert.InDelta(t, 6.0, nearest[0].Sensordata["pm2.5"], 0.01)
+	assert.Equal(t, 3, requests) // 1km, 2km, 4km
+}
+
+func TestNearestSensorsMaxRadius(t *testing.T) {
+	rows := [][]float32{
+		{1, 33.3500, -96.6666, 5.0},
+		{5, 34.3333, -96.6666, 9.0},
+	}
+	requests := 0
+	server := setupBoundsServer(t, rows, &requests)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+
+	opts := DefaultNearestOptions()
+	opts.MaxRadiusKm = 10
+	nearest, err := c.NearestSensors(33.3333, -96.6666, 2, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(nearest))
+	assert.Equal(t, 5, requests) // 1km, 2km, 4km, 8km, 10km
+}
+
+func TestNearestSensorsPointBudget(t *testing.T) {
+	rows := [][]float32{
+		{1, 33.3340, -96.6666, 5.0},
+		{2, 33.3500, -96.6666, 5.0},
+	}
+	requests := 0
+	server := setupBoundsServer(t, rows, &requests)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+
+	opts := DefaultNearestOptions()
+	opts.MaxPoints = 1
+	nearest, err := c.NearestSensors(33.3333, -96.6666, 2, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(nearest))
+	assert.Equal(t, 1, requests)
+}
+
+func TestNearestSensorsBadArgs(t *testing.T) {
+	c, _ := NewClient("test-read-key", "")
+	_, err := c.NearestSensors(33.3333, -96.6666, 0, DefaultNearestOptions())
+	assert.NotNil(t, err)
+	opts := DefaultNearestOptions()
+	opts.MaxRadiusKm = 0.5
+	_, err = c.NearestSensors(33.3333, -96.6666, 1, opts)
+	assert.NotNil(t, err)
+}
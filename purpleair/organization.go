@@ -0,0 +1,49 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Organization is the organization a read key belongs to, and the api points it has left
+type Organization struct {
+	APIVersion      string `json:"api_version"`
+	TimeStamp       uint   `json:"time_stamp"`
+	ID              string `json:"organization_id"`
+	Name            string `json:"organization_name"`
+	RemainingPoints int    `json:"remaining_points"`
+}
+
+// Time is when the api reported the remaining points
+func (o Organization) Time() time.Time {
+	return time.Unix(int64(o.TimeStamp), 0).UTC()
+}
+
+// GetOrganization gets the read key's organization, or with a KeyPool the next key's
+func (c Client) GetOrganization() (*Organization, error) {
+	return decodeOrganization(c.get("/organization", nil))
+}
+
+// GetKeyOrganization gets key's organization, which needn't be the client's or the pool's next
+func (c Client) GetKeyOrganization(key string) (*Organization, error) {
+	return decodeOrganization(c.getWithKey("/organization", nil, key))
+}
+
+func decodeOrganization(resp *http.Response, err error) (*Organization, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %s", err)
+	}
+	var o Organization
+	if err := json.Unmarshal(body, &o); err != nil {
+		return nil, fmt.Errorf("can not unmarshal response JSON")
+	}
+	return &o, nil
+}
@@ -0,0 +1,42 @@
+package purpleair
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrganization(t *testing.T) {
+	var key string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("X-API-Key")
+		if r.URL.Path != "/organization" {
+			t.Errorf("Expected to request '/organization', got: %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{
+			"api_version": "V1.0.14-0.0.58",
+			"time_stamp": 1700000000,
+			"organization_id": "1a2b3c",
+			"organization_name": "Example Air",
+			"remaining_points": 987654
+		}`))
+	}))
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	o, err := c.GetOrganization()
+	assert.Nil(t, err)
+	assert.Equal(t, "Example Air", o.Name)
+	assert.Equal(t, "1a2b3c", o.ID)
+	assert.Equal(t, 987654, o.RemainingPoints)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), o.Time())
+
+	o, err = c.GetKeyOrganization("other-read-key")
+	assert.Nil(t, err)
+	assert.Equal(t, "other-read-key", key)
+	assert.Equal(t, 987654, o.RemainingPoints)
+}
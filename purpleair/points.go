@@ -0,0 +1,301 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EstimatePoints is what a /sensors query for fields is charged when it returns sensors: a
+// point per field per sensor. sensor_index is always returned, so it counts whether it's asked
+// for or not.
+func EstimatePoints(fields []string, sensors int) int {
+	n := len(fields)
+	if !contains(fields, "sensor_index") {
+		n++
+	}
+	return n * sensors
+}
+
+// BudgetError is returned instead of making a request that would go over a budget
+type BudgetError struct {
+	Period   string // day, month, or balance for the organization's remaining points
+	Budget   int
+	Spent    int
+	Estimate int
+	Reset    time.Time // when the period's budget starts again, or the balance is next checked
+}
+
+func (e *BudgetError) Error() string {
+	if e.Period == "balance" {
+		return fmt.Sprintf("request estimated at %d points would go over the %d points left, checking again at %s",
+			e.Estimate, e.Budget-e.Spent, e.Reset.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("request estimated at %d points would go over the %s's budget of %d, %d spent until %s",
+		e.Estimate, e.Period, e.Budget, e.Spent, e.Reset.Format(time.RFC3339))
+}
+
+type pointsState struct {
+	Day        string         `json:"day"`
+	DaySpent   int            `json:"day_spent"`
+	Month      string         `json:"month"`
+	MonthSpent int            `json:"month_spent"`
+	Sensors    map[string]int `json:"sensors"` // sensors last returned by each query
+}
+
+// PointsTracker adds up the points a client's requests are charged, by UTC day and month, and
+// refuses requests estimated to go over DailyBudget or MonthlyBudget with a *BudgetError. It
+// remembers how many sensors each query returned, to estimate the query's next request, and
+// estimates a query it hasn't seen at DefaultSensors, or the sensors of a show_only. It is
+// saved to Path after each request, if set, so budgets hold across restarts. With the
+// organization's remaining points from SetBalance it also refuses requests past them.
+type PointsTracker struct {
+	DailyBudget    int // 0 for no limit
+	MonthlyBudget  int // 0 for no limit
+	Path           string
+	OnError        func(err error) // failures to save, which don't fail the request
+	BalanceRefresh time.Duration   // how often SetBalance is expected, refused requests wait for the next
+	DefaultSensors int             // sensors a query that hasn't been seen is expected to return
+
+	mu              sync.Mutex
+	now             func() time.Time
+	state           pointsState
+	reserved        int // estimates of the requests Allow let through that haven't been Released
+	balanceReported int
+	balance         int       // balanceReported less what's been spent since
+	balanceAt       time.Time // zero until SetBalance
+}
+
+func NewPointsTracker(path string) *PointsTracker {
+	return &PointsTracker{
+		Path:           path,
+		BalanceRefresh: time.Hour,
+		DefaultSensors: 100,
+		now:            time.Now,
+		state:          pointsState{Sensors: make(map[string]int)},
+	}
+}
+
+// SetBalance records the points the organization has left, as the api reports them, which
+// takes the place of what's been counted down since the last
+func (t *PointsTracker) SetBalance(remaining int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.balanceReported = remaining
+	t.balance = remaining
+	t.balanceAt = t.now()
+}
+
+// Balance is the points the organization has left: as last reported, less what's been spent
+// since. ok is false before SetBalance.
+func (t *PointsTracker) Balance() (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.balance, !t.balanceAt.IsZero()
+}
+
+// Load reads the state file. A missing file is not an error.
+func (t *PointsTracker) Load() error {
+	if t.Path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(t.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := json.Unmarshal(b, &t.state); err != nil {
+		return fmt.Errorf("%s: %s", t.Path, err)
+	}
+	if t.state.Sensors == nil {
+		t.state.Sensors = make(map[string]int)
+	}
+	return nil
+}
+
+func (t *PointsTracker) saveLocked() error {
+	if t.Path == "" {
+		return nil
+	}
+	b, err := json.Marshal(t.state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.Path), filepath.Base(t.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), t.Path)
+}
+
+// rollLocked starts a new day or month's spending when the date has moved on
+func (t *PointsTracker) rollLocked() {
+	now := t.now().UTC()
+	if day := now.Format("2006-01-02"); t.state.Day != day {
+		t.state.Day = day
+		t.state.DaySpent = 0
+	}
+	if month := now.Format("2006-01"); t.state.Month != month {
+		t.state.Month = month
+		t.state.MonthSpent = 0
+	}
+}
+
+// queryKey identifies which sensors a request selects: its endpoint and parameters, except
+// the fields asked for and modified_since
+func queryKey(endpoint string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "fields" && k != "modified_since" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	parts := []string{endpoint}
+	for _, k := range keys {
+		parts = append(parts, k+"="+params[k])
+	}
+	return strings.Join(parts, "&")
+}
+
+// Sensors is how many sensors the same query last returned, and false if it hasn't been seen
+func (t *PointsTracker) Sensors(endpoint string, params map[string]string) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, ok := t.state.Sensors[queryKey(endpoint, params)]
+	return n, ok
+}
+
+// Estimate predicts the points a /sensors request will be charged, from the fields it asks
+// for and how many sensors the same query last returned. ok is false for a new query, which
+// is estimated from its show_only, or at DefaultSensors.
+func (t *PointsTracker) Estimate(endpoint string, params map[string]string) (int, bool) {
+	var fields []string
+	if f := params["fields"]; f != "" {
+		fields = strings.Split(f, ",")
+	}
+	n, ok := t.Sensors(endpoint, params)
+	if !ok {
+		n = t.DefaultSensors
+		if show_only := params["show_only"]; show_only != "" {
+			n = len(strings.Split(show_only, ","))
+		}
+	}
+	return EstimatePoints(fields, n), ok
+}
+
+// Allow returns a *BudgetError if estimate more points would go over a budget, counting the
+// requests already allowed and not yet Released. Otherwise it reserves estimate until Release,
+// so requests made at the same time can't both spend the last of a budget. A spent budget
+// refuses every request, even one estimated at 0.
+func (t *PointsTracker) Allow(estimate int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked()
+	now := t.now().UTC()
+	over := func(budget int, spent int) bool {
+		return budget > 0 && (spent >= budget || spent+t.reserved+estimate > budget)
+	}
+	if over(t.DailyBudget, t.state.DaySpent) {
+		return &BudgetError{Period: "day", Budget: t.DailyBudget, Spent: t.state.DaySpent, Estimate: estimate,
+			Reset: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)}
+	}
+	if over(t.MonthlyBudget, t.state.MonthSpent) {
+		return &BudgetError{Period: "month", Budget: t.MonthlyBudget, Spent: t.state.MonthSpent, Estimate: estimate,
+			Reset: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)}
+	}
+	if !t.balanceAt.IsZero() && (t.balance <= 0 || t.reserved+estimate > t.balance) {
+		reset := t.balanceAt.Add(t.BalanceRefresh)
+		if !reset.After(now) {
+			reset = now.Add(t.BalanceRefresh)
+		}
+		return &BudgetError{Period: "balance", Budget: t.balanceReported, Spent: t.balanceReported - t.balance,
+			Estimate: estimate, Reset: reset}
+	}
+	t.reserved += estimate
+	return nil
+}
+
+// Release gives back what Allow reserved for a request, once it's been Recorded or has failed
+func (t *PointsTracker) Release(estimate int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reserved -= estimate
+}
+
+// Record adds the points a request was charged. sensors is how many it returned, remembered
+// for the query's next estimate, or -1 for a request that isn't a query of sensors.
+func (t *PointsTracker) Record(endpoint string, params map[string]string, sensors int, points int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked()
+	t.state.DaySpent += points
+	t.state.MonthSpent += points
+	t.balance -= points
+	// modified_since only returns the sensors that changed, which says nothing about the query
+	if _, ok := params["modified_since"]; sensors >= 0 && !ok {
+		t.state.Sensors[queryKey(endpoint, params)] = sensors
+	}
+	return t.saveLocked()
+}
+
+// Spent is the points recorded today and this month
+func (t *PointsTracker) Spent() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked()
+	return t.state.DaySpent, t.state.MonthSpent
+}
+
+// reservePoints refuses a /sensors request that the client's tracker, if any, estimates would
+// go over a budget, and otherwise reserves the estimate, for releasePoints once the request is done
+func (c Client) reservePoints(endpoint string, params map[string]string) (int, error) {
+	if c.Points == nil {
+		return 0, nil
+	}
+	estimate, _ := c.Points.Estimate(endpoint, params)
+	return estimate, c.Points.Allow(estimate)
+}
+
+// releasePoints gives back what reservePoints reserved
+func (c Client) releasePoints(estimate int) {
+	if c.Points != nil {
+		c.Points.Release(estimate)
+	}
+}
+
+// recordPoints records a request's points with the client's tracker, key pool and OnPoints,
+// if any
+func (c Client) recordPoints(resp *http.Response, endpoint string, params map[string]string, sensors int, points int) {
+	if c.OnPoints != nil {
+		c.OnPoints(points)
+	}
+	if c.Keys != nil {
+		c.Keys.Record(resp.Request.Header.Get("X-API-Key"), points)
+	}
+	if c.Points == nil {
+		return
+	}
+	if err := c.Points.Record(endpoint, params, sensors, points); err != nil && c.Points.OnError != nil {
+		c.Points.OnError(err)
+	}
+}
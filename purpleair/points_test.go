@@ -0,0 +1,150 @@
+package purpleair
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimatePoints(t *testing.T) {
+	assert.Equal(t, 30, EstimatePoints([]string{"pm2.5", "humidity"}, 10))
+	assert.Equal(t, 20, EstimatePoints([]string{"sensor_index", "pm2.5"}, 10))
+	assert.Equal(t, 0, EstimatePoints([]string{"pm2.5"}, 0))
+}
+
+func TestPointsTrackerBudget(t *testing.T) {
+	tr := NewPointsTracker("")
+	tr.DailyBudget = 1000
+	tr.MonthlyBudget = 1500
+	now := time.Date(2022, 9, 26, 12, 0, 0, 0, time.UTC)
+	tr.now = func() time.Time { return now }
+	params := map[string]string{"fields": "pm2.5,humidity", "nwlng": "1"}
+
+	estimate, ok := tr.Estimate("/sensors", params)
+	assert.False(t, ok)
+	assert.Equal(t, 300, estimate) // DefaultSensors
+	assert.Nil(t, tr.Allow(estimate))
+	assert.Nil(t, tr.Record("/sensors", params, 300, 900))
+	tr.Release(estimate)
+	// the next request of the same query, for one field, is expected to return the same sensors
+	estimate, ok = tr.Estimate("/sensors", map[string]string{"fields": "pm2.5", "nwlng": "1"})
+	assert.True(t, ok)
+	assert.Equal(t, 600, estimate)
+	var e *BudgetError
+	assert.True(t, errors.As(tr.Allow(estimate), &e))
+	assert.Equal(t, "day", e.Period)
+	assert.Equal(t, 900, e.Spent)
+	assert.Equal(t, time.Date(2022, 9, 27, 0, 0, 0, 0, time.UTC), e.Reset)
+	assert.Nil(t, tr.Allow(100))
+	tr.Release(100)
+
+	// modified_since returns only the sensors that changed
+	assert.Nil(t, tr.Record("/sensors", map[string]string{"nwlng": "1", "modified_since": "1"}, 2, 100))
+	n, _ := tr.Sensors("/sensors", params)
+	assert.Equal(t, 300, n)
+	assert.NotNil(t, tr.Allow(0))
+
+	// a new day starts the daily budget again, but not the monthly one
+	now = now.Add(12 * time.Hour)
+	assert.True(t, errors.As(tr.Allow(600), &e))
+	assert.Equal(t, "month", e.Period)
+	assert.Equal(t, time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC), e.Reset)
+	assert.Nil(t, tr.Allow(500))
+	day, month := tr.Spent()
+	assert.Equal(t, 0, day)
+	assert.Equal(t, 1000, month)
+}
+
+func TestPointsTrackerBalance(t *testing.T) {
+	tr := NewPointsTracker("")
+	now := time.Date(2022, 9, 26, 12, 0, 0, 0, time.UTC)
+	tr.now = func() time.Time { return now }
+	_, ok := tr.Balance()
+	assert.False(t, ok)
+	assert.Nil(t, tr.Allow(1000000))
+	tr.Release(1000000)
+
+	tr.SetBalance(1000)
+	assert.Nil(t, tr.Record("/sensors", map[string]string{"nwlng": "1"}, 100, 900))
+	balance, ok := tr.Balance()
+	assert.True(t, ok)
+	assert.Equal(t, 100, balance)
+	assert.Nil(t, tr.Allow(100))
+	tr.Release(100)
+	var e *BudgetError
+	assert.True(t, errors.As(tr.Allow(200), &e))
+	assert.Equal(t, "balance", e.Period)
+	assert.Equal(t, 900, e.Spent)
+	assert.Equal(t, now.Add(time.Hour), e.Reset)
+	assert.ErrorContains(t, e, "the 100 points left")
+
+	// the api's balance replaces what's been counted down
+	now = now.Add(2 * time.Hour)
+	tr.SetBalance(50000)
+	assert.Nil(t, tr.Allow(200))
+}
+
+func TestPointsTrackerReserve(t *testing.T) {
+	tr := NewPointsTracker("")
+	tr.DailyBudget = 1000
+	// a query that hasn't been seen is estimated from its show_only, or DefaultSensors
+	estimate, ok := tr.Estimate("/sensors", map[string]string{"fields": "pm2.5", "show_only": "1,2,3"})
+	assert.False(t, ok)
+	assert.Equal(t, 6, estimate)
+	tr.DefaultSensors = 300
+	estimate, _ = tr.Estimate("/sensors", map[string]string{"fields": "pm2.5", "nwlng": "1"})
+	assert.Equal(t, 600, estimate)
+
+	// what's been allowed counts until it's released, so both can't spend the same points
+	assert.Nil(t, tr.Allow(estimate))
+	var e *BudgetError
+	assert.True(t, errors.As(tr.Allow(estimate), &e))
+	tr.Release(estimate)
+	assert.Nil(t, tr.Allow(estimate))
+}
+
+func TestPointsTrackerLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "points.json")
+	tr := NewPointsTracker(path)
+	assert.Nil(t, tr.Load())
+	assert.Nil(t, tr.Record("/sensors", map[string]string{"fields": "pm2.5", "show_only": "1,2"}, 2, 4))
+
+	tr = NewPointsTracker(path)
+	assert.Nil(t, tr.Load())
+	day, month := tr.Spent()
+	assert.Equal(t, 4, day)
+	assert.Equal(t, 4, month)
+	estimate, ok := tr.Estimate("/sensors", map[string]string{"fields": "pm2.5,humidity", "show_only": "1,2"})
+	assert.True(t, ok)
+	assert.Equal(t, 6, estimate)
+}
+
+func TestClientPointsBudget(t *testing.T) {
+	rows := [][]float32{
+		{1, 0.5, 0.5, 5.0},
+		{2, 1.5, 1.5, 6.0},
+	}
+	requests := 0
+	server := setupBoundsServer(t, rows, &requests)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	c.Points = NewPointsTracker("")
+	c.Points.DailyBudget = 10
+	c.Points.DefaultSensors = 2
+	b, _ := NewBounds(0, 2, 2, 0)
+
+	_, err := c.GetSensorsInBounds(map[string]string{"fields": "pm2.5"}, b)
+	assert.Nil(t, err)
+	day, _ := c.Points.Spent()
+	assert.Equal(t, 8, day)
+	// the 2 sensors for pm2.5 and sensor_index would go over, so the request isn't made
+	_, err = c.GetSensorsInBounds(map[string]string{"fields": "pm2.5"}, b)
+	var e *BudgetError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, 4, e.Estimate)
+	assert.Equal(t, 1, requests)
+}
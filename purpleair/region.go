@@ -0,0 +1,187 @@
+package purpleair
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Region is where to watch sensors: a box, or the polygons of an area inside their envelope
+type Region struct {
+	Bounds *Bounds
+	Area   *Area // nil for just the box
+}
+
+// NewCircleRegion is the box inscribed in a circle of range_km around lat_deg,lon_deg
+func NewCircleRegion(lat_deg float64, lon_deg float64, range_km float64) (*Region, error) {
+	lat_rad := Radians(lat_deg)
+	lon_rad := Radians(lon_deg)
+	nwlat, nwlng := PointFromLocRadial(lat_rad, lon_rad, range_km, Radians(-45))
+	selat, selng := PointFromLocRadial(lat_rad, lon_rad, range_km, Radians(135))
+	b, err := NewBounds(
+		float32(Degrees(nwlng)),
+		float32(Degrees(nwlat)),
+		float32(Degrees(selng)),
+		float32(Degrees(selat)))
+	if err != nil {
+		return nil, err
+	}
+	return &Region{Bounds: b}, nil
+}
+
+// NewAreaRegion is the sensors inside area
+func NewAreaRegion(area *Area) (*Region, error) {
+	b, err := area.Envelope()
+	if err != nil {
+		return nil, err
+	}
+	return &Region{Bounds: b, Area: area}, nil
+}
+
+// Contains reports whether a point in degrees is in the region
+func (r Region) Contains(lat float64, lon float64) bool {
+	if r.Area != nil {
+		return r.Area.Contains(lat, lon)
+	}
+	return r.Bounds.Contains(float32(lat), float32(lon))
+}
+
+// approximate size of the box in square km, to compare what querying boxes costs
+func (b Bounds) areaKm2() float64 {
+	h_rad := Radians(float64(b.nwlat) - float64(b.selat))
+	w_rad := Radians(b.lngWidth()) * math.Cos(Radians((float64(b.nwlat)+float64(b.selat))/2))
+	return distanceRadiansToKm(h_rad) * distanceRadiansToKm(w_rad)
+}
+
+// RegionGroup is regions that are polled with one query of Bounds. Regions are indexes into
+// the regions given to GroupRegions.
+type RegionGroup struct {
+	Bounds  *Bounds
+	Regions []int
+}
+
+// GroupRegions groups regions whose boxes overlap, so the sensors they share are only
+// requested, and paid for, once. Boxes are only grouped when the box around both is no bigger
+// than the two boxes, so a query of it doesn't cost more points than querying each.
+func GroupRegions(regions []*Region) []RegionGroup {
+	groups := make([]RegionGroup, len(regions))
+	for i, r := range regions {
+		groups[i] = RegionGroup{Bounds: r.Bounds, Regions: []int{i}}
+	}
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(groups) && !merged; i++ {
+			for j := i + 1; j < len(groups); j++ {
+				a, b := groups[i], groups[j]
+				if !a.Bounds.Intersects(*b.Bounds) {
+					continue
+				}
+				u := a.Bounds.Union(*b.Bounds)
+				if u.areaKm2() > a.Bounds.areaKm2()+b.Bounds.areaKm2() {
+					continue
+				}
+				members := append(append([]int{}, a.Regions...), b.Regions...)
+				sort.Ints(members)
+				groups[i] = RegionGroup{Bounds: u, Regions: members}
+				groups = append(groups[:j], groups[j+1:]...)
+				merged = true
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// withLocationFields adds latitude and longitude to a copy of params' fields if they are missing
+func withLocationFields(params map[string]string) map[string]string {
+	fields := []string{}
+	if f, ok := params["fields"]; ok && f != "" {
+		fields = strings.Split(f, ",")
+	}
+	for _, f := range []string{"latitude", "longitude"} {
+		if !contains(fields, f) {
+			fields = append(fields, f)
+		}
+	}
+	p := map[string]string{}
+	for k, v := range params {
+		p[k] = v
+	}
+	p["fields"] = strings.Join(fields, ",")
+	return p
+}
+
+// GetSensorsInRegions queries the box around all the regions once, and returns the sensors in
+// each region. A sensor in more than one region is in each of their responses. A single box
+// is queried as it is, without adding latitude and longitude to the fields.
+func (c Client) GetSensorsInRegions(params map[string]string, regions []*Region) ([]*Sensors, error) {
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("no regions to query")
+	}
+	if len(regions) == 1 {
+		var s *Sensors
+		var err error
+		if regions[0].Area != nil {
+			s, err = c.GetSensorsInArea(params, regions[0].Area)
+		} else {
+			s, err = c.GetSensorsInBounds(params, regions[0].Bounds)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*Sensors{s}, nil
+	}
+	b := regions[0].Bounds
+	for _, r := range regions[1:] {
+		b = b.Union(*r.Bounds)
+	}
+	s, err := c.GetSensorsInBounds(withLocationFields(params), b)
+	if err != nil {
+		return nil, err
+	}
+	ilat := s.FieldIndex("latitude")
+	ilon := s.FieldIndex("longitude")
+	if ilat < 0 || ilon < 0 {
+		return nil, fmt.Errorf("response is missing latitude or longitude")
+	}
+	responses := make([]*Sensors, len(regions))
+	for i, r := range regions {
+		inside := *s
+		inside.Data = make([][]*float32, 0, len(s.Data))
+		for _, d := range s.Data {
+			if d[ilat] == nil || d[ilon] == nil {
+				continue
+			}
+			if r.Contains(float64(*d[ilat]), float64(*d[ilon])) {
+				inside.Data = append(inside.Data, d)
+			}
+		}
+		responses[i] = &inside
+	}
+	return responses, nil
+}
+
+// SensorsQueries are the /sensors parameters GetSensorsInRegions requests for regions, one for
+// each request, so what they cost can be estimated without making them
+func SensorsQueries(params map[string]string, regions []*Region) []map[string]string {
+	if len(regions) == 0 {
+		return nil
+	}
+	b := regions[0].Bounds
+	for _, r := range regions[1:] {
+		b = b.Union(*r.Bounds)
+	}
+	if len(regions) > 1 || regions[0].Area != nil {
+		params = withLocationFields(params)
+	}
+	var queries []map[string]string
+	for _, sb := range b.Split() {
+		p := map[string]string{}
+		for k, v := range params {
+			p[k] = v
+		}
+		queries = append(queries, AppendBoundsParams(p, sb))
+	}
+	return queries
+}
@@ -0,0 +1,71 @@
+package purpleair
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupRegions(t *testing.T) {
+	home, _ := NewCircleRegion(33.3333, -96.6666, 3)
+	school, _ := NewCircleRegion(33.3400, -96.6600, 3)           // mostly the same sensors as home
+	office, _ := NewCircleRegion(32.7767, -96.7970, 3)           // ~60km away
+	corner, _ := NewCircleRegion(33.3333+0.05, -96.6666+0.06, 3) // only the corners touch home
+	groups := GroupRegions([]*Region{home, office, school, corner})
+	assert.Equal(t, 3, len(groups))
+	assert.Equal(t, []int{0, 2}, groups[0].Regions)
+	assert.Equal(t, home.Bounds.Union(*school.Bounds), groups[0].Bounds)
+	assert.Equal(t, []int{1}, groups[1].Regions)
+	assert.Equal(t, []int{3}, groups[2].Regions)
+}
+
+func TestGetSensorsInRegions(t *testing.T) {
+	rows := [][]float32{
+		{1, 0.5, 0.5, 5.0},
+		{2, 1.5, 1.5, 6.0},
+		{3, 2.5, 2.5, 7.0},
+		{4, 1.8, 0.1, 8.0},
+	}
+	requests := 0
+	server := setupBoundsServer(t, rows, &requests)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	a, _ := NewBounds(0, 2, 2, 0)
+	b, _ := NewBounds(1, 3, 3, 1)
+	triangle, _ := ParseGeoJSONArea([]byte(`{"type": "Polygon", "coordinates": [[[0, 0], [2, 0], [0, 2], [0, 0]]]}`))
+	area, _ := NewAreaRegion(triangle)
+	responses, err := c.GetSensorsInRegions(map[string]string{"fields": "pm2.5"}, []*Region{{Bounds: a}, {Bounds: b}, area})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requests)
+	indexes := func(s *Sensors) []float32 {
+		var idx []float32
+		for _, d := range s.Data {
+			idx = append(idx, *d[0])
+		}
+		return idx
+	}
+	assert.Equal(t, []float32{1, 2, 4}, indexes(responses[0]))
+	assert.Equal(t, []float32{2, 3}, indexes(responses[1]))
+	assert.Equal(t, []float32{1, 4}, indexes(responses[2])) // 2 is in the envelope, not the triangle
+	// each response is charged for every row of the one query
+	assert.Equal(t, 16, responses[0].Points())
+	assert.Equal(t, 16, responses[2].Points())
+
+	responses, err = c.GetSensorsInRegions(map[string]string{"fields": "pm2.5"}, []*Region{{Bounds: b}})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, []float32{2, 3}, indexes(responses[0]))
+}
+
+func TestSensorsQueries(t *testing.T) {
+	a, _ := NewBounds(0, 2, 2, 0)
+	b, _ := NewBounds(1, 3, 3, 1)
+	queries := SensorsQueries(map[string]string{"fields": "pm2.5"}, []*Region{{Bounds: a}})
+	assert.Equal(t, []map[string]string{{"fields": "pm2.5", "nwlng": "0.000000", "nwlat": "2.000000", "selng": "2.000000", "selat": "0.000000"}}, queries)
+	queries = SensorsQueries(map[string]string{"fields": "pm2.5"}, []*Region{{Bounds: a}, {Bounds: b}})
+	assert.Equal(t, []map[string]string{{"fields": "pm2.5,latitude,longitude", "nwlng": "0.000000", "nwlat": "3.000000", "selng": "3.000000", "selat": "0.000000"}}, queries)
+	// a box across the antimeridian is two requests
+	c, _ := NewBounds(179, 1, -179, 0)
+	assert.Equal(t, 2, len(SensorsQueries(map[string]string{"fields": "pm2.5"}, []*Region{{Bounds: c}})))
+}
@@ -0,0 +1,84 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// SensorSample is one sensor's measurements at the time the sensor reported them. It replaces
+// Sample, which keeps the sensor index as a float field and has only the response-wide timestamp.
+type SensorSample struct {
+	SensorIndex int                `json:"sensor_index"`
+	Time        time.Time          `json:"time"`
+	Tags        map[string]string  `json:"tags,omitempty"`
+	Fields      map[string]float64 `json:"fields"`
+}
+
+func NewSensorSample(sensor_index int, t time.Time) *SensorSample {
+	return &SensorSample{
+		SensorIndex: sensor_index,
+		Time:        t,
+		Tags:        make(map[string]string),
+		Fields:      make(map[string]float64),
+	}
+}
+
+// SensorsToSensorSamples converts each row of a /sensors response into a SensorSample. The sample
+// time is the sensor's last_seen if it was requested, otherwise the response's data_time_stamp.
+// Null values are left out of Fields, and rows without a sensor_index are skipped.
+func SensorsToSensorSamples(s *Sensors) []SensorSample {
+	idx := s.FieldIndex("sensor_index")
+	iseen := s.FieldIndex("last_seen")
+	samples := make([]SensorSample, 0, len(s.Data))
+	for _, d := range s.Data {
+		if idx < 0 || idx >= len(d) || d[idx] == nil {
+			continue
+		}
+		sensor_index := int(math.Round(float64(*d[idx])))
+		t := time.Unix(int64(s.DataTimeStamp), 0).UTC()
+		if ts, ok := s.LastSeen(sensor_index); ok {
+			t = time.Unix(int64(ts), 0).UTC()
+		}
+		sample := NewSensorSample(sensor_index, t)
+		for j, v := range d {
+			if v != nil && j != idx && j != iseen && j < len(s.Fields) {
+				sample.Fields[s.Fields[j]] = float64(*v)
+			}
+		}
+		samples = append(samples, *sample)
+	}
+	return samples
+}
+
+// SampleToSensorSample converts the older Sample type
+func SampleToSensorSample(s Sample) SensorSample {
+	sample := NewSensorSample(int(math.Round(float64(s.Sampledata["sensor_index"]))), time.Unix(int64(s.Timestamp), 0).UTC())
+	for k, v := range s.Sampledata {
+		if k != "sensor_index" {
+			sample.Fields[k] = float64(v)
+		}
+	}
+	for k, v := range s.Tags {
+		sample.Tags[k] = v
+	}
+	return *sample
+}
+
+func SensorSamplesJson(samples []SensorSample) ([]byte, error) {
+	return json.Marshal(samples)
+}
+
+// MetricName makes a valid metric name from a PurpleAir field, e.g. pm2.5_alt becomes
+// purpleair_pm2_5_alt
+func MetricName(field string) string {
+	b := []byte("purpleair_")
+	for _, c := range []byte(field) {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			b = append(b, c)
+		} else {
+			b = append(b, '_')
+		}
+	}
+	return string(b)
+}
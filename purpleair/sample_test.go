@@ -0,0 +1,79 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSensorsToSensorSamples(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	s, err := c.GetSensors(map[string]string{"fields": "humidity,temperature,voc,pm1.0,pm2.5,pm10.0"})
+	assert.Nil(t, err)
+
+	samples := SensorsToSensorSamples(s)
+	assert.Equal(t, 4, len(samples))
+	assert.Equal(t, 127397, samples[3].SensorIndex)
+	assert.Equal(t, time.Unix(1664170800, 0).UTC(), samples[3].Time)
+	assert.Equal(t, 5, len(samples[3].Fields)) // no sensor_index, no null voc
+	assert.InDelta(t, 51.0, samples[3].Fields["humidity"], 0.01)
+}
+
+func TestSensorsToSensorSamplesLastSeen(t *testing.T) {
+	var s Sensors
+	err := json.Unmarshal([]byte(`{
+		"data_time_stamp" : 1664170800,
+		"fields" : ["sensor_index","last_seen","pm2.5"],
+		"data" : [
+			[15111,1664170743,8.7],
+			[20755,null,9.9]
+		]
+	}`), &s)
+	assert.Nil(t, err)
+	ts, ok := s.LastSeen(15111)
+	assert.True(t, ok)
+	assert.Equal(t, uint(1664170743), ts)
+
+	samples := SensorsToSensorSamples(&s)
+	assert.Equal(t, 2, len(samples))
+	assert.Equal(t, time.Unix(1664170743, 0).UTC(), samples[0].Time) // exact, not rounded through float32
+	assert.Equal(t, time.Unix(1664170800, 0).UTC(), samples[1].Time)
+	assert.Equal(t, map[string]float64{"pm2.5": float64(float32(8.7))}, samples[0].Fields)
+}
+
+func TestSampleToSensorSample(t *testing.T) {
+	s := Sample{
+		Timestamp:  1664170800,
+		Sampledata: map[string]float32{"sensor_index": 15111, "pm2.5": 8.5},
+		Tags:       map[string]string{"name": "Porch"},
+	}
+	ss := SampleToSensorSample(s)
+	assert.Equal(t, 15111, ss.SensorIndex)
+	assert.Equal(t, time.Unix(1664170800, 0).UTC(), ss.Time)
+	assert.Equal(t, map[string]float64{"pm2.5": 8.5}, ss.Fields)
+	assert.Equal(t, "Porch", ss.Tags["name"])
+}
+
+func TestSensorSamplesJson(t *testing.T) {
+	ss := NewSensorSample(15111, time.Unix(1664170800, 0).UTC())
+	ss.Fields["pm2.5"] = 8.5
+	ss.Tags["name"] = "Porch"
+	js, err := SensorSamplesJson([]SensorSample{*ss})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{
+		"sensor_index": 15111,
+		"time": "2022-09-26T05:40:00Z",
+		"tags": {"name": "Porch"},
+		"fields": {"pm2.5": 8.5}
+	}]`, string(js))
+}
+
+func TestMetricName(t *testing.T) {
+	assert.Equal(t, "purpleair_pm2_5_alt", MetricName("pm2.5_alt"))
+	assert.Equal(t, "purpleair_humidity", MetricName("humidity"))
+}
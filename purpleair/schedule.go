@@ -0,0 +1,152 @@
+package purpleair
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Scheduler times /sensors polls to just after PurpleAir refreshes its data, which it does
+// about every 2 minutes. It learns the refresh period from the steps in data_time_stamp, backs
+// off while the data doesn't change, and spreads PointsPerDay over the rest of the UTC day.
+// With Interval set it polls every Interval instead, still within PointsPerDay. After
+// SetBalance it also paces the organization's remaining points over the rest of the UTC month.
+type Scheduler struct {
+	Interval     time.Duration // fixed time between polls, 0 to follow the data
+	Period       time.Duration // expected time between refreshes, learned from responses
+	Margin       time.Duration // how long after an expected refresh to poll
+	MinInterval  time.Duration
+	MaxInterval  time.Duration // longest wait while backing off, unless the budget needs longer
+	PointsPerDay int           // 0 for no limit
+
+	mu         sync.Mutex
+	now        func() time.Time
+	data       time.Time // the latest data_time_stamp
+	unchanged  int       // polls in a row that got the same data
+	day        string
+	spent      int
+	cost       int // the points of the latest poll
+	balance    int // remaining points less what's been spent since SetBalance
+	hasBalance bool
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		Period:      2 * time.Minute,
+		Margin:      10 * time.Second,
+		MinInterval: 30 * time.Second,
+		MaxInterval: 10 * time.Minute,
+		now:         time.Now,
+	}
+}
+
+// learn folds the time between two refreshes into Period. Steps that span several refreshes,
+// because a poll was late or failed, count as that many periods, and long outages are ignored.
+func (s *Scheduler) learn(step time.Duration) {
+	if step <= 0 || step > 30*time.Minute {
+		return
+	}
+	n := math.Round(float64(step) / float64(s.Period))
+	if n < 1 {
+		n = 1
+	}
+	s.Period = (3*s.Period + time.Duration(float64(step)/n)) / 4
+	if s.Period < s.MinInterval {
+		s.Period = s.MinInterval
+	}
+}
+
+// SetBalance records the points the organization has left, as the api reports them, which
+// are shared between the days left in the month
+func (s *Scheduler) SetBalance(remaining int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.balance = remaining
+	s.hasBalance = true
+}
+
+// Next records a response and the points it cost, and returns how long to wait before the next
+// poll. Waits are worked out on the api's clock, from the response's time_stamp, so a skewed
+// local clock doesn't matter.
+func (s *Scheduler) Next(r *Sensors, points int) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if today := s.now().UTC().Format("2006-01-02"); s.day != today {
+		s.day = today
+		s.spent = 0
+	}
+	s.spent += points
+	s.cost = points
+	s.balance -= points
+
+	data := time.Unix(int64(r.DataTimeStamp), 0)
+	var wait time.Duration
+	if s.data.IsZero() || data.After(s.data) {
+		if !s.data.IsZero() {
+			s.learn(data.Sub(s.data))
+		}
+		s.data = data
+		s.unchanged = 0
+		wait = data.Add(s.Period + s.Margin).Sub(time.Unix(int64(r.TimeStamp), 0))
+	} else {
+		// the refresh is late, so try again sooner at first, then less often
+		s.unchanged++
+		wait = s.MinInterval << (s.unchanged - 1)
+	}
+	if wait < s.MinInterval {
+		wait = s.MinInterval
+	}
+	if wait > s.MaxInterval || s.unchanged > 16 {
+		wait = s.MaxInterval
+	}
+	if s.Interval > 0 {
+		wait = s.Interval
+	}
+	if budget := s.budgetWait(points); budget > wait {
+		wait = budget
+	}
+	return wait
+}
+
+// budgetWait is the least wait that keeps polls costing points within PointsPerDay and the
+// balance's share of each day: what's left of the day shared between the polls that are left,
+// or until tomorrow if there are none
+func (s *Scheduler) budgetWait(points int) time.Duration {
+	if (s.PointsPerDay <= 0 && !s.hasBalance) || points <= 0 {
+		return 0
+	}
+	now := s.now().UTC()
+	budget := s.PointsPerDay - s.spent
+	if s.hasBalance {
+		// the balance at the start of the day, shared by today and the rest of the month
+		days := time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day() - now.Day() + 1
+		share := (s.balance+s.spent)/days - s.spent
+		if s.PointsPerDay <= 0 || share < budget {
+			budget = share
+		}
+	}
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	left := tomorrow.Sub(now)
+	polls := budget / points
+	if polls < 1 {
+		return left
+	}
+	return left / time.Duration(polls)
+}
+
+// Cost is the points the latest poll cost, 0 before the first
+func (s *Scheduler) Cost() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cost
+}
+
+// Spent is the points recorded today
+func (s *Scheduler) Spent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.day != s.now().UTC().Format("2006-01-02") {
+		return 0
+	}
+	return s.spent
+}
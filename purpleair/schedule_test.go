@@ -0,0 +1,92 @@
+package purpleair
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func response(data_time_stamp uint, time_stamp uint) *Sensors {
+	return &Sensors{TimeStamp: time_stamp, DataTimeStamp: data_time_stamp}
+}
+
+func TestSchedulerFollowsData(t *testing.T) {
+	s := NewScheduler()
+	s.now = func() time.Time { return time.Unix(1664200000, 0) }
+	// polls just after the next refresh is expected
+	assert.Equal(t, 125*time.Second, s.Next(response(1664200000, 1664200005), 100))
+	assert.Equal(t, 125*time.Second, s.Next(response(1664200120, 1664200125), 100))
+	// a missed refresh counts as two periods, not one long one
+	assert.Equal(t, 125*time.Second, s.Next(response(1664200360, 1664200365), 100))
+	assert.Equal(t, 2*time.Minute, s.Period)
+	// refreshes every 100s pull the period towards 100s
+	for i := uint(1); i <= 20; i++ {
+		s.Next(response(1664200360+100*i, 1664200365+100*i), 100)
+	}
+	assert.InDelta(t, float64(100*time.Second), float64(s.Period), float64(time.Second))
+	assert.Equal(t, 2300, s.Spent())
+}
+
+func TestSchedulerBacksOff(t *testing.T) {
+	s := NewScheduler()
+	s.now = func() time.Time { return time.Unix(1664200000, 0) }
+	s.Next(response(1664200000, 1664200005), 100)
+	assert.Equal(t, 30*time.Second, s.Next(response(1664200000, 1664200130), 100))
+	assert.Equal(t, time.Minute, s.Next(response(1664200000, 1664200160), 100))
+	assert.Equal(t, 2*time.Minute, s.Next(response(1664200000, 1664200220), 100))
+	for i := 0; i < 30; i++ {
+		s.Next(response(1664200000, 1664200220), 100)
+	}
+	assert.Equal(t, 10*time.Minute, s.Next(response(1664200000, 1664200220), 100))
+	// fresh data goes back to following the refreshes, a late one to the least wait
+	assert.Equal(t, 30*time.Second, s.Next(response(1664200600, 1664202000), 100))
+}
+
+func TestSchedulerBudget(t *testing.T) {
+	s := NewScheduler()
+	s.PointsPerDay = 1000
+	now := time.Date(2022, 9, 26, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	// 900 points left is 9 polls in the 12 hours left
+	assert.Equal(t, 80*time.Minute, s.Next(response(1664200000, 1664200005), 100))
+	s.Next(response(1664200120, 1664200125), 850)
+	assert.Equal(t, 12*time.Hour, s.Next(response(1664200240, 1664200245), 100))
+	assert.Equal(t, 1050, s.Spent())
+	// a new day starts the budget again
+	now = now.Add(12 * time.Hour)
+	assert.Equal(t, 0, s.Spent())
+
+	s = NewScheduler()
+	s.Interval = 5 * time.Minute
+	s.now = func() time.Time { return now }
+	assert.Equal(t, 5*time.Minute, s.Next(response(1664200000, 1664200005), 100))
+
+	// the balance's share of the 5 days left in the month caps the day's points, with or
+	// without PointsPerDay
+	now = time.Date(2022, 9, 26, 12, 0, 0, 0, time.UTC)
+	s = NewScheduler()
+	s.now = func() time.Time { return now }
+	s.SetBalance(5000)
+	assert.Equal(t, 80*time.Minute, s.Next(response(1664200000, 1664200005), 100))
+	s.PointsPerDay = 100000
+	assert.Equal(t, 90*time.Minute, s.Next(response(1664200120, 1664200125), 100))
+	s.SetBalance(50)
+	assert.Equal(t, 12*time.Hour, s.Next(response(1664200240, 1664200245), 100))
+}
+
+func TestSchedulerBalanceDailySpend(t *testing.T) {
+	// a balance of 30000 on the first of a 30 day month is 1000 points a day
+	now := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+	s := NewScheduler()
+	s.now = func() time.Time { return now }
+	s.SetBalance(30000)
+	spent := 0
+	for ts := uint(1661990400); now.Day() == 1; ts += 120 {
+		wait := s.Next(response(ts, ts+5), 100)
+		spent = s.Spent()
+		now = now.Add(wait)
+	}
+	assert.LessOrEqual(t, spent, 1000)
+	assert.GreaterOrEqual(t, spent, 900)
+}
@@ -0,0 +1,129 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// SensorRowFunc is called for each row of a streamed /sensors response. header holds everything
+// decoded before the data array, including Fields. row and the values it points to are reused for
+// the next row, so copy anything that needs to outlive the call. Returning an error stops the stream.
+type SensorRowFunc func(header *Sensors, row []*float32) error
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("expected %s got %v", want, tok)
+	}
+	return nil
+}
+
+func decodeRows(dec *json.Decoder, header *Sensors, fn SensorRowFunc) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	values := make([]float32, len(header.Fields))
+	row := make([]*float32, len(header.Fields))
+	for dec.More() {
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		row = row[:0]
+		for i := 0; dec.More(); i++ {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if i >= len(values) {
+				return fmt.Errorf("row has more values than fields")
+			}
+			switch v := tok.(type) {
+			case nil:
+				row = append(row, nil)
+			case float64:
+				values[i] = float32(v)
+				row = append(row, &values[i])
+			default:
+				return fmt.Errorf("unexpected value %v in data", tok)
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+		if err := fn(header, row); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+// DecodeSensorsStream decodes a /sensors response from r one row at a time instead of
+// holding the whole data array in memory. The returned Sensors has every field but Data.
+// PurpleAir sends fields before data; a response with data first is an error.
+func DecodeSensorsStream(r io.Reader, fn SensorRowFunc) (*Sensors, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, fmt.Errorf("can not decode response JSON: %s", err)
+	}
+	var header Sensors
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("can not decode response JSON: %s", err)
+		}
+		key := tok.(string)
+		if key == "data" {
+			if header.Fields == nil {
+				return nil, fmt.Errorf("data received before fields")
+			}
+			if err := decodeRows(dec, &header, fn); err != nil {
+				return nil, fmt.Errorf("can not decode response data: %s", err)
+			}
+			continue
+		}
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("can not decode response JSON: %s", err)
+		}
+		kv, _ := json.Marshal(map[string]json.RawMessage{key: v})
+		if err := json.Unmarshal(kv, &header); err != nil {
+			return nil, fmt.Errorf("can not unmarshal %s: %s", key, err)
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, fmt.Errorf("can not decode response JSON: %s", err)
+	}
+	return &header, nil
+}
+
+// StreamSensors is GetSensors for large queries, passing each row to fn as it is read
+// rather than buffering the whole response
+func (c Client) StreamSensors(params map[string]string, fn SensorRowFunc) (*Sensors, error) {
+	err := validateParams(params)
+	if err != nil {
+		return nil, err
+	}
+	estimate, err := c.reservePoints("/sensors", params)
+	if err != nil {
+		return nil, err
+	}
+	defer c.releasePoints(estimate)
+	resp, err := c.get("/sensors", params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rows := 0
+	header, err := DecodeSensorsStream(resp.Body, func(h *Sensors, row []*float32) error {
+		rows++
+		return fn(h, row)
+	})
+	if header != nil {
+		c.recordPoints(resp, "/sensors", params, rows, len(header.Fields)*rows)
+	}
+	return header, err
+}
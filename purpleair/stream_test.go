@@ -0,0 +1,145 @@
+package purpleair
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamSensors(t *testing.T) {
+	server := setupServer(t)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	params := map[string]string{
+		"fields":        "humidity,temperature,voc,pm1.0,pm2.5,pm10.0",
+		"location_type": "0",
+	}
+
+	var rows [][]*float32
+	h, err := c.StreamSensors(params, func(header *Sensors, row []*float32) error {
+		assert.Equal(t, 7, len(header.Fields))
+		r := make([]*float32, len(row))
+		for i, v := range row {
+			if v != nil {
+				v := *v
+				r[i] = &v
+			}
+		}
+		rows = append(rows, r)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "V1.0.11-0.0.40", h.APIVersion)
+	assert.Equal(t, uint(1664170800), h.DataTimeStamp)
+	assert.Equal(t, uint(604800), h.MaxAge)
+	assert.Nil(t, h.Data)
+
+	// the same rows as the buffered path
+	s, err := c.GetSensors(params)
+	assert.Nil(t, err)
+	assert.Equal(t, s.Data, rows)
+}
+
+func TestDecodeSensorsStreamStop(t *testing.T) {
+	body := `{"fields": ["sensor_index"], "data": [[1], [2], [3]]}`
+	n := 0
+	_, err := DecodeSensorsStream(strings.NewReader(body), func(header *Sensors, row []*float32) error {
+		n++
+		if n == 2 {
+			return fmt.Errorf("stop")
+		}
+		return nil
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestDecodeSensorsStreamBad(t *testing.T) {
+	noop := func(header *Sensors, row []*float32) error { return nil }
+	_, err := DecodeSensorsStream(strings.NewReader(`{"data": [[1]], "fields": ["sensor_index"]}`), noop)
+	assert.NotNil(t, err)
+	_, err = DecodeSensorsStream(strings.NewReader(`{"fields": ["sensor_index"], "data": [[1, 2]]}`), noop)
+	assert.NotNil(t, err)
+	_, err = DecodeSensorsStream(strings.NewReader(`{"fields": ["sensor_index"], "data": [["a"]]}`), noop)
+	assert.NotNil(t, err)
+	_, err = DecodeSensorsStream(strings.NewReader(`[]`), noop)
+	assert.NotNil(t, err)
+}
+
+func largeSensorsBody(rows int, extra ...string) []byte {
+	fields := append([]string{"sensor_index"}, validPm25Fields()...)
+	fields = append(fields, validPm25AverageFields()...)
+	fields = append(fields, extra...)
+	var b bytes.Buffer
+	b.WriteString(`{"api_version":"V1.0.11-0.0.40","time_stamp":1664170828,"data_time_stamp":1664170800,"fields":`)
+	f, _ := json.Marshal(fields)
+	b.Write(f)
+	b.WriteString(`,"data":[`)
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(fmt.Sprintf("[%d", i))
+		for j := 1; j < len(fields); j++ {
+			if j%7 == 0 {
+				b.WriteString(",null")
+			} else {
+				b.WriteString(fmt.Sprintf(",%d.%d", i%500, j))
+			}
+		}
+		b.WriteString("]")
+	}
+	b.WriteString("]}")
+	return b.Bytes()
+}
+
+func BenchmarkUnmarshalSensors(b *testing.B) {
+	body := largeSensorsBody(10000)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var s Sensors
+		if err := json.Unmarshal(body, &s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// last_seen is in the default fields
+func BenchmarkUnmarshalSensorsLastSeen(b *testing.B) {
+	body := largeSensorsBody(10000, "last_seen")
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var s Sensors
+		if err := json.Unmarshal(body, &s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeSensorsStream(b *testing.B) {
+	body := largeSensorsBody(10000)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var sum float32
+		_, err := DecodeSensorsStream(bytes.NewReader(body), func(header *Sensors, row []*float32) error {
+			if row[1] != nil {
+				sum += *row[1]
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
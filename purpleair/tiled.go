@@ -0,0 +1,94 @@
+package purpleair
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type TileOptions struct {
+	Columns int // tiles west to east
+	Rows    int // tiles north to south
+	Workers int // maximum requests in flight
+}
+
+func DefaultTileOptions() TileOptions {
+	return TileOptions{
+		Columns: 4,
+		Rows:    4,
+		Workers: 4,
+	}
+}
+
+type TileError struct {
+	Tile *Bounds
+	Err  error
+}
+
+func (e TileError) Error() string {
+	return fmt.Sprintf("tile %s: %s", e.Tile.UrlString(), e.Err)
+}
+
+// TilesError is returned by GetSensorsTiled when some of the tiles failed
+type TilesError struct {
+	Failed []TileError
+	Total  int
+}
+
+func (e *TilesError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		msgs[i] = f.Error()
+	}
+	return fmt.Sprintf("%d of %d tiles failed: %s", len(e.Failed), e.Total, strings.Join(msgs, "; "))
+}
+
+// GetSensorsTiled splits b into tiles and queries them concurrently, which keeps each
+// response small enough to return within the client timeout. Sensors on the edge of two tiles
+// are only returned once. If some tiles fail the sensors from the rest are returned along with
+// a *TilesError; if every tile fails no sensors are returned.
+func (c Client) GetSensorsTiled(params map[string]string, b *Bounds, opts TileOptions) (*Sensors, error) {
+	if opts.Workers < 1 {
+		return nil, fmt.Errorf("workers must be at least 1")
+	}
+	tiles, err := b.Tile(opts.Columns, opts.Rows)
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]*Sensors, len(tiles))
+	errs := make([]error, len(tiles))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				responses[i], errs[i] = c.GetSensorsInBounds(params, tiles[i])
+			}
+		}()
+	}
+	for i := range tiles {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	tilesErr := &TilesError{Total: len(tiles)}
+	ok := make([]*Sensors, 0, len(tiles))
+	for i := range tiles {
+		if errs[i] != nil {
+			tilesErr.Failed = append(tilesErr.Failed, TileError{Tile: tiles[i], Err: errs[i]})
+		} else {
+			ok = append(ok, responses[i])
+		}
+	}
+	if len(ok) == 0 {
+		return nil, tilesErr
+	}
+	s := mergeSensors(ok)
+	if len(tilesErr.Failed) > 0 {
+		return s, tilesErr
+	}
+	return s, nil
+}
@@ -0,0 +1,116 @@
+package purpleair
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// like setupBoundsServer but safe for concurrent requests, and fails any tile whose west edge is failLng
+func setupTiledServer(t *testing.T, rows [][]float32, failLng string, inFlightMax *int32) *httptest.Server {
+	var inFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(inFlightMax)
+			if n <= max || atomic.CompareAndSwapInt32(inFlightMax, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		q := r.URL.Query()
+		if q.Get("nwlng") == failLng {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`upstream timeout`))
+			return
+		}
+		nwlng, _ := strconv.ParseFloat(q.Get("nwlng"), 32)
+		nwlat, _ := strconv.ParseFloat(q.Get("nwlat"), 32)
+		selng, _ := strconv.ParseFloat(q.Get("selng"), 32)
+		selat, _ := strconv.ParseFloat(q.Get("selat"), 32)
+		data := [][]*float32{}
+		for _, row := range rows {
+			row := row
+			lat, lon := float64(row[1]), float64(row[2])
+			if lat <= nwlat && lat >= selat && lon >= nwlng && lon <= selng {
+				data = append(data, []*float32{&row[0], &row[1], &row[2]})
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(Sensors{
+			DataTimeStamp: 1664170800,
+			Fields:        []string{"sensor_index", "latitude", "longitude"},
+			Data:          data,
+		})
+	}))
+	return server
+}
+
+func TestGetSensorsTiled(t *testing.T) {
+	rows := [][]float32{
+		{1, 2, 2},
+		{2, 8, 8},
+		{3, 5, 5}, // on the corner of all four tiles
+		{4, 2, 12},
+		{5, 8, 18},
+	}
+	var inFlightMax int32
+	server := setupTiledServer(t, rows, "", &inFlightMax)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	b, _ := NewBounds(0, 10, 20, 0)
+
+	opts := TileOptions{Columns: 4, Rows: 2, Workers: 3}
+	s, err := c.GetSensorsTiled(map[string]string{"fields": "latitude,longitude"}, b, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(s.Data))
+	assert.LessOrEqual(t, atomic.LoadInt32(&inFlightMax), int32(3))
+}
+
+func TestGetSensorsTiledPartial(t *testing.T) {
+	rows := [][]float32{
+		{1, 2, 2},
+		{4, 2, 12},
+	}
+	var inFlightMax int32
+	server := setupTiledServer(t, rows, "10.000000", &inFlightMax)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	b, _ := NewBounds(0, 10, 20, 0)
+
+	s, err := c.GetSensorsTiled(map[string]string{"fields": "latitude,longitude"}, b, TileOptions{Columns: 2, Rows: 1, Workers: 2})
+	assert.NotNil(t, s)
+	assert.Equal(t, 1, len(s.Data))
+	var tilesErr *TilesError
+	assert.True(t, errors.As(err, &tilesErr))
+	assert.Equal(t, 2, tilesErr.Total)
+	assert.Equal(t, 1, len(tilesErr.Failed))
+	assert.Equal(t, float32(10), tilesErr.Failed[0].Tile.NwLng())
+}
+
+func TestGetSensorsTiledAllFailed(t *testing.T) {
+	var inFlightMax int32
+	server := setupTiledServer(t, nil, "0.000000", &inFlightMax)
+	defer server.Close()
+	c, _ := NewClient("test-read-key", "")
+	c.BaseURL = server.URL
+	b, _ := NewBounds(0, 10, 20, 0)
+
+	s, err := c.GetSensorsTiled(map[string]string{}, b, TileOptions{Columns: 1, Rows: 1, Workers: 1})
+	assert.Nil(t, s)
+	assert.NotNil(t, err)
+
+	_, err = c.GetSensorsTiled(map[string]string{}, b, TileOptions{Columns: 1, Rows: 1, Workers: 0})
+	assert.NotNil(t, err)
+}
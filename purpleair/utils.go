@@ -65,3 +65,11 @@ func PointFromLocRadial(lat1 float64, lon1 float64, distance_km float64, bearing
 	lon := math.Mod(lon1+dlon+math.Pi, 2.*math.Pi) - math.Pi
 	return lat, lon
 }
+
+// GreatCircleDistanceKm is the distance between two points given in radians
+// http://edwilliams.org/avform147.htm
+func GreatCircleDistanceKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	d_rad := 2 * math.Asin(math.Sqrt(math.Pow(math.Sin((lat1-lat2)/2), 2)+
+		math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin((lon1-lon2)/2), 2)))
+	return distanceRadiansToKm(d_rad)
+}
@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+type SinkError struct {
+	Sink string
+	Err  error
+}
+
+func (e SinkError) Error() string {
+	return fmt.Sprintf("sink %s: %s", e.Sink, e.Err)
+}
+
+func (e SinkError) Unwrap() error {
+	return e.Err
+}
+
+// FanoutError is returned when one or more sinks of a Fanout failed
+type FanoutError struct {
+	Failed []SinkError
+}
+
+func (e *FanoutError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		msgs[i] = f.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type namedSink struct {
+	name   string
+	sink   Sink
+	opened bool
+}
+
+// Fanout is a Sink that passes every call on to each of its sinks. A failing sink doesn't
+// stop the others; its error goes to OnError, if set, and is returned in a *FanoutError. A
+// sink that failed to open is opened again before each Write and Flush, and skipped until it
+// opens.
+type Fanout struct {
+	OnError func(name string, err error)
+
+	sinks []*namedSink
+}
+
+func NewFanout() *Fanout {
+	return &Fanout{}
+}
+
+func (f *Fanout) Add(name string, s Sink) {
+	f.sinks = append(f.sinks, &namedSink{name: name, sink: s})
+}
+
+func (f *Fanout) Len() int {
+	return len(f.sinks)
+}
+
+func (f *Fanout) each(call func(ns *namedSink) error) error {
+	var fe FanoutError
+	for _, ns := range f.sinks {
+		if err := call(ns); err != nil {
+			if f.OnError != nil {
+				f.OnError(ns.name, err)
+			}
+			fe.Failed = append(fe.Failed, SinkError{Sink: ns.name, Err: err})
+		}
+	}
+	if len(fe.Failed) > 0 {
+		return &fe
+	}
+	return nil
+}
+
+func (f *Fanout) Open() error {
+	return f.each(func(ns *namedSink) error { return ns.open() })
+}
+
+func (ns *namedSink) open() error {
+	if err := ns.sink.Open(); err != nil {
+		return err
+	}
+	ns.opened = true
+	return nil
+}
+
+// opened calls call with each sink that's open, opening it first if it failed to before
+func (f *Fanout) opened(call func(s Sink) error) error {
+	return f.each(func(ns *namedSink) error {
+		if !ns.opened {
+			if err := ns.open(); err != nil {
+				return err
+			}
+		}
+		return call(ns.sink)
+	})
+}
+
+func (f *Fanout) Write(samples []purpleair.SensorSample) error {
+	return f.opened(func(s Sink) error { return s.Write(samples) })
+}
+
+func (f *Fanout) Flush() error {
+	return f.opened(func(s Sink) error { return s.Flush() })
+}
+
+// Close closes the sinks that opened
+func (f *Fanout) Close() error {
+	return f.each(func(ns *namedSink) error {
+		if !ns.opened {
+			return nil
+		}
+		ns.opened = false
+		return ns.sink.Close()
+	})
+}
@@ -0,0 +1,289 @@
+package sink
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+func init() {
+	Register("influx", newInfluxSinkFromOptions)
+}
+
+// InfluxWriter batches lines and writes them when BatchSize lines are buffered or FlushInterval
+// after the first line of a batch, whichever comes first. A batch that fails to write is dropped
+// and its error returned from the next Write or Flush.
+type InfluxWriter struct {
+	BatchSize     int
+	FlushInterval time.Duration
+	Precision     Precision
+
+	write   func(lines []byte) error
+	mu      sync.Mutex
+	buf     []byte
+	n       int
+	timer   *time.Timer
+	lastErr error
+}
+
+func NewInfluxWriter(write func(lines []byte) error) *InfluxWriter {
+	return &InfluxWriter{
+		BatchSize:     5000,
+		FlushInterval: 10 * time.Second,
+		Precision:     Nanoseconds,
+		write:         write,
+	}
+}
+
+func (w *InfluxWriter) WritePoint(p Point) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b, err := AppendLinePrecision(w.buf, p, w.Precision)
+	if err != nil {
+		w.buf = b
+		return err
+	}
+	w.buf = append(b, '\n')
+	w.n++
+	if w.n >= w.BatchSize {
+		err = w.flushLocked()
+	} else if w.n == 1 && w.FlushInterval > 0 {
+		w.timer = time.AfterFunc(w.FlushInterval, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			if err := w.flushLocked(); err != nil {
+				w.lastErr = err
+			}
+		})
+	}
+	if err == nil && w.lastErr != nil {
+		err = w.lastErr
+		w.lastErr = nil
+	}
+	return err
+}
+
+func (w *InfluxWriter) flushLocked() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.n == 0 {
+		return nil
+	}
+	lines := w.buf
+	w.buf = nil
+	w.n = 0
+	return w.write(lines)
+}
+
+func (w *InfluxWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.flushLocked()
+	if err == nil && w.lastErr != nil {
+		err = w.lastErr
+	}
+	w.lastErr = nil
+	return err
+}
+
+func newInfluxWriterForClient(influx *InfluxDbClient) *InfluxWriter {
+	w := NewInfluxWriter(influx.WriteLines)
+	w.Precision = influx.config.Precision
+	return w
+}
+
+// InfluxSink writes samples to InfluxDB in line protocol. Each sample is tagged with its
+// sensor_index, its own tags, and the sink's static tags.
+type InfluxSink struct {
+	Precision int // decimal places kept for float fields
+
+	writer      *InfluxWriter
+	measurement string
+	tags        map[string]string
+}
+
+func NewInfluxSink(influx *InfluxDbClient, measurement string, tags map[string]string) *InfluxSink {
+	return &InfluxSink{
+		Precision:   2,
+		writer:      newInfluxWriterForClient(influx),
+		measurement: measurement,
+		tags:        tags,
+	}
+}
+
+func parseBoolOption(options map[string]string, key string) (bool, error) {
+	v, ok := options[key]
+	if !ok || v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("option %s: %s", key, err)
+	}
+	return b, nil
+}
+
+// InfluxConfigFromOptions reads the influx sink options:
+//
+//	url, or host and port (default 8086) for http
+//	version 1 (default) or 2
+//	1.x: database, rp, username, password, auth=basic (default) or query
+//	2.x and 3.x: org, bucket, token
+//	precision ns (default), us, ms or s
+//	timeout, tls_skip_verify, tls_ca, tls_cert, tls_key
+func InfluxConfigFromOptions(options map[string]string) (InfluxConfig, error) {
+	cfg := InfluxConfig{
+		URL:             options["url"],
+		Database:        options["database"],
+		RetentionPolicy: options["rp"],
+		Username:        options["username"],
+		Password:        options["password"],
+		Token:           options["token"],
+		Org:             options["org"],
+		Bucket:          options["bucket"],
+		TLSCAFile:       options["tls_ca"],
+		TLSCertFile:     options["tls_cert"],
+		TLSKeyFile:      options["tls_key"],
+	}
+	if cfg.URL == "" {
+		host := options["host"]
+		if host == "" {
+			return cfg, fmt.Errorf("influx sink requires a url or host option")
+		}
+		port := options["port"]
+		if port == "" {
+			port = "8086"
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			return cfg, fmt.Errorf("influx sink option port: %s", err)
+		}
+		cfg.URL = fmt.Sprintf("http://%s:%s", host, port)
+	}
+	if v := options["version"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("influx sink option version: %s", err)
+		}
+		cfg.Version = n
+	}
+	switch options["auth"] {
+	case "", "basic":
+	case "query":
+		cfg.QueryAuth = true
+	default:
+		return cfg, fmt.Errorf("influx sink option auth must be basic or query")
+	}
+	p, err := ParsePrecision(options["precision"])
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Precision = p
+	if v := options["timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("influx sink option timeout: %s", err)
+		}
+		cfg.Timeout = d
+	}
+	cfg.TLSSkipVerify, err = parseBoolOption(options, "tls_skip_verify")
+	return cfg, err
+}
+
+// options are those of InfluxConfigFromOptions plus measurement (default purpleair),
+// batch_size, flush_interval, and tag.<name>=<value> for static tags
+func newInfluxSinkFromOptions(options map[string]string) (Sink, error) {
+	cfg, err := InfluxConfigFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	influx, err := NewInfluxClientFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	measurement := options["measurement"]
+	if measurement == "" {
+		measurement = "purpleair"
+	}
+	tags := make(map[string]string)
+	for k, v := range options {
+		if strings.HasPrefix(k, "tag.") {
+			tags[strings.TrimPrefix(k, "tag.")] = v
+		}
+	}
+	s := NewInfluxSink(influx, measurement, tags)
+	if v, ok := options["batch_size"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("influx sink option batch_size must be a positive integer")
+		}
+		s.writer.BatchSize = n
+	}
+	if v, ok := options["flush_interval"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("influx sink option flush_interval: %s", err)
+		}
+		s.writer.FlushInterval = d
+	}
+	return s, nil
+}
+
+// SampleToPoint converts a sample to a line protocol point, adding AQI fields computed from
+// pm2.5_alt and pm2.5. AQI stays a float field so it matches databases written by earlier versions.
+func SampleToPoint(measurement string, tags map[string]string, precision int, s purpleair.SensorSample) Point {
+	p := Point{
+		Measurement: measurement,
+		Tags:        make(map[string]string, len(tags)+len(s.Tags)+1),
+		Fields:      make(map[string]interface{}, len(s.Fields)+2),
+		Time:        s.Time,
+	}
+	for k, v := range tags {
+		p.Tags[k] = v
+	}
+	for k, v := range s.Tags {
+		p.Tags[k] = v
+	}
+	p.Tags["sensor_index"] = strconv.Itoa(s.SensorIndex)
+	scale := math.Pow(10, float64(precision))
+	for k, v := range s.Fields {
+		p.Fields[k] = math.Round(v*scale) / scale
+	}
+	if v, ok := s.Fields["pm2.5_alt"]; ok {
+		p.Fields["aqi_epa"] = float64(purpleair.Pm25ToAqi(v))
+	}
+	if v, ok := s.Fields["pm2.5"]; ok {
+		p.Fields["aqi_raw"] = float64(purpleair.Pm25ToAqi(v))
+	}
+	return p
+}
+
+func (s *InfluxSink) Open() error {
+	return nil
+}
+
+func (s *InfluxSink) Write(samples []purpleair.SensorSample) error {
+	for _, sample := range samples {
+		if len(sample.Fields) == 0 {
+			continue
+		}
+		if err := s.writer.WritePoint(SampleToPoint(s.measurement, s.tags, s.Precision, sample)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *InfluxSink) Flush() error {
+	return s.writer.Flush()
+}
+
+func (s *InfluxSink) Close() error {
+	return s.writer.Flush()
+}
@@ -0,0 +1,205 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// InfluxConfig selects the write API and credentials. Version 1 writes to /write with the
+// database, using basic auth or u/p query parameters if a username is set. Version 2 writes to
+// /api/v2/write with an org, bucket and token, which InfluxDB 3.x also accepts.
+type InfluxConfig struct {
+	URL       string // scheme://host:port
+	Version   int    // 1 or 2
+	Precision Precision
+	Timeout   time.Duration
+
+	// 1.x
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+	QueryAuth       bool // send credentials as u and p query parameters instead of basic auth
+
+	// 2.x and 3.x
+	Token  string
+	Org    string
+	Bucket string
+
+	TLSSkipVerify bool
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+}
+
+type InfluxDbClient struct {
+	config     InfluxConfig
+	HTTPClient *http.Client
+}
+
+// NewInfluxClient makes a 1.x client for http://host:port
+func NewInfluxClient(host string, port int, database string, username string, password string) *InfluxDbClient {
+	c, _ := NewInfluxClientFromConfig(InfluxConfig{
+		URL:      fmt.Sprintf("http://%s:%d", host, port),
+		Version:  1,
+		Database: database,
+		Username: username,
+		Password: password,
+	})
+	return c
+}
+
+func tlsConfig(cfg InfluxConfig) (*tls.Config, error) {
+	t := &tls.Config{InsecureSkipVerify: cfg.TLSSkipVerify}
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCAFile)
+		}
+		t.RootCAs = pool
+	}
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		t.Certificates = []tls.Certificate{cert}
+	}
+	return t, nil
+}
+
+func NewInfluxClientFromConfig(cfg InfluxConfig) (*InfluxDbClient, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("influx url %q must be http(s)://host:port", cfg.URL)
+	}
+	switch cfg.Version {
+	case 0, 1:
+		cfg.Version = 1
+		if cfg.Database == "" {
+			return nil, fmt.Errorf("influx 1.x requires a database")
+		}
+	case 2:
+		if cfg.Org == "" || cfg.Bucket == "" || cfg.Token == "" {
+			return nil, fmt.Errorf("influx 2.x requires an org, bucket and token")
+		}
+	default:
+		return nil, fmt.Errorf("unknown influx version %d, expected 1 or 2", cfg.Version)
+	}
+	if cfg.Precision == "" {
+		cfg.Precision = Nanoseconds
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second * 2
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if u.Scheme == "https" {
+		t, err := tlsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = t
+	}
+	return &InfluxDbClient{
+		config: cfg,
+		HTTPClient: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: transport,
+		},
+	}, nil
+}
+
+// InfluxError is a write that influx answered with a 4xx or 5xx
+type InfluxError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e InfluxError) Error() string {
+	return fmt.Sprintf("influx returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Permanent is true for a batch influx rejected, such as a field type conflict
+func (e InfluxError) Permanent() bool {
+	return permanentStatus(e.StatusCode)
+}
+
+// 1.x errors are {"error": "..."} and 2.x errors are {"code": "...", "message": "..."}
+func newInfluxError(resp *http.Response) InfluxError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var e struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	msg := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &e) == nil {
+		if e.Error != "" {
+			msg = e.Error
+		} else if e.Message != "" {
+			msg = e.Message
+		}
+	}
+	return InfluxError{StatusCode: resp.StatusCode, Message: msg}
+}
+
+func (c *InfluxDbClient) writeRequest(lines []byte) (*http.Request, error) {
+	q := url.Values{}
+	q.Set("precision", string(c.config.Precision))
+	endpoint := "/write"
+	if c.config.Version == 2 {
+		endpoint = "/api/v2/write"
+		q.Set("org", c.config.Org)
+		q.Set("bucket", c.config.Bucket)
+	} else {
+		q.Set("db", c.config.Database)
+		if c.config.RetentionPolicy != "" {
+			q.Set("rp", c.config.RetentionPolicy)
+		}
+		if c.config.Username != "" && c.config.QueryAuth {
+			q.Set("u", c.config.Username)
+			q.Set("p", c.config.Password)
+		}
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(c.config.URL, "/")+endpoint+"?"+q.Encode(), bytes.NewReader(lines))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.config.Version == 2 {
+		req.Header.Set("Authorization", "Token "+c.config.Token)
+	} else if c.config.Username != "" && !c.config.QueryAuth {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	return req, nil
+}
+
+// WriteLines posts a batch of newline separated line protocol with timestamps in the
+// client's precision
+func (c *InfluxDbClient) WriteLines(lines []byte) error {
+	req, err := c.writeRequest(lines)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return newInfluxError(resp)
+	}
+	return nil
+}
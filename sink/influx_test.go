@@ -0,0 +1,218 @@
+package sink
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupInfluxServer(t *testing.T, status int, response string) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/write" || r.URL.Query().Get("db") != "purpleair" {
+			t.Errorf("unexpected write to %s", r.URL)
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, bodies...)
+	}
+}
+
+func influxOptions(server *httptest.Server) map[string]string {
+	host, port, _ := strings.Cut(strings.TrimPrefix(server.URL, "http://"), ":")
+	return map[string]string{"host": host, "port": port, "database": "purpleair", "tag.location": "home"}
+}
+
+func TestInfluxSink(t *testing.T) {
+	server, bodies := setupInfluxServer(t, http.StatusNoContent, "")
+	defer server.Close()
+
+	s, err := New("influx", influxOptions(server))
+	assert.Nil(t, err)
+	assert.Nil(t, s.Open())
+	assert.Nil(t, s.Write(testSamples()))
+	assert.Nil(t, s.Write(testSamples()))
+	assert.Equal(t, 0, len(bodies())) // batched until flushed
+	assert.Nil(t, s.Close())
+	assert.Equal(t, 1, len(bodies()))
+	line := "purpleair,location=home,name=Backyard\\,\\ North,sensor_index=15111 aqi_raw=36,humidity=43,pm2.5=8.7 1664170800000000000\n"
+	assert.Equal(t, line+line, bodies()[0])
+}
+
+func TestInfluxSinkBatchSize(t *testing.T) {
+	server, bodies := setupInfluxServer(t, http.StatusNoContent, "")
+	defer server.Close()
+	options := influxOptions(server)
+	options["batch_size"] = "2"
+	s, err := New("influx", options)
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, s.Write(testSamples()))
+	}
+	assert.Equal(t, 2, len(bodies()))
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, 3, len(bodies()))
+	assert.Equal(t, 1, strings.Count(bodies()[2], "\n"))
+}
+
+func TestInfluxSinkFlushInterval(t *testing.T) {
+	server, bodies := setupInfluxServer(t, http.StatusNoContent, "")
+	defer server.Close()
+	options := influxOptions(server)
+	options["flush_interval"] = "20ms"
+	s, err := New("influx", options)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Write(testSamples()))
+	assert.Eventually(t, func() bool { return len(bodies()) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestInfluxSinkErrors(t *testing.T) {
+	server, _ := setupInfluxServer(t, http.StatusBadRequest, `{"error":"field type conflict: input field \"aqi_raw\" is type integer, already exists as type float"}`)
+	defer server.Close()
+	s, err := New("influx", influxOptions(server))
+	assert.Nil(t, err)
+	assert.Nil(t, s.Write(testSamples()))
+	err = s.Flush()
+	var ie InfluxError
+	assert.True(t, errors.As(err, &ie))
+	assert.Equal(t, http.StatusBadRequest, ie.StatusCode)
+	assert.Contains(t, ie.Message, "field type conflict")
+
+	server2, _ := setupInfluxServer(t, http.StatusServiceUnavailable, "upstream unavailable")
+	defer server2.Close()
+	s, _ = New("influx", influxOptions(server2))
+	s.Write(testSamples())
+	err = s.Flush()
+	assert.True(t, errors.As(err, &ie))
+	assert.Equal(t, "upstream unavailable", ie.Message)
+}
+
+func TestInfluxSinkV2(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		assert.Equal(t, "/api/v2/write", r.URL.Path)
+		assert.Equal(t, "home", q.Get("org"))
+		assert.Equal(t, "purpleair", q.Get("bucket"))
+		assert.Equal(t, "s", q.Get("precision"))
+		assert.Equal(t, "Token secret-token", r.Header.Get("Authorization"))
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s, err := New("influx", map[string]string{
+		"url": server.URL, "version": "2", "org": "home", "bucket": "purpleair", "token": "secret-token", "precision": "s",
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Write(testSamples()))
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, "purpleair,name=Backyard\\,\\ North,sensor_index=15111 aqi_raw=36,humidity=43,pm2.5=8.7 1664170800\n", body)
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"code":"unauthorized","message":"unauthorized access"}`))
+	})
+	s.Write(testSamples())
+	var ie InfluxError
+	assert.True(t, errors.As(s.Flush(), &ie))
+	assert.Equal(t, "unauthorized access", ie.Message)
+}
+
+func TestInfluxSinkV1Auth(t *testing.T) {
+	var query, user, pass string
+	var basic bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		user, pass, basic = r.BasicAuth()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	options := map[string]string{"url": server.URL, "database": "purpleair", "rp": "month", "username": "writer", "password": "pw"}
+	s, err := New("influx", options)
+	assert.Nil(t, err)
+	s.Write(testSamples())
+	assert.Nil(t, s.Flush())
+	assert.True(t, basic)
+	assert.Equal(t, "writer", user)
+	assert.Equal(t, "pw", pass)
+	assert.Equal(t, "db=purpleair&precision=ns&rp=month", query)
+
+	options["auth"] = "query"
+	s, err = New("influx", options)
+	assert.Nil(t, err)
+	s.Write(testSamples())
+	assert.Nil(t, s.Flush())
+	assert.False(t, basic)
+	assert.Equal(t, "db=purpleair&p=pw&precision=ns&rp=month&u=writer", query)
+}
+
+func TestInfluxSinkTLS(t *testing.T) {
+	var writes int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writes++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	options := map[string]string{"url": server.URL, "database": "purpleair"}
+	s, err := New("influx", options)
+	assert.Nil(t, err)
+	s.Write(testSamples())
+	assert.NotNil(t, s.Flush()) // self signed certificate
+	assert.Equal(t, 0, writes)
+
+	options["tls_skip_verify"] = "true"
+	s, err = New("influx", options)
+	assert.Nil(t, err)
+	s.Write(testSamples())
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, 1, writes)
+}
+
+func TestInfluxConfigFromOptions(t *testing.T) {
+	cfg, err := InfluxConfigFromOptions(map[string]string{"host": "localhost", "database": "purpleair"})
+	assert.Nil(t, err)
+	assert.Equal(t, "http://localhost:8086", cfg.URL)
+	assert.Equal(t, Nanoseconds, cfg.Precision)
+
+	for _, options := range []map[string]string{
+		{},
+		{"host": "localhost", "port": "http"},
+		{"url": "http://localhost:8086", "version": "two"},
+		{"url": "http://localhost:8086", "auth": "digest"},
+		{"url": "http://localhost:8086", "precision": "m"},
+		{"url": "http://localhost:8086", "tls_skip_verify": "maybe"},
+	} {
+		_, err := InfluxConfigFromOptions(options)
+		assert.NotNil(t, err, options)
+	}
+
+	for _, options := range []map[string]string{
+		{"url": "localhost:8086", "database": "purpleair"},
+		{"url": "http://localhost:8086"},
+		{"url": "http://localhost:8086", "version": "2", "org": "home", "bucket": "purpleair"},
+		{"url": "http://localhost:8086", "version": "3", "database": "purpleair"},
+	} {
+		_, err := New("influx", options)
+		assert.NotNil(t, err, options)
+	}
+}
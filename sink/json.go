@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+func init() {
+	Register("json", newJSONSinkFromOptions)
+}
+
+// JSONSink writes each batch of samples as a JSON array
+type JSONSink struct {
+	w      io.Writer
+	pretty bool
+}
+
+func NewJSONSink(w io.Writer, pretty bool) *JSONSink {
+	return &JSONSink{w: w, pretty: pretty}
+}
+
+// options: pretty (default true)
+func newJSONSinkFromOptions(options map[string]string) (Sink, error) {
+	pretty := true
+	if v, ok := options["pretty"]; ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("json sink option pretty: %s", err)
+		}
+		pretty = b
+	}
+	return NewJSONSink(os.Stdout, pretty), nil
+}
+
+func (s *JSONSink) Open() error {
+	return nil
+}
+
+func (s *JSONSink) Write(samples []purpleair.SensorSample) error {
+	js, err := purpleair.SensorSamplesJson(samples)
+	if err != nil {
+		return err
+	}
+	if s.pretty {
+		var prettyJSON bytes.Buffer
+		if err := json.Indent(&prettyJSON, js, "", "    "); err != nil {
+			return err
+		}
+		js = prettyJSON.Bytes()
+	}
+	_, err = fmt.Fprintln(s.w, string(js))
+	return err
+}
+
+func (s *JSONSink) Flush() error {
+	return nil
+}
+
+func (s *JSONSink) Close() error {
+	return nil
+}
@@ -0,0 +1,150 @@
+package sink
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point is one line of InfluxDB line protocol. Field values may be float64, float32, int,
+// int64, bool or string.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// Precision is the unit of line protocol timestamps, named as in the influx write APIs
+type Precision string
+
+const (
+	Nanoseconds  Precision = "ns"
+	Microseconds Precision = "us"
+	Milliseconds Precision = "ms"
+	Seconds      Precision = "s"
+)
+
+func ParsePrecision(s string) (Precision, error) {
+	switch Precision(s) {
+	case Nanoseconds, Microseconds, Milliseconds, Seconds:
+		return Precision(s), nil
+	case "":
+		return Nanoseconds, nil
+	}
+	return "", fmt.Errorf("unknown precision %s, expected ns, us, ms or s", s)
+}
+
+func (pr Precision) timestamp(t time.Time) int64 {
+	switch pr {
+	case Microseconds:
+		return t.UnixNano() / int64(time.Microsecond)
+	case Milliseconds:
+		return t.UnixNano() / int64(time.Millisecond)
+	case Seconds:
+		return t.Unix()
+	}
+	return t.UnixNano()
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func appendFieldValue(b []byte, v interface{}) ([]byte, bool, error) {
+	switch x := v.(type) {
+	case float64:
+		// influx rejects NaN and Inf, so the field is left out
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return b, false, nil
+		}
+		return strconv.AppendFloat(b, x, 'f', -1, 64), true, nil
+	case float32:
+		return appendFieldValue(b, float64(x))
+	case int:
+		return append(strconv.AppendInt(b, int64(x), 10), 'i'), true, nil
+	case int64:
+		return append(strconv.AppendInt(b, x, 10), 'i'), true, nil
+	case bool:
+		return strconv.AppendBool(b, x), true, nil
+	case string:
+		b = append(b, '"')
+		b = append(b, stringEscaper.Replace(x)...)
+		return append(b, '"'), true, nil
+	}
+	return b, false, fmt.Errorf("unsupported field type %T", v)
+}
+
+// AppendLine appends p to b as a line of line protocol with a nanosecond timestamp
+func AppendLine(b []byte, p Point) ([]byte, error) {
+	return AppendLinePrecision(b, p, Nanoseconds)
+}
+
+// AppendLinePrecision appends p to b as a line of line protocol, without the newline. Tags and
+// fields are sorted by key, which influx recommends for write performance, and empty tag values
+// are left out since influx rejects them. A point with no writable fields is an error.
+func AppendLinePrecision(b []byte, p Point, precision Precision) ([]byte, error) {
+	if p.Measurement == "" {
+		return b, fmt.Errorf("point has no measurement")
+	}
+	start := len(b)
+	b = append(b, measurementEscaper.Replace(p.Measurement)...)
+	for _, k := range sortedKeys(p.Tags) {
+		if p.Tags[k] == "" {
+			continue
+		}
+		b = append(b, ',')
+		b = append(b, keyEscaper.Replace(k)...)
+		b = append(b, '=')
+		b = append(b, keyEscaper.Replace(p.Tags[k])...)
+	}
+	n := 0
+	for _, k := range sortedKeys(p.Fields) {
+		mark := len(b)
+		if n == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, keyEscaper.Replace(k)...)
+		b = append(b, '=')
+		var ok bool
+		var err error
+		b, ok, err = appendFieldValue(b, p.Fields[k])
+		if err != nil {
+			return b[:start], fmt.Errorf("field %s: %s", k, err)
+		}
+		if !ok {
+			b = b[:mark]
+			continue
+		}
+		n++
+	}
+	if n == 0 {
+		return b[:start], fmt.Errorf("point has no fields")
+	}
+	if !p.Time.IsZero() {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, precision.timestamp(p.Time), 10)
+	}
+	return b, nil
+}
+
+func EncodeLine(p Point) (string, error) {
+	b, err := AppendLine(nil, p)
+	return string(b), err
+}
@@ -0,0 +1,42 @@
+package sink
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeLine(t *testing.T) {
+	line, err := EncodeLine(Point{
+		Measurement: "purple air",
+		Tags:        map[string]string{"name": "Backyard, North", "location": "home", "empty": "", "a=b": "c"},
+		Fields: map[string]interface{}{
+			"pm2.5":    8.7,
+			"aqi":      36,
+			"count":    int64(-2),
+			"online":   true,
+			"firmware": `7.02 "beta"`,
+			"bad":      math.NaN(),
+		},
+		Time: time.Unix(1664170800, 5),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, `purple\ air,a\=b=c,location=home,name=Backyard\,\ North aqi=36i,count=-2i,firmware="7.02 \"beta\"",online=true,pm2.5=8.7 1664170800000000005`, line)
+}
+
+func TestEncodeLineNoTime(t *testing.T) {
+	line, err := EncodeLine(Point{Measurement: "m", Fields: map[string]interface{}{"f": float32(1.5)}})
+	assert.Nil(t, err)
+	assert.Equal(t, "m f=1.5", line)
+}
+
+func TestEncodeLineBad(t *testing.T) {
+	_, err := EncodeLine(Point{Fields: map[string]interface{}{"f": 1.0}})
+	assert.NotNil(t, err)
+	_, err = EncodeLine(Point{Measurement: "m", Fields: map[string]interface{}{"f": math.Inf(1)}})
+	assert.NotNil(t, err)
+	_, err = EncodeLine(Point{Measurement: "m", Fields: map[string]interface{}{"f": []int{1}}})
+	assert.NotNil(t, err)
+}
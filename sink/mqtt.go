@@ -0,0 +1,269 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+func init() {
+	Register("mqtt", newMQTTSinkFromOptions)
+}
+
+// haSensor is how Home Assistant should show a field: its device class and unit
+type haSensor struct {
+	deviceClass string
+	unit        string
+}
+
+var haSensors = map[string]haSensor{
+	"pm1.0":       {"pm1", "µg/m³"},
+	"pm2.5":       {"pm25", "µg/m³"},
+	"pm2.5_alt":   {"pm25", "µg/m³"},
+	"pm10.0":      {"pm10", "µg/m³"},
+	"humidity":    {"humidity", "%"},
+	"temperature": {"temperature", "°F"},
+	"pressure":    {"atmospheric_pressure", "mbar"},
+	"aqi_epa":     {"aqi", ""},
+	"aqi_raw":     {"aqi", ""},
+}
+
+// MQTTOptions configures an MQTTSink. Topic may contain {sensor_index}.
+type MQTTOptions struct {
+	Broker            string // tcp://host:1883, ssl://host:8883 or ws://host/mqtt
+	ClientID          string
+	Username          string
+	Password          string
+	Topic             string
+	AvailabilityTopic string
+	QoS               byte
+	Retain            bool
+	Discovery         bool
+	DiscoveryPrefix   string
+	Timeout           time.Duration
+}
+
+func DefaultMQTTOptions() MQTTOptions {
+	hostname, _ := os.Hostname()
+	return MQTTOptions{
+		ClientID:          "purpleair-" + hostname,
+		Topic:             "purpleair/{sensor_index}/state",
+		AvailabilityTopic: "purpleair/status",
+		QoS:               1,
+		Retain:            true,
+		DiscoveryPrefix:   "homeassistant",
+		Timeout:           10 * time.Second,
+	}
+}
+
+// MQTTSink publishes each sample as a JSON object of its time and fields, AQI included, to the
+// sensor's topic. The availability topic is set to online on connect and offline on Close, and
+// to offline by the broker as the Last Will if the connection drops. With Discovery each field
+// of a sensor is announced to Home Assistant the first time it's published on a connection.
+type MQTTSink struct {
+	opts      MQTTOptions
+	client    mqtt.Client
+	mu        sync.Mutex // announced is reset from paho's goroutine on reconnect
+	announced map[string]bool
+}
+
+func NewMQTTSink(opts MQTTOptions) *MQTTSink {
+	return &MQTTSink{opts: opts, announced: make(map[string]bool)}
+}
+
+// options: broker, client_id, username, password, topic, availability_topic, qos, retain,
+// discovery, discovery_prefix and timeout
+func newMQTTSinkFromOptions(options map[string]string) (Sink, error) {
+	opts := DefaultMQTTOptions()
+	for option, v := range map[string]*string{
+		"broker":             &opts.Broker,
+		"client_id":          &opts.ClientID,
+		"username":           &opts.Username,
+		"password":           &opts.Password,
+		"topic":              &opts.Topic,
+		"availability_topic": &opts.AvailabilityTopic,
+		"discovery_prefix":   &opts.DiscoveryPrefix,
+	} {
+		if s, ok := options[option]; ok {
+			*v = s
+		}
+	}
+	if opts.Broker == "" {
+		return nil, fmt.Errorf("mqtt sink requires a broker option")
+	}
+	if v, ok := options["qos"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 2 {
+			return nil, fmt.Errorf("mqtt sink option qos must be 0, 1 or 2")
+		}
+		opts.QoS = byte(n)
+	}
+	for option, b := range map[string]*bool{"retain": &opts.Retain, "discovery": &opts.Discovery} {
+		if v, ok := options[option]; ok {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("mqtt sink option %s: %s", option, err)
+			}
+			*b = parsed
+		}
+	}
+	if v, ok := options["timeout"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt sink option timeout: %s", err)
+		}
+		opts.Timeout = d
+	}
+	return NewMQTTSink(opts), nil
+}
+
+func (s *MQTTSink) wait(t mqtt.Token) error {
+	if !t.WaitTimeout(s.opts.Timeout) {
+		return fmt.Errorf("mqtt timed out after %s", s.opts.Timeout)
+	}
+	return t.Error()
+}
+
+func (s *MQTTSink) Open() error {
+	co := mqtt.NewClientOptions().
+		AddBroker(s.opts.Broker).
+		SetClientID(s.opts.ClientID).
+		SetUsername(s.opts.Username).
+		SetPassword(s.opts.Password).
+		SetAutoReconnect(true).
+		SetConnectTimeout(s.opts.Timeout)
+	if s.opts.AvailabilityTopic != "" {
+		co.SetWill(s.opts.AvailabilityTopic, "offline", s.opts.QoS, true)
+	}
+	co.SetOnConnectHandler(func(c mqtt.Client) {
+		// a new session may be on a broker that lost its retained messages, so announce again
+		s.mu.Lock()
+		for key := range s.announced {
+			delete(s.announced, key)
+		}
+		s.mu.Unlock()
+		if s.opts.AvailabilityTopic != "" {
+			c.Publish(s.opts.AvailabilityTopic, s.opts.QoS, true, "online")
+		}
+	})
+	s.client = mqtt.NewClient(co)
+	return s.wait(s.client.Connect())
+}
+
+func (s *MQTTSink) topic(sensor_index int) string {
+	return strings.ReplaceAll(s.opts.Topic, "{sensor_index}", strconv.Itoa(sensor_index))
+}
+
+func objectID(field string) string {
+	return strings.NewReplacer(".", "_", "/", "_", " ", "_").Replace(field)
+}
+
+// discoveryConfig is the Home Assistant MQTT discovery config of one field of a sensor
+func (s *MQTTSink) discoveryConfig(sample purpleair.SensorSample, field string) ([]byte, error) {
+	id := fmt.Sprintf("purpleair_%d", sample.SensorIndex)
+	device := map[string]interface{}{
+		"identifiers":  []string{id},
+		"name":         fmt.Sprintf("PurpleAir %d", sample.SensorIndex),
+		"manufacturer": "PurpleAir",
+	}
+	if name := sample.Tags["name"]; name != "" {
+		device["name"] = name
+	}
+	if model := sample.Tags["model"]; model != "" {
+		device["model"] = model
+	}
+	if fw := sample.Tags["firmware_version"]; fw != "" {
+		device["sw_version"] = fw
+	}
+	config := map[string]interface{}{
+		"name":           field,
+		"unique_id":      id + "_" + objectID(field),
+		"object_id":      id + "_" + objectID(field),
+		"state_topic":    s.topic(sample.SensorIndex),
+		"value_template": fmt.Sprintf("{{ value_json[%q] }}", field),
+		"state_class":    "measurement",
+		"device":         device,
+	}
+	if ha, ok := haSensors[field]; ok {
+		config["device_class"] = ha.deviceClass
+		if ha.unit != "" {
+			config["unit_of_measurement"] = ha.unit
+		}
+	}
+	if s.opts.AvailabilityTopic != "" {
+		config["availability_topic"] = s.opts.AvailabilityTopic
+	}
+	return json.Marshal(config)
+}
+
+func (s *MQTTSink) Write(samples []purpleair.SensorSample) error {
+	for _, sample := range samples {
+		if len(sample.Fields) == 0 {
+			continue
+		}
+		state := make(map[string]interface{}, len(sample.Fields)+3)
+		for k, v := range sample.Fields {
+			state[k] = v
+		}
+		if v, ok := sample.Fields["pm2.5_alt"]; ok {
+			state["aqi_epa"] = purpleair.Pm25ToAqi(v)
+		}
+		if v, ok := sample.Fields["pm2.5"]; ok {
+			state["aqi_raw"] = purpleair.Pm25ToAqi(v)
+		}
+		if s.opts.Discovery {
+			for _, field := range sortedKeys(state) {
+				key := strconv.Itoa(sample.SensorIndex) + "/" + field
+				s.mu.Lock()
+				done := s.announced[key]
+				s.mu.Unlock()
+				if done {
+					continue
+				}
+				config, err := s.discoveryConfig(sample, field)
+				if err != nil {
+					return err
+				}
+				topic := fmt.Sprintf("%s/sensor/purpleair_%d/%s/config", s.opts.DiscoveryPrefix, sample.SensorIndex, objectID(field))
+				if err := s.wait(s.client.Publish(topic, s.opts.QoS, true, config)); err != nil {
+					return err
+				}
+				s.mu.Lock()
+				s.announced[key] = true
+				s.mu.Unlock()
+			}
+		}
+		state["time"] = sample.Time.UTC().Format(time.RFC3339)
+		payload, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := s.wait(s.client.Publish(s.topic(sample.SensorIndex), s.opts.QoS, s.opts.Retain, payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MQTTSink) Flush() error {
+	return nil
+}
+
+func (s *MQTTSink) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	var err error
+	if s.opts.AvailabilityTopic != "" && s.client.IsConnected() {
+		err = s.wait(s.client.Publish(s.opts.AvailabilityTopic, s.opts.QoS, true, "offline"))
+	}
+	s.client.Disconnect(250)
+	return err
+}
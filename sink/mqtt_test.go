@@ -0,0 +1,130 @@
+package sink
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/events"
+	"github.com/mochi-co/mqtt/server/listeners"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupBroker starts an embedded broker and returns its address and the CONNECT packets of
+// the clients that connected to it
+func setupBroker(t *testing.T) (*broker.Server, string, func() []events.Packet) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	var mu sync.Mutex
+	var connects []events.Packet
+	server := broker.NewServer(nil)
+	server.Events.OnConnect = func(cl events.Client, pk events.Packet) {
+		mu.Lock()
+		defer mu.Unlock()
+		connects = append(connects, pk)
+	}
+	assert.Nil(t, server.AddListener(listeners.NewTCP("t1", addr), nil))
+	assert.Nil(t, server.Serve())
+	t.Cleanup(func() { server.Close() })
+	return server, "tcp://" + addr, func() []events.Packet {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]events.Packet{}, connects...)
+	}
+}
+
+// subscribe collects the latest message on each topic, retained ones included
+func subscribe(t *testing.T, addr string) func() map[string]string {
+	var mu sync.Mutex
+	messages := make(map[string]string)
+	c := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(addr).SetClientID("test-subscriber"))
+	assert.True(t, c.Connect().WaitTimeout(5*time.Second))
+	token := c.Subscribe("#", 1, func(c mqtt.Client, m mqtt.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		messages[m.Topic()] = string(m.Payload())
+	})
+	assert.True(t, token.WaitTimeout(5*time.Second))
+	t.Cleanup(func() { c.Disconnect(0) })
+	return func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		copied := make(map[string]string, len(messages))
+		for k, v := range messages {
+			copied[k] = v
+		}
+		return copied
+	}
+}
+
+func TestMQTTSink(t *testing.T) {
+	_, addr, connects := setupBroker(t)
+
+	s, err := New("mqtt", map[string]string{"broker": addr, "client_id": "purpleair-test", "discovery": "true"})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Open())
+	samples := testSamples()
+	samples[0].Tags["model"] = "PA-II"
+	samples[0].Fields["pm2.5_alt"] = 6.1
+	assert.Nil(t, s.Write(samples))
+
+	// a subscriber that joins later gets the retained state, discovery and availability
+	messages := subscribe(t, addr)
+	assert.Eventually(t, func() bool { return len(messages()) == 7 }, 5*time.Second, 10*time.Millisecond)
+	m := messages()
+	assert.Equal(t, "online", m["purpleair/status"])
+	assert.JSONEq(t, `{"time":"2022-09-26T05:40:00Z","pm2.5":8.7,"pm2.5_alt":6.1,"humidity":43,"aqi_epa":25,"aqi_raw":36}`, m["purpleair/15111/state"])
+
+	var config map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(m["homeassistant/sensor/purpleair_15111/pm2_5_alt/config"]), &config))
+	assert.Equal(t, "pm25", config["device_class"])
+	assert.Equal(t, "µg/m³", config["unit_of_measurement"])
+	assert.Equal(t, "purpleair_15111_pm2_5_alt", config["unique_id"])
+	assert.Equal(t, "purpleair/15111/state", config["state_topic"])
+	assert.Equal(t, `{{ value_json["pm2.5_alt"] }}`, config["value_template"])
+	assert.Equal(t, "purpleair/status", config["availability_topic"])
+	assert.Equal(t, map[string]interface{}{
+		"identifiers":  []interface{}{"purpleair_15111"},
+		"name":         "Backyard, North",
+		"manufacturer": "PurpleAir",
+		"model":        "PA-II",
+	}, config["device"])
+	config = nil
+	assert.Nil(t, json.Unmarshal([]byte(m["homeassistant/sensor/purpleair_15111/aqi_epa/config"]), &config))
+	assert.Equal(t, "aqi", config["device_class"])
+	assert.NotContains(t, config, "unit_of_measurement")
+
+	// the broker is told to mark us offline if we drop
+	c := connects()[0]
+	assert.Equal(t, "purpleair-test", c.ClientIdentifier)
+	assert.True(t, c.WillFlag)
+	assert.True(t, c.WillRetain)
+	assert.Equal(t, "purpleair/status", c.WillTopic)
+	assert.Equal(t, "offline", string(c.WillMessage))
+
+	assert.Nil(t, s.Close())
+	assert.Eventually(t, func() bool { return messages()["purpleair/status"] == "offline" }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMQTTSinkOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{},
+		{"broker": "tcp://localhost:1883", "qos": "3"},
+		{"broker": "tcp://localhost:1883", "retain": "sometimes"},
+		{"broker": "tcp://localhost:1883", "timeout": "soon"},
+	} {
+		_, err := New("mqtt", options)
+		assert.NotNil(t, err, options)
+	}
+
+	s, err := New("mqtt", map[string]string{"broker": "tcp://127.0.0.1:1", "timeout": "1s"})
+	assert.Nil(t, err)
+	assert.NotNil(t, s.Open())
+}
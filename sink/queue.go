@@ -0,0 +1,220 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+type queueEntry struct {
+	seq  uint64
+	size int64
+}
+
+// DiskQueue is a first in first out queue of sample batches kept as one file per batch in a
+// directory, so it survives restarts. When it holds more than MaxBytes the oldest batches are
+// evicted, always keeping the newest.
+type DiskQueue struct {
+	MaxBytes int64
+
+	dir     string
+	entries []queueEntry
+	bytes   int64
+	next    uint64
+	evicted int
+}
+
+// NewDiskQueue opens the queue in dir, creating it if needed and picking up any batches left
+// by an earlier run
+func NewDiskQueue(dir string, maxBytes int64) (*DiskQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &DiskQueue{MaxBytes: maxBytes, dir: dir}
+	// ReadDir sorts by name and names are zero padded, so this is oldest first
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// a write interrupted before its rename
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+		q.entries = append(q.entries, queueEntry{seq: seq, size: info.Size()})
+		q.bytes += info.Size()
+		q.next = seq + 1
+	}
+	return q, nil
+}
+
+func (q *DiskQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+// Len is the number of batches queued
+func (q *DiskQueue) Len() int {
+	return len(q.entries)
+}
+
+// Bytes is the size on disk of the queued batches
+func (q *DiskQueue) Bytes() int64 {
+	return q.bytes
+}
+
+// Evicted is the number of batches dropped to stay under MaxBytes
+func (q *DiskQueue) Evicted() int {
+	return q.evicted
+}
+
+// Push adds a batch to the end of the queue
+func (q *DiskQueue) Push(samples []purpleair.SensorSample) error {
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return err
+	}
+	seq := q.next
+	// write then rename so a crash doesn't leave a truncated batch
+	tmp := q.path(seq) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path(seq)); err != nil {
+		return err
+	}
+	q.next++
+	q.entries = append(q.entries, queueEntry{seq: seq, size: int64(len(data))})
+	q.bytes += int64(len(data))
+	for q.MaxBytes > 0 && q.bytes > q.MaxBytes && len(q.entries) > 1 {
+		if err := q.Pop(); err != nil {
+			return err
+		}
+		q.evicted++
+	}
+	return nil
+}
+
+// Peek reads the oldest batch without removing it. It returns nil when the queue is empty.
+func (q *DiskQueue) Peek() ([]purpleair.SensorSample, error) {
+	if len(q.entries) == 0 {
+		return nil, nil
+	}
+	data, err := os.ReadFile(q.path(q.entries[0].seq))
+	if err != nil {
+		return nil, err
+	}
+	var samples []purpleair.SensorSample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return nil, fmt.Errorf("queued batch %s: %s", q.path(q.entries[0].seq), err)
+	}
+	return samples, nil
+}
+
+// Pop removes the oldest batch
+func (q *DiskQueue) Pop() error {
+	if len(q.entries) == 0 {
+		return nil
+	}
+	e := q.entries[0]
+	if err := os.Remove(q.path(e.seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	q.entries = q.entries[1:]
+	q.bytes -= e.size
+	return nil
+}
+
+// QueuedSink puts a DiskQueue in front of a sink. Write only adds the batch to the queue, and
+// Flush replays queued batches to the sink in order, removing each once the sink has written and
+// flushed it. If the sink fails the rest stay queued for the next Flush, or the next run. A
+// batch the sink rejects with a permanent error, see IsPermanent, is dropped instead, so it
+// doesn't hold up the batches after it, and reported once the rest are flushed.
+type QueuedSink struct {
+	queue   *DiskQueue
+	sink    Sink
+	opened  bool
+	dropped int
+}
+
+func NewQueuedSink(s Sink, queue *DiskQueue) *QueuedSink {
+	return &QueuedSink{queue: queue, sink: s}
+}
+
+func (s *QueuedSink) Queue() *DiskQueue {
+	return s.queue
+}
+
+// Dropped is the number of batches the sink rejected with a permanent error
+func (s *QueuedSink) Dropped() int {
+	return s.dropped
+}
+
+// Open opens the sink. A sink that's down is left for Flush to open, and its error to report,
+// so batches are queued meanwhile.
+func (s *QueuedSink) Open() error {
+	if s.sink.Open() == nil {
+		s.opened = true
+	}
+	return nil
+}
+
+func (s *QueuedSink) Write(samples []purpleair.SensorSample) error {
+	return s.queue.Push(samples)
+}
+
+func (s *QueuedSink) Flush() error {
+	if !s.opened && s.queue.Len() > 0 {
+		if err := s.sink.Open(); err != nil {
+			return fmt.Errorf("%d batches queued: %w", s.queue.Len(), err)
+		}
+		s.opened = true
+	}
+	var dropped error
+	for s.queue.Len() > 0 {
+		samples, err := s.queue.Peek()
+		if err != nil {
+			// an unreadable batch would block the queue forever
+			s.queue.Pop()
+			return err
+		}
+		err = s.sink.Write(samples)
+		if err == nil {
+			err = s.sink.Flush()
+		}
+		if err != nil && !IsPermanent(err) {
+			return fmt.Errorf("%d batches queued: %w", s.queue.Len(), err)
+		}
+		if err := s.queue.Pop(); err != nil {
+			return err
+		}
+		if err != nil {
+			s.dropped++
+			dropped = fmt.Errorf("dropped a batch of %d samples: %w", len(samples), err)
+		}
+	}
+	return dropped
+}
+
+// Close replays what it can and closes the sink. Anything still queued is left on disk.
+func (s *QueuedSink) Close() error {
+	err := s.Flush()
+	if cerr := s.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
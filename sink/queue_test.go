@@ -0,0 +1,131 @@
+package sink
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+	"github.com/stretchr/testify/assert"
+)
+
+func batch(t int64) []purpleair.SensorSample {
+	s := purpleair.NewSensorSample(15111, time.Unix(t, 0).UTC())
+	s.Fields["pm2.5"] = 8.7
+	return []purpleair.SensorSample{*s}
+}
+
+func TestQueuedSinkReplay(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 0)
+	assert.Nil(t, err)
+	inner := &memorySink{err: errors.New("connection refused")}
+	s := NewQueuedSink(inner, q)
+	// a sink that's down when opened still has its samples queued
+	assert.Nil(t, s.Open())
+	assert.Nil(t, s.Write(batch(1664170800)))
+	assert.NotNil(t, s.Flush())
+	assert.Nil(t, s.Write(batch(1664170860)))
+	assert.NotNil(t, s.Close())
+	assert.Equal(t, 2, q.Len())
+
+	// after a restart the queue is picked up and replayed oldest first
+	q, err = NewDiskQueue(dir, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, q.Len())
+	inner = &memorySink{}
+	s = NewQueuedSink(inner, q)
+	assert.Nil(t, s.Open())
+	assert.Nil(t, s.Write(batch(1664170920)))
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, int64(0), q.Bytes())
+	assert.Equal(t, 3, len(inner.samples))
+	for i, sample := range inner.samples {
+		assert.Equal(t, time.Unix(1664170800+60*int64(i), 0).UTC(), sample.Time)
+	}
+	files, _ := os.ReadDir(dir)
+	assert.Empty(t, files)
+}
+
+// rejectSink rejects the batch at reject, as influx does one with a field type conflict
+type rejectSink struct {
+	memorySink
+	reject time.Time
+}
+
+func (r *rejectSink) Write(samples []purpleair.SensorSample) error {
+	if samples[0].Time.Equal(r.reject) {
+		return InfluxError{StatusCode: 400, Message: "field type conflict"}
+	}
+	return r.memorySink.Write(samples)
+}
+
+func TestQueuedSinkPermanentError(t *testing.T) {
+	q, err := NewDiskQueue(t.TempDir(), 0)
+	assert.Nil(t, err)
+	inner := &rejectSink{reject: time.Unix(1664170800, 0).UTC()}
+	s := NewQueuedSink(inner, q)
+	assert.Nil(t, s.Open())
+	assert.Nil(t, s.Write(batch(1664170800)))
+	assert.Nil(t, s.Write(batch(1664170860)))
+	// the rejected batch is dropped and reported, and doesn't hold up the one after it
+	err = s.Flush()
+	assert.True(t, IsPermanent(err))
+	assert.ErrorContains(t, err, "dropped a batch of 1 samples")
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, 1, s.Dropped())
+	assert.Equal(t, 1, len(inner.samples))
+	assert.Equal(t, time.Unix(1664170860, 0).UTC(), inner.samples[0].Time)
+
+	assert.False(t, IsPermanent(InfluxError{StatusCode: 429}))
+	assert.False(t, IsPermanent(InfluxError{StatusCode: 503}))
+	assert.False(t, IsPermanent(errors.New("connection refused")))
+	assert.True(t, IsPermanent(RemoteWriteError{StatusCode: 400}))
+}
+
+func TestDiskQueueEviction(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, q.Push(batch(1664170800)))
+	size := q.Bytes()
+
+	q.MaxBytes = 3 * size
+	for i := int64(1); i < 5; i++ {
+		assert.Nil(t, q.Push(batch(1664170800+60*i)))
+	}
+	assert.Equal(t, 3, q.Len())
+	assert.Equal(t, 2, q.Evicted())
+	samples, err := q.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Unix(1664170920, 0).UTC(), samples[0].Time)
+
+	// the newest batch is kept even if it's over the limit by itself
+	q.MaxBytes = 1
+	assert.Nil(t, q.Push(batch(1664171100)))
+	assert.Equal(t, 1, q.Len())
+	samples, _ = q.Peek()
+	assert.Equal(t, time.Unix(1664171100, 0).UTC(), samples[0].Time)
+}
+
+func TestDiskQueueCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	q, _ := NewDiskQueue(dir, 0)
+	q.Push(batch(1664170800))
+	q.Push(batch(1664170860))
+	os.WriteFile(q.path(0), []byte("{not json"), 0644)
+	os.WriteFile(q.path(9)+".tmp", []byte("partial"), 0644)
+
+	q, err := NewDiskQueue(dir, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, q.Len())
+	inner := &memorySink{}
+	s := NewQueuedSink(inner, q)
+	assert.NotNil(t, s.Flush()) // the corrupt batch is reported and dropped
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, 1, len(inner.samples))
+	files, _ := os.ReadDir(dir)
+	assert.Empty(t, files)
+}
@@ -0,0 +1,312 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+func init() {
+	Register("remote_write", newRemoteWriteSinkFromOptions)
+}
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+type promSample struct {
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+type timeSeries struct {
+	labels  []promLabel // sorted by name
+	samples []promSample
+}
+
+// protobuf wire format, enough for a remote_write WriteRequest
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|2)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// encodeWriteRequest encodes
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label { string name = 1; string value = 2; }
+//	Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []timeSeries) []byte {
+	var req, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = appendBytesField(msg[:0], 1, []byte(l.name))
+			msg = appendBytesField(msg, 2, []byte(l.value))
+			ts = appendBytesField(ts, 1, msg)
+		}
+		for _, p := range s.samples {
+			msg = append(msg[:0], 1<<3|1)
+			msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(p.value))
+			msg = append(msg, 2<<3|0)
+			msg = appendVarint(msg, uint64(p.timestamp))
+			ts = appendBytesField(ts, 2, msg)
+		}
+		req = appendBytesField(req, 1, ts)
+	}
+	return req
+}
+
+// labelName makes a valid Prometheus label name from a tag
+func labelName(tag string) string {
+	b := []byte(tag)
+	for i, c := range b {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || (i > 0 && c >= '0' && c <= '9')) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// RemoteWriteError is a write the receiver answered with a 4xx or 5xx
+type RemoteWriteError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e RemoteWriteError) Error() string {
+	return fmt.Sprintf("remote_write returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Permanent is true for a batch the receiver rejected, such as an out of order sample
+func (e RemoteWriteError) Permanent() bool {
+	return permanentStatus(e.StatusCode)
+}
+
+// RemoteWriteSink pushes samples to a Prometheus remote_write receiver such as
+// VictoriaMetrics, Mimir or Prometheus itself. Each field is a series named as the exporter
+// names it, labelled with sensor_index, the sample's tags and the sink's Labels, at the sample's
+// time. Samples are buffered until Flush. A request that fails with a 5xx, a 429 or a network
+// error is retried with backoff, as the remote_write spec asks; other errors, and running out of
+// retries, drop the batch and return the error.
+type RemoteWriteSink struct {
+	Labels     map[string]string
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	HTTPClient *http.Client
+
+	url      string
+	username string
+	password string
+	token    string
+	sleep    func(time.Duration)
+	series   map[string]*timeSeries
+}
+
+func NewRemoteWriteSink(url string, labels map[string]string) *RemoteWriteSink {
+	return &RemoteWriteSink{
+		Labels:     labels,
+		MaxRetries: 5,
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: 5 * time.Second,
+		url:        url,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		sleep:      time.Sleep,
+		series:     make(map[string]*timeSeries),
+	}
+}
+
+// options: url, username and password for basic auth or bearer_token, label.<name>=<value> for
+// static labels, max_retries, min_backoff, max_backoff and timeout
+func newRemoteWriteSinkFromOptions(options map[string]string) (Sink, error) {
+	url := options["url"]
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("remote_write sink requires an http(s) url option")
+	}
+	labels := make(map[string]string)
+	for k, v := range options {
+		if strings.HasPrefix(k, "label.") {
+			labels[labelName(strings.TrimPrefix(k, "label."))] = v
+		}
+	}
+	s := NewRemoteWriteSink(url, labels)
+	s.username = options["username"]
+	s.password = options["password"]
+	s.token = options["bearer_token"]
+	if v, ok := options["max_retries"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("remote_write sink option max_retries must be a non-negative integer")
+		}
+		s.MaxRetries = n
+	}
+	for option, d := range map[string]*time.Duration{
+		"min_backoff": &s.MinBackoff,
+		"max_backoff": &s.MaxBackoff,
+		"timeout":     &s.HTTPClient.Timeout,
+	} {
+		if v, ok := options[option]; ok {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("remote_write sink option %s: %s", option, err)
+			}
+			*d = parsed
+		}
+	}
+	return s, nil
+}
+
+func (s *RemoteWriteSink) Open() error {
+	return nil
+}
+
+func (s *RemoteWriteSink) add(labels []promLabel, p promSample) {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	var key strings.Builder
+	for _, l := range labels {
+		key.WriteString(l.name)
+		key.WriteByte(0)
+		key.WriteString(l.value)
+		key.WriteByte(0)
+	}
+	ts, ok := s.series[key.String()]
+	if !ok {
+		ts = &timeSeries{labels: labels}
+		s.series[key.String()] = ts
+	}
+	ts.samples = append(ts.samples, p)
+}
+
+func (s *RemoteWriteSink) Write(samples []purpleair.SensorSample) error {
+	for _, sample := range samples {
+		common := make(map[string]string, len(s.Labels)+len(sample.Tags)+1)
+		for k, v := range s.Labels {
+			common[k] = v
+		}
+		for k, v := range sample.Tags {
+			common[labelName(k)] = v
+		}
+		common["sensor_index"] = strconv.Itoa(sample.SensorIndex)
+		fields := make(map[string]float64, len(sample.Fields)+2)
+		for k, v := range sample.Fields {
+			fields[k] = v
+		}
+		if v, ok := sample.Fields["pm2.5_alt"]; ok {
+			fields["aqi_epa"] = float64(purpleair.Pm25ToAqi(v))
+		}
+		if v, ok := sample.Fields["pm2.5"]; ok {
+			fields["aqi_raw"] = float64(purpleair.Pm25ToAqi(v))
+		}
+		ts := sample.Time.UnixNano() / int64(time.Millisecond)
+		for field, v := range fields {
+			labels := make([]promLabel, 0, len(common)+1)
+			labels = append(labels, promLabel{name: "__name__", value: purpleair.MetricName(field)})
+			for k, lv := range common {
+				// empty labels are the same as no label
+				if lv != "" {
+					labels = append(labels, promLabel{name: k, value: lv})
+				}
+			}
+			s.add(labels, promSample{value: v, timestamp: ts})
+		}
+	}
+	return nil
+}
+
+func (s *RemoteWriteSink) send(body []byte) (retry bool, wait time.Duration, err error) {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "purpleair-api-go")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	} else if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, 0, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	err = RemoteWriteError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil {
+			wait = time.Duration(secs) * time.Second
+		}
+		return true, wait, err
+	}
+	return resp.StatusCode >= 500, 0, err
+}
+
+// Flush sends everything written since the last Flush as one request
+func (s *RemoteWriteSink) Flush() error {
+	if len(s.series) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(s.series))
+	for k := range s.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	series := make([]timeSeries, 0, len(s.series))
+	for _, k := range keys {
+		ts := s.series[k]
+		sort.Slice(ts.samples, func(i, j int) bool { return ts.samples[i].timestamp < ts.samples[j].timestamp })
+		series = append(series, *ts)
+	}
+	s.series = make(map[string]*timeSeries)
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+
+	backoff := s.MinBackoff
+	for attempt := 0; ; attempt++ {
+		retry, wait, err := s.send(body)
+		if err == nil || !retry || attempt >= s.MaxRetries {
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+			if backoff > s.MaxBackoff {
+				backoff = s.MaxBackoff
+			}
+		}
+		// Flush holds up polling, so a receiver's Retry-After is capped too
+		if wait > s.MaxBackoff {
+			wait = s.MaxBackoff
+		}
+		s.sleep(wait)
+	}
+}
+
+func (s *RemoteWriteSink) Close() error {
+	return s.Flush()
+}
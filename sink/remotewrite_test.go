@@ -0,0 +1,207 @@
+package sink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+)
+
+// readField reads one protobuf field, returning its number, and its bytes for length
+// delimited fields or its value for varint and fixed64 fields
+func readField(t *testing.T, b []byte) (int, []byte, uint64, []byte) {
+	key, n := binary.Uvarint(b)
+	b = b[n:]
+	switch key & 7 {
+	case 0:
+		v, n := binary.Uvarint(b)
+		return int(key >> 3), nil, v, b[n:]
+	case 1:
+		return int(key >> 3), nil, binary.LittleEndian.Uint64(b), b[8:]
+	case 2:
+		l, n := binary.Uvarint(b)
+		return int(key >> 3), b[n : n+int(l)], 0, b[n+int(l):]
+	}
+	t.Fatalf("unexpected wire type %d", key&7)
+	return 0, nil, 0, nil
+}
+
+// decodeWriteRequest turns a WriteRequest into lines of name{labels} value timestamp
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	var lines []string
+	for len(b) > 0 {
+		_, ts, _, rest := readField(t, b)
+		b = rest
+		var name string
+		var labels []string
+		var samples []string
+		for len(ts) > 0 {
+			field, msg, _, rest := readField(t, ts)
+			ts = rest
+			if field == 1 {
+				_, k, _, rest := readField(t, msg)
+				_, v, _, _ := readField(t, rest)
+				if string(k) == "__name__" {
+					name = string(v)
+				} else {
+					labels = append(labels, fmt.Sprintf("%s=%q", k, v))
+				}
+			} else {
+				_, _, bits, rest := readField(t, msg)
+				_, _, ms, _ := readField(t, rest)
+				samples = append(samples, fmt.Sprintf("%g %d", math.Float64frombits(bits), ms))
+			}
+		}
+		for _, s := range samples {
+			lines = append(lines, name+"{"+strings.Join(labels, ",")+"} "+s)
+		}
+	}
+	return lines
+}
+
+func setupRemoteWriteServer(t *testing.T, statuses []int) (*httptest.Server, *[][]string) {
+	var requests [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		assert.Nil(t, err)
+		requests = append(requests, decodeWriteRequest(t, body))
+		status := http.StatusNoContent
+		if len(requests) <= len(statuses) {
+			status = statuses[len(requests)-1]
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "2")
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("out of order sample"))
+	}))
+	return server, &requests
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	server, requests := setupRemoteWriteServer(t, nil)
+	defer server.Close()
+
+	s, err := New("remote_write", map[string]string{"url": server.URL, "label.location": "home"})
+	assert.Nil(t, err)
+	assert.Nil(t, s.Open())
+	later := testSamples()
+	later[0].Time = later[0].Time.Add(time.Minute)
+	later[0].Fields["pm2.5"] = 9.1
+	// written out of order, sent in order
+	assert.Nil(t, s.Write(later))
+	assert.Nil(t, s.Write(testSamples()))
+	assert.Equal(t, 0, len(*requests))
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, 1, len(*requests))
+	lines := (*requests)[0]
+	sort.Strings(lines)
+	labels := `{location="home",name="Backyard, North",sensor_index="15111"}`
+	assert.Equal(t, []string{
+		"purpleair_aqi_raw" + labels + " 36 1664170800000",
+		"purpleair_aqi_raw" + labels + " 38 1664170860000",
+		"purpleair_humidity" + labels + " 43 1664170800000",
+		"purpleair_humidity" + labels + " 43 1664170860000",
+		"purpleair_pm2_5" + labels + " 8.7 1664170800000",
+		"purpleair_pm2_5" + labels + " 9.1 1664170860000",
+	}, lines)
+
+	// nothing buffered, nothing sent
+	assert.Nil(t, s.Close())
+	assert.Equal(t, 1, len(*requests))
+}
+
+func TestRemoteWriteSinkRetry(t *testing.T) {
+	server, requests := setupRemoteWriteServer(t, []int{http.StatusServiceUnavailable, http.StatusTooManyRequests})
+	defer server.Close()
+	s := NewRemoteWriteSink(server.URL, nil)
+	var waits []time.Duration
+	s.sleep = func(d time.Duration) { waits = append(waits, d) }
+	s.Write(testSamples())
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, 3, len(*requests))
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 2 * time.Second}, waits)
+
+	// a 4xx isn't retried and the batch is dropped
+	server2, requests2 := setupRemoteWriteServer(t, []int{http.StatusBadRequest})
+	defer server2.Close()
+	s = NewRemoteWriteSink(server2.URL, nil)
+	s.sleep = func(d time.Duration) { t.Error("unexpected retry") }
+	s.Write(testSamples())
+	var rwe RemoteWriteError
+	assert.True(t, errors.As(s.Flush(), &rwe))
+	assert.Equal(t, http.StatusBadRequest, rwe.StatusCode)
+	assert.Equal(t, "out of order sample", rwe.Message)
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, 1, len(*requests2))
+
+	// retries run out
+	server3, requests3 := setupRemoteWriteServer(t, []int{500, 500, 500, 500})
+	defer server3.Close()
+	s = NewRemoteWriteSink(server3.URL, nil)
+	s.MaxRetries = 2
+	waits = nil
+	s.sleep = func(d time.Duration) { waits = append(waits, d) }
+	s.Write(testSamples())
+	assert.NotNil(t, s.Flush())
+	assert.Equal(t, 3, len(*requests3))
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, waits)
+}
+
+func TestRemoteWriteSinkRetryAfter(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	s := NewRemoteWriteSink(server.URL, nil)
+	var waits []time.Duration
+	s.sleep = func(d time.Duration) { waits = append(waits, d) }
+	s.Write(testSamples())
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, []time.Duration{s.MaxBackoff}, waits)
+}
+
+func TestRemoteWriteSinkOptions(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+	s, err := New("remote_write", map[string]string{"url": server.URL, "bearer_token": "secret"})
+	assert.Nil(t, err)
+	s.Write(testSamples())
+	assert.Nil(t, s.Flush())
+	assert.Equal(t, "Bearer secret", auth)
+
+	for _, options := range []map[string]string{
+		{},
+		{"url": "localhost:8428"},
+		{"url": server.URL, "max_retries": "-1"},
+		{"url": server.URL, "min_backoff": "fast"},
+	} {
+		_, err := New("remote_write", options)
+		assert.NotNil(t, err, options)
+	}
+}
@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+)
+
+// Sink is an output for samples. Open is called once before the first Write, Write may
+// buffer, Flush pushes anything buffered to the destination, and Close flushes and releases
+// the sink. Implementations don't need to be safe for concurrent use.
+type Sink interface {
+	Open() error
+	Write(samples []purpleair.SensorSample) error
+	Flush() error
+	Close() error
+}
+
+// IsPermanent reports whether err, or an error it wraps, is a write the destination rejected
+// in a way that writing the same samples again won't fix, such as a malformed batch
+func IsPermanent(err error) bool {
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// permanentStatus is whether an http status rejects the request itself: a 4xx, except auth
+// and not found errors which are fixed by setting the sink up again, and timeouts and 429s
+func permanentStatus(code int) bool {
+	switch code {
+	case 401, 403, 404, 408, 429:
+		return false
+	}
+	return code >= 400 && code < 500
+}
+
+// Factory makes a sink from its options, as given in a sink spec or config file
+type Factory func(options map[string]string) (Sink, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a kind of sink available to New. Sinks in this package register
+// themselves; other packages can add their own from an init function.
+func Register(kind string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[kind] = f
+}
+
+// Kinds lists the registered kinds of sink
+func Kinds() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	kinds := make([]string, 0, len(factories))
+	for k := range factories {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func New(kind string, options map[string]string) (Sink, error) {
+	factoriesMu.RLock()
+	f, ok := factories[kind]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %s, expected one of %s", kind, strings.Join(Kinds(), ", "))
+	}
+	return f(options)
+}
+
+// ParseSpec splits a sink spec like "influx:host=localhost,port=8086" into its kind and options
+func ParseSpec(spec string) (string, map[string]string, error) {
+	kind, opts, _ := strings.Cut(spec, ":")
+	if kind == "" {
+		return "", nil, fmt.Errorf("sink spec %q has no kind", spec)
+	}
+	options := make(map[string]string)
+	if opts == "" {
+		return kind, options, nil
+	}
+	for _, kv := range strings.Split(opts, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return "", nil, fmt.Errorf("sink spec %q option %q must be key=value", spec, kv)
+		}
+		options[k] = v
+	}
+	return kind, options, nil
+}
@@ -0,0 +1,141 @@
+package sink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/poynting/purpleair-api-go/purpleair"
+	"github.com/stretchr/testify/assert"
+)
+
+type memorySink struct {
+	samples []purpleair.SensorSample
+	err     error
+	opened  bool
+	closed  bool
+}
+
+func (m *memorySink) Open() error {
+	m.opened = true
+	return m.err
+}
+
+func (m *memorySink) Write(samples []purpleair.SensorSample) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.samples = append(m.samples, samples...)
+	return nil
+}
+
+func (m *memorySink) Flush() error {
+	return m.err
+}
+
+func (m *memorySink) Close() error {
+	m.closed = true
+	return nil
+}
+
+func testSamples() []purpleair.SensorSample {
+	s := purpleair.NewSensorSample(15111, time.Unix(1664170800, 0).UTC())
+	s.Fields["pm2.5"] = 8.7
+	s.Fields["humidity"] = 43
+	s.Tags["name"] = "Backyard, North"
+	return []purpleair.SensorSample{*s}
+}
+
+func TestParseSpec(t *testing.T) {
+	kind, options, err := ParseSpec("influx:host=localhost,port=8086,tag.location=home")
+	assert.Nil(t, err)
+	assert.Equal(t, "influx", kind)
+	assert.Equal(t, map[string]string{"host": "localhost", "port": "8086", "tag.location": "home"}, options)
+
+	kind, options, err = ParseSpec("json")
+	assert.Nil(t, err)
+	assert.Equal(t, "json", kind)
+	assert.Empty(t, options)
+
+	_, _, err = ParseSpec("influx:host")
+	assert.NotNil(t, err)
+	_, _, err = ParseSpec(":host=a")
+	assert.NotNil(t, err)
+}
+
+func TestRegistry(t *testing.T) {
+	Register("memory", func(options map[string]string) (Sink, error) {
+		return &memorySink{}, nil
+	})
+	assert.Contains(t, Kinds(), "memory")
+	assert.Contains(t, Kinds(), "json")
+	assert.Contains(t, Kinds(), "influx")
+	s, err := New("memory", nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, s)
+	_, err = New("carrier-pigeon", nil)
+	assert.NotNil(t, err)
+	_, err = New("influx", map[string]string{"host": "localhost"})
+	assert.NotNil(t, err) // no database
+}
+
+func TestFanout(t *testing.T) {
+	good := &memorySink{}
+	bad := &memorySink{err: fmt.Errorf("connection refused")}
+	other := &memorySink{}
+	f := NewFanout()
+	f.Add("good", good)
+	f.Add("bad", bad)
+	f.Add("other", other)
+	var reported []string
+	f.OnError = func(name string, err error) {
+		reported = append(reported, name)
+	}
+
+	err := f.Open()
+	assert.True(t, good.opened && bad.opened && other.opened)
+	assert.NotNil(t, err)
+
+	err = f.Write(testSamples())
+	var fe *FanoutError
+	assert.True(t, errors.As(err, &fe))
+	assert.Equal(t, 1, len(fe.Failed))
+	assert.Equal(t, "bad", fe.Failed[0].Sink)
+	assert.Equal(t, 1, len(good.samples))
+	assert.Equal(t, 1, len(other.samples)) // a failing sink doesn't stop the ones after it
+	assert.Equal(t, []string{"bad", "bad"}, reported)
+
+	assert.Nil(t, NewFanout().Write(testSamples()))
+
+	// a sink that failed to open is opened again, and written once it opens
+	bad.err = nil
+	bad.opened = false
+	assert.Nil(t, f.Write(testSamples()))
+	assert.True(t, bad.opened)
+	assert.Equal(t, 1, len(bad.samples))
+	f.Close()
+	assert.True(t, good.closed && bad.closed && other.closed)
+
+	// a sink that never opened isn't closed
+	down := &memorySink{err: fmt.Errorf("connection refused")}
+	f = NewFanout()
+	f.Add("down", down)
+	f.Open()
+	assert.Nil(t, f.Close())
+	assert.False(t, down.closed)
+}
+
+func TestJSONSink(t *testing.T) {
+	var b bytes.Buffer
+	s := NewJSONSink(&b, false)
+	assert.Nil(t, s.Open())
+	assert.Nil(t, s.Write(testSamples()))
+	assert.JSONEq(t, `[{
+		"sensor_index": 15111,
+		"time": "2022-09-26T05:40:00Z",
+		"tags": {"name": "Backyard, North"},
+		"fields": {"pm2.5": 8.7, "humidity": 43}
+	}]`, b.String())
+}